@@ -4,6 +4,7 @@ import (
 	"math"
 
 	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/pkg/errors"
 )
 
 /*
@@ -68,12 +69,22 @@ func (c *CholeskyDecomposition) GetL() *Matrix {
 @exception  RuntimeException  Matrix is not symmetric positive definite.
 */
 func (c *CholeskyDecomposition) Solve(B *Matrix) *Matrix {
-	if B.RowsDimension() != c.N {
+	x, err := c.TrySolve(B)
+	if err != nil {
+		logger.Log(err.Error())
 		return &Matrix{}
 	}
+	return x
+}
+
+// TrySolve returns X so that L*L'*X = B, ErrDimensionMismatch or
+// ErrSingularMatrix if the matrix is not symmetric positive definite.
+func (c *CholeskyDecomposition) TrySolve(B *Matrix) (*Matrix, error) {
+	if B.RowsDimension() != c.N {
+		return nil, errors.Wrapf(ErrDimensionMismatch, "right hand side has %d rows for %d", B.RowsDimension(), c.N)
+	}
 	if !c.Isspd {
-		logger.Log("Matrix is not symmetric positive definite.")
-		return &Matrix{}
+		return nil, errors.Wrap(ErrSingularMatrix, "matrix is not symmetric positive definite")
 	}
 
 	// Copy right hand side.
@@ -111,5 +122,33 @@ func (c *CholeskyDecomposition) Solve(B *Matrix) *Matrix {
 	}
 
 	mat, _ := NewMatrixWithMatrix(X, c.N, nx)
-	return mat
+	return mat, nil
+}
+
+// forward returns L^-1 B.
+func (c *CholeskyDecomposition) forward(B *Matrix) *Matrix {
+	X := B.Copy()
+	for k := 0; k < c.N; k++ {
+		for j := 0; j < X.N; j++ {
+			for i := 0; i < k; i++ {
+				X.A[k][j] -= X.A[i][j] * c.L[k][i]
+			}
+			X.A[k][j] /= c.L[k][k]
+		}
+	}
+	return X
+}
+
+// backward returns L'^-1 B.
+func (c *CholeskyDecomposition) backward(B *Matrix) *Matrix {
+	X := B.Copy()
+	for k := c.N - 1; k >= 0; k-- {
+		for j := 0; j < X.N; j++ {
+			for i := k + 1; i < c.N; i++ {
+				X.A[k][j] -= X.A[i][j] * c.L[i][k]
+			}
+			X.A[k][j] /= c.L[k][k]
+		}
+	}
+	return X
 }
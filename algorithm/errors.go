@@ -0,0 +1,11 @@
+package algorithm
+
+import "github.com/pkg/errors"
+
+// The Try methods return these errors, wrapped with the details, where their
+// historical counterparts log and return an empty matrix.
+var (
+	ErrDimensionMismatch = errors.New("matrix dimensions do not agree")
+	ErrSingularMatrix    = errors.New("matrix is singular")
+	ErrAliasedMatrix     = errors.New("matrix shares elements with an operand")
+)
@@ -0,0 +1,38 @@
+package algorithm
+
+import (
+	"github.com/pkg/errors"
+)
+
+// GeneralizedSymmetricEigen returns the eigenvalues in increasing order and
+// the eigenvectors, in the columns of a matrix, of a v = λ b v with a
+// symmetric and b symmetric positive definite. With the Cholesky decomposition
+// b = L L', they are the eigenvalues of the symmetric matrix L^-1 a L'^-1 and
+// its eigenvectors y give v = L'^-1 y, so the eigenvalues are real and the
+// eigenvectors b-orthonormal: V' b V = I. It returns ErrSingularMatrix if b is
+// not positive definite.
+func GeneralizedSymmetricEigen(a, b *Matrix) ([]float64, *Matrix, error) {
+	if a.M != a.N || b.M != b.N || a.M != b.M {
+		return nil, nil, errors.Wrapf(ErrDimensionMismatch, "generalized eigenvalues of %dx%d and %dx%d matrices", a.M, a.N, b.M, b.N)
+	}
+	c := NewCholeskyDecomposition(symmetrize(b))
+	if !c.Isspd {
+		return nil, nil, errors.Wrap(ErrSingularMatrix, "matrix is not symmetric positive definite")
+	}
+	// L^-1 a L'^-1 = L^-1 (L^-1 a)' as a is symmetric
+	reduced := c.forward(c.forward(symmetrize(a)).Transpose())
+	e := reduced.SymEig()
+	return e.Getd(), c.backward(e.GetV()), nil
+}
+
+// symmetrize returns (m + m') / 2, m without the rounding errors breaking its
+// symmetry.
+func symmetrize(m *Matrix) *Matrix {
+	x := NewMatrix(m.M, m.N)
+	for i := 0; i < m.M; i++ {
+		for j := 0; j < m.N; j++ {
+			x.A[i][j] = (m.A[i][j] + m.A[j][i]) / 2
+		}
+	}
+	return x
+}
@@ -0,0 +1,154 @@
+package algorithm
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// lanczosTolerance is the residual, relative to the largest eigenvalue in
+	// magnitude, under which a Ritz pair is converged.
+	lanczosTolerance = 1e-10
+)
+
+// NewSymmetricEigenvalueDecomposition returns the eigenvalue decomposition of
+// the symmetric matrix m without testing its symmetry, m is replaced by
+// (m + m^T) / 2 to remove the rounding errors. The eigenvalues are sorted in
+// increasing order.
+func NewSymmetricEigenvalueDecomposition(m *Matrix) *EigenvalueDecomposition {
+	e := &EigenvalueDecomposition{N: m.N, IsSymmetric: true}
+	e.V = symmetrize(m).A
+	e.D = make([]float64, e.N)
+	e.E = make([]float64, e.N)
+	e.Tred2()
+	e.Tql2()
+	return e
+}
+
+// SymEig returns the eigenvalue decomposition of the symmetric matrix m, see
+// NewSymmetricEigenvalueDecomposition.
+func (m *Matrix) SymEig() *EigenvalueDecomposition {
+	return NewSymmetricEigenvalueDecomposition(m)
+}
+
+// TopEigen returns the k largest eigenvalues of the symmetric matrix m in
+// decreasing order and their eigenvectors in the columns of a n x k matrix.
+// They are computed by a Lanczos iteration with full reorthogonalization, the
+// Krylov subspace is doubled until the k Ritz pairs converge.
+func TopEigen(m *Matrix, k int) ([]float64, *Matrix, error) {
+	n := m.M
+	if m.N != n {
+		return nil, nil, errors.Wrapf(ErrDimensionMismatch, "eigenvalues of a %dx%d matrix", m.M, m.N)
+	}
+	if k < 1 || k > n {
+		return nil, nil, errors.Errorf("cannot compute %d eigenvalues of a %dx%d matrix", k, n, n)
+	}
+	steps := minInt(n, maxInt(2*k, k+20))
+	for {
+		values, vectors, converged := lanczos(m, k, steps)
+		if converged || steps == n {
+			return values, vectors, nil
+		}
+		steps = minInt(n, 2*steps)
+	}
+}
+
+// lanczos returns the k largest Ritz pairs of m in the Krylov subspace of
+// dimension steps and whether they are converged.
+func lanczos(m *Matrix, k, steps int) ([]float64, *Matrix, bool) {
+	n := m.M
+	r := rand.New(rand.NewSource(1))
+	// the rows of q are the Lanczos vectors
+	q := NewMatrix(steps, n)
+	alpha := make([]float64, steps)
+	beta := make([]float64, steps) // beta[j] couples q[j-1] and q[j]
+	randomUnitVector(r, q.A[0], nil)
+	w := NewMatrix(n, 1)
+	scale, residual := 0., 0.
+	for j := 0; j < steps; j++ {
+		m.TimesInto(newMatrixWithData(q.A[j], n, 1, 1), w)
+		alpha[j] = dotProduct(q.A[j], w.Data)
+		// full reorthogonalization, twice is enough
+		for pass := 0; pass < 2; pass++ {
+			for i := 0; i <= j; i++ {
+				orthogonalize(w.Data, q.A[i])
+			}
+		}
+		b := math.Sqrt(dotProduct(w.Data, w.Data))
+		scale = math.Max(scale, math.Max(math.Abs(alpha[j]), b))
+		if j+1 == steps {
+			residual = b
+			break
+		}
+		if b <= 1e-12*scale {
+			// invariant subspace, go on with a vector orthogonal to it
+			randomUnitVector(r, q.A[j+1], q.A[:j+1])
+			continue
+		}
+		beta[j+1] = b
+		for i, v := range w.Data {
+			q.A[j+1][i] = v / b
+		}
+	}
+
+	// eigenvalues of the tridiagonal matrix alpha, beta
+	t := &EigenvalueDecomposition{N: steps, IsSymmetric: true}
+	t.V = NewMatrix(steps, steps).A
+	for i := 0; i < steps; i++ {
+		t.V[i][i] = 1
+	}
+	t.D = append(make([]float64, 0, steps), alpha...)
+	t.E = append(make([]float64, 0, steps), beta...)
+	t.Tql2()
+
+	values := make([]float64, k)
+	s := NewMatrix(steps, k)
+	converged := true
+	largest := math.Max(math.Abs(t.D[0]), math.Abs(t.D[steps-1]))
+	for c := 0; c < k; c++ {
+		index := steps - 1 - c
+		values[c] = t.D[index]
+		for i := 0; i < steps; i++ {
+			s.A[i][c] = t.V[i][index]
+		}
+		if math.Abs(residual*t.V[steps-1][index]) > lanczosTolerance*largest {
+			converged = false
+		}
+	}
+	return values, q.TransposeTimesMatrix(s), converged
+}
+
+// randomUnitVector stores in v a random unit vector orthogonal to the rows of
+// basis.
+func randomUnitVector(r *rand.Rand, v []float64, basis [][]float64) {
+	for i := range v {
+		v[i] = r.NormFloat64()
+	}
+	for pass := 0; pass < 2; pass++ {
+		for _, b := range basis {
+			orthogonalize(v, b)
+		}
+	}
+	norm := math.Sqrt(dotProduct(v, v))
+	for i := range v {
+		v[i] /= norm
+	}
+}
+
+// orthogonalize removes from v its component along the unit vector u.
+func orthogonalize(v, u []float64) {
+	p := dotProduct(v, u)
+	for i := range v {
+		v[i] -= p * u[i]
+	}
+}
+
+func dotProduct(a, b []float64) float64 {
+	sum := 0.
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
@@ -2,6 +2,8 @@ package algorithm
 
 import (
 	"math"
+
+	"github.com/pkg/errors"
 )
 
 /** LU Decomposition.
@@ -221,12 +223,21 @@ func (lud *LUDecomposition) Det() float64 {
 */
 
 func (lud *LUDecomposition) Solve(b *Matrix) *Matrix {
+	x, err := lud.TrySolve(b)
+	if err != nil {
+		return &Matrix{}
+	}
+	return x
+}
 
+// TrySolve returns X so that L*U*X = B(piv,:), ErrDimensionMismatch or
+// ErrSingularMatrix if it cannot be computed.
+func (lud *LUDecomposition) TrySolve(b *Matrix) (*Matrix, error) {
 	if b.RowsDimension() != lud.M {
-		return &Matrix{}
+		return nil, errors.Wrapf(ErrDimensionMismatch, "right hand side has %d rows for %d", b.RowsDimension(), lud.M)
 	}
 	if !lud.IsNonsingular() {
-		return &Matrix{}
+		return nil, ErrSingularMatrix
 	}
 
 	// Copy right hand side with pivoting
@@ -252,5 +263,5 @@ func (lud *LUDecomposition) Solve(b *Matrix) *Matrix {
 			}
 		}
 	}
-	return xmat
+	return xmat, nil
 }
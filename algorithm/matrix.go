@@ -1,7 +1,11 @@
 package algorithm
 
 import (
+	"encoding/json"
+	"runtime"
 	"strconv"
+	"sync"
+	"unsafe"
 
 	"github.com/jeromelesaux/facerecognition/logger"
 	"github.com/pkg/errors"
@@ -61,23 +65,93 @@ import (
 @version 5 August 1998
 */
 type Matrix struct {
-	A [][]float64
+	A [][]float64 // rows of the matrix, views of Data
 	M int
 	N int
+	// Data holds the elements in row-major order, the row i starts at
+	// i*Stride. Stride is N unless the matrix is a view of a larger one.
+	Data   []float64
+	Stride int
 }
 
+var (
+	// blockSize is the side of the blocks of the multiplications, 3 blocks of
+	// 64x64 float64 fit in a 256KB cache.
+	blockSize = 64
+	// parallelThreshold is the number of multiply-adds under which a
+	// multiplication runs on a single goroutine.
+	parallelThreshold = 1 << 16
+)
+
 func NewMatrix(m, n int) *Matrix {
+	return newMatrixWithData(make([]float64, m*n), m, n, n)
+}
+
+// newMatrixWithData returns the m x n matrix of the elements data with rows
+// starting every stride elements.
+func newMatrixWithData(data []float64, m, n, stride int) *Matrix {
 	mat := &Matrix{
-		M: m,
-		N: n,
+		M:      m,
+		N:      n,
+		Data:   data,
+		Stride: stride,
 	}
 	mat.A = make([][]float64, m)
 	for i := 0; i < m; i++ {
-		mat.A[i] = make([]float64, n)
+		mat.A[i] = data[i*stride : i*stride+n : i*stride+n]
 	}
 	return mat
 }
 
+// View returns the submatrix m(i0:i1, j0:j1) sharing its elements with m.
+func (m *Matrix) View(i0, i1, j0, j1 int) *Matrix {
+	rows, columns := i1-i0+1, j1-j0+1
+	if rows <= 0 || columns <= 0 {
+		return NewMatrix(maxInt(rows, 0), maxInt(columns, 0))
+	}
+	offset := i0*m.Stride + j0
+	return newMatrixWithData(m.Data[offset:offset+(rows-1)*m.Stride+columns], rows, columns, m.Stride)
+}
+
+// Copy returns a copy of m with contiguous storage.
+func (m *Matrix) Copy() *Matrix {
+	x := NewMatrix(m.M, m.N)
+	for i := 0; i < m.M; i++ {
+		copy(x.A[i], m.A[i])
+	}
+	return x
+}
+
+// matrixJSON is the persisted form of a matrix, the rows and the dimensions.
+type matrixJSON struct {
+	A [][]float64
+	M int
+	N int
+}
+
+func (m Matrix) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&matrixJSON{A: m.A, M: m.M, N: m.N})
+}
+
+func (m *Matrix) UnmarshalJSON(data []byte) error {
+	mj := &matrixJSON{}
+	if err := json.Unmarshal(data, mj); err != nil {
+		return err
+	}
+	if len(mj.A) != mj.M {
+		return errors.Wrapf(ErrDimensionMismatch, "matrix has %d rows and M is %d", len(mj.A), mj.M)
+	}
+	x := NewMatrix(mj.M, mj.N)
+	for i, row := range mj.A {
+		if len(row) != mj.N {
+			return errors.Wrapf(ErrDimensionMismatch, "matrix row %d has %d columns and N is %d", i, len(row), mj.N)
+		}
+		copy(x.A[i], row)
+	}
+	*m = *x
+	return nil
+}
+
 func NewMatrixFilled(m, n, s int) *Matrix {
 	mat := NewMatrix(m, n)
 	for i := 0; i < m; i++ {
@@ -118,13 +192,26 @@ func (m *Matrix) Transpose() *Matrix {
 }
 
 func (m *Matrix) Plus(b *Matrix) *Matrix {
+	x, err := m.TryPlus(b)
+	if err != nil {
+		logger.Log(err.Error())
+		return &Matrix{}
+	}
+	return x
+}
+
+// TryPlus returns m + b or ErrDimensionMismatch.
+func (m *Matrix) TryPlus(b *Matrix) (*Matrix, error) {
+	if err := m.sameDimensions(b); err != nil {
+		return nil, err
+	}
 	x := NewMatrix(m.M, m.N)
 	for i := 0; i < m.M; i++ {
 		for j := 0; j < m.N; j++ {
 			x.A[i][j] = m.A[i][j] + b.A[i][j]
 		}
 	}
-	return x
+	return x, nil
 }
 
 func (m *Matrix) PlusEqual(b *Matrix) {
@@ -136,13 +223,48 @@ func (m *Matrix) PlusEqual(b *Matrix) {
 }
 
 func (m *Matrix) Minus(b *Matrix) *Matrix {
+	x, err := m.TryMinus(b)
+	if err != nil {
+		logger.Log(err.Error())
+		return &Matrix{}
+	}
+	return x
+}
+
+// TryMinus returns m - b or ErrDimensionMismatch.
+func (m *Matrix) TryMinus(b *Matrix) (*Matrix, error) {
+	if err := m.sameDimensions(b); err != nil {
+		return nil, err
+	}
 	x := NewMatrix(m.M, m.N)
 	for i := 0; i < m.M; i++ {
 		for j := 0; j < m.N; j++ {
 			x.A[i][j] = m.A[i][j] - b.A[i][j]
 		}
 	}
-	return x
+	return x, nil
+}
+
+// MinusInPlace stores m - b in m, it returns ErrDimensionMismatch if they do
+// not have the same dimensions.
+func (m *Matrix) MinusInPlace(b *Matrix) error {
+	if err := m.sameDimensions(b); err != nil {
+		return err
+	}
+	for i := 0; i < m.M; i++ {
+		mi, bi := m.A[i], b.A[i]
+		for j := range mi {
+			mi[j] -= bi[j]
+		}
+	}
+	return nil
+}
+
+func (m *Matrix) sameDimensions(b *Matrix) error {
+	if m.M != b.M || m.N != b.N {
+		return errors.Wrapf(ErrDimensionMismatch, "%dx%d and %dx%d", m.M, m.N, b.M, b.N)
+	}
+	return nil
 }
 
 func (m *Matrix) Tostring() string {
@@ -150,28 +272,147 @@ func (m *Matrix) Tostring() string {
 }
 
 func (m *Matrix) TimesMatrix(b *Matrix) *Matrix {
-	if b.M != m.N {
-		logger.Log("Matrix inner dimensions must agree")
+	x, err := m.TryTimesMatrix(b)
+	if err != nil {
+		logger.Log(err.Error())
 		return &Matrix{}
 	}
+	return x
+}
+
+// TryTimesMatrix returns m * b or ErrDimensionMismatch if the inner dimensions
+// do not agree.
+func (m *Matrix) TryTimesMatrix(b *Matrix) (*Matrix, error) {
 	x := NewMatrix(m.M, b.N)
-	Bcolj := make([]float64, m.N)
-	for j := 0; j < b.N; j++ {
-		for k := 0; k < m.N; k++ {
-			Bcolj[k] = b.A[k][j]
-		}
-		for i := 0; i < m.M; i++ {
-			Arowi := m.A[i]
-			s := 0.0
-			for k := 0; k < m.N; k++ {
-				s += Arowi[k] * Bcolj[k]
+	if err := m.TimesInto(b, x); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// TimesInto stores m * b in x, a m.M x b.N matrix sharing no element with m
+// and b, or returns ErrAliasedMatrix. The product is computed by blocks of rows
+// on several goroutines, each element is summed in the same order as the naive
+// product.
+func (m *Matrix) TimesInto(b, x *Matrix) error {
+	if b.M != m.N {
+		return errors.Wrapf(ErrDimensionMismatch, "inner dimensions %d and %d", m.N, b.M)
+	}
+	if x.M != m.M || x.N != b.N {
+		return errors.Wrapf(ErrDimensionMismatch, "product is %dx%d and gets a %dx%d matrix", m.M, b.N, x.M, x.N)
+	}
+	if overlaps(x.Data, m.Data) || overlaps(x.Data, b.Data) {
+		return errors.Wrap(ErrAliasedMatrix, "product matrix")
+	}
+	parallelRows(m.M, m.N*b.N, func(i0, i1 int) {
+		for ii := i0; ii < i1; ii += blockSize {
+			iEnd := minInt(ii+blockSize, i1)
+			for i := ii; i < iEnd; i++ {
+				xi := x.A[i]
+				for j := range xi {
+					xi[j] = 0
+				}
+			}
+			for kk := 0; kk < m.N; kk += blockSize {
+				kEnd := minInt(kk+blockSize, m.N)
+				for jj := 0; jj < b.N; jj += blockSize {
+					jEnd := minInt(jj+blockSize, b.N)
+					for i := ii; i < iEnd; i++ {
+						xi := x.A[i][jj:jEnd]
+						mi := m.A[i]
+						for k := kk; k < kEnd; k++ {
+							mik := mi[k]
+							bk := b.A[k][jj:jEnd]
+							for j := range xi {
+								xi[j] += mik * bk[j]
+							}
+						}
+					}
+				}
 			}
-			x.A[i][j] = s
 		}
+	})
+	return nil
+}
+
+// TransposeTimesMatrix returns m^T * b without computing the transpose of m.
+func (m *Matrix) TransposeTimesMatrix(b *Matrix) *Matrix {
+	x := NewMatrix(m.N, b.N)
+	if err := m.TransposeTimesInto(b, x); err != nil {
+		logger.Log(err.Error())
+		return &Matrix{}
 	}
 	return x
 }
 
+// TransposeTimesInto stores m^T * b in x, a m.N x b.N matrix sharing no
+// element with m and b, or returns ErrAliasedMatrix.
+func (m *Matrix) TransposeTimesInto(b, x *Matrix) error {
+	if b.M != m.M {
+		return errors.Wrapf(ErrDimensionMismatch, "inner dimensions %d and %d", m.M, b.M)
+	}
+	if x.M != m.N || x.N != b.N {
+		return errors.Wrapf(ErrDimensionMismatch, "product is %dx%d and gets a %dx%d matrix", m.N, b.N, x.M, x.N)
+	}
+	if overlaps(x.Data, m.Data) || overlaps(x.Data, b.Data) {
+		return errors.Wrap(ErrAliasedMatrix, "product matrix")
+	}
+	parallelRows(m.N, m.M*b.N, func(i0, i1 int) {
+		for ii := i0; ii < i1; ii += blockSize {
+			iEnd := minInt(ii+blockSize, i1)
+			for i := ii; i < iEnd; i++ {
+				xi := x.A[i]
+				for j := range xi {
+					xi[j] = 0
+				}
+			}
+			for k := 0; k < m.M; k++ {
+				mk, bk := m.A[k], b.A[k]
+				for i := ii; i < iEnd; i++ {
+					mki := mk[i]
+					xi := x.A[i]
+					for j := range xi {
+						xi[j] += mki * bk[j]
+					}
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// overlaps returns true if the elements of a and b share memory, as the ones of
+// a matrix and of its views.
+func overlaps(a, b []float64) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	size := unsafe.Sizeof(a[0])
+	a0, b0 := uintptr(unsafe.Pointer(&a[0])), uintptr(unsafe.Pointer(&b[0]))
+	return a0 < b0+uintptr(len(b))*size && b0 < a0+uintptr(len(a))*size
+}
+
+// parallelRows runs f on contiguous ranges of the rows 0 to rows - 1, on as
+// many goroutines as processors unless the work, the number of multiply-adds
+// per row, is small.
+func parallelRows(rows, work int, f func(i0, i1 int)) {
+	workers := minInt(runtime.GOMAXPROCS(0), rows)
+	if workers <= 1 || rows*work < parallelThreshold {
+		f(0, rows)
+		return
+	}
+	size := (rows + workers - 1) / workers
+	var wg sync.WaitGroup
+	for i0 := 0; i0 < rows; i0 += size {
+		wg.Add(1)
+		go func(i0, i1 int) {
+			defer wg.Done()
+			f(i0, i1)
+		}(i0, minInt(i0+size, rows))
+	}
+	wg.Wait()
+}
+
 func (m *Matrix) Times(s float64) *Matrix {
 	x := NewMatrix(m.M, m.N)
 	for i := 0; i < m.M; i++ {
@@ -232,16 +473,35 @@ func (m *Matrix) Eig() *EigenvalueDecomposition {
 	return NewEigenvalueDecomposition(m)
 }
 
+func (m *Matrix) SVD() *SingularValueDecomposition {
+	return NewSingularValueDecomposition(m)
+}
+
 func (m *Matrix) Inverse() *Matrix {
 	return m.Solve(m.Identity(m.M, m.M))
 }
 
+// TryInverse returns the inverse of m, ErrSingularMatrix if it has none.
+func (m *Matrix) TryInverse() (*Matrix, error) {
+	return m.TrySolve(m.Identity(m.M, m.M))
+}
+
 func (m *Matrix) Solve(b *Matrix) *Matrix {
+	x, err := m.TrySolve(b)
+	if err != nil {
+		logger.Log(err.Error())
+		return &Matrix{}
+	}
+	return x
+}
+
+// TrySolve returns the solution of m * x = b, the least squares solution if m
+// is not square.
+func (m *Matrix) TrySolve(b *Matrix) (*Matrix, error) {
 	if m.M == m.N {
-		return NewLUDecomposition(m).Solve(b)
-	} else {
-		return NewQRDecomposition(m).Solve(b)
+		return NewLUDecomposition(m).TrySolve(b)
 	}
+	return NewQRDecomposition(m).TrySolve(b)
 }
 
 func (mat *Matrix) Identity(m, n int) *Matrix {
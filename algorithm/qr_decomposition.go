@@ -2,6 +2,7 @@ package algorithm
 
 import (
 	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/pkg/errors"
 	"math"
 )
 
@@ -73,13 +74,22 @@ func (q *QRDecomposition) IsFullRank() bool {
 }
 
 func (q *QRDecomposition) Solve(b *Matrix) *Matrix {
-	if b.RowsDimension() != q.M {
-		logger.Log("Matrix row dimensions must agree.")
+	x, err := q.TrySolve(b)
+	if err != nil {
+		logger.Log(err.Error())
 		return &Matrix{}
 	}
+	return x
+}
+
+// TrySolve returns the least squares solution X of A*X = B, ErrDimensionMismatch
+// or ErrSingularMatrix if A is rank deficient.
+func (q *QRDecomposition) TrySolve(b *Matrix) (*Matrix, error) {
+	if b.RowsDimension() != q.M {
+		return nil, errors.Wrapf(ErrDimensionMismatch, "right hand side has %d rows for %d", b.RowsDimension(), q.M)
+	}
 	if !q.IsFullRank() {
-		logger.Log("Matrix is rank deficient.")
-		return &Matrix{}
+		return nil, errors.Wrap(ErrSingularMatrix, "matrix is rank deficient")
 	}
 	// Copy right hand side
 	nx := b.ColumnsDimension()
@@ -108,6 +118,6 @@ func (q *QRDecomposition) Solve(b *Matrix) *Matrix {
 		}
 	}
 	mat, _ := NewMatrixWithMatrix(b.A, q.N, nx)
-	return mat.GetMatrix3(0, q.N-1, 0, nx-1)
+	return mat.GetMatrix3(0, q.N-1, 0, nx-1), nil
 	//return (new Matrix(X, n, nx).getMatrix(0, n-1, 0, nx-1));
 }
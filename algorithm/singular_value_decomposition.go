@@ -53,20 +53,20 @@ type SingularValueDecomposition struct {
 
 func NewSingularValueDecomposition(matrix *Matrix) *SingularValueDecomposition {
 	s := &SingularValueDecomposition{M: matrix.RowsDimension(), N: matrix.ColumnsDimension()}
+	if s.M < s.N {
+		// the LINPACK algorithm needs m >= n, A^T = V S U^T gives the thin
+		// decomposition of A, U is m x m and V is n x m
+		t := NewSingularValueDecomposition(matrix.Transpose())
+		s.U, s.V, s.S = t.V, t.U, t.S
+		return s
+	}
 	// Derived from LINPACK code.
-	// Initialize.
-
-	// double[][] A = Arg.getArrayCopy();
-
-	/* Apparently the failing cases are only a proper subset of (m<n),
-	   so let's not throw error.  Correct fix to come later?
-	if (m<n) {
-	    throw new IllegalArgumentException("Jama SVD only works for m >= n"); }
-	*/
+	// Initialize, the matrix is left unchanged.
+	a := matrix.Copy().A
 	nu := minInt(s.M, s.N)
 	s.S = make([]float64, minInt(s.M+1, s.N))
 	s.U = make([][]float64, s.M)
-	for i := 0; i < minInt(s.M+1, s.N); i++ {
+	for i := 0; i < s.M; i++ {
 		s.U[i] = make([]float64, nu)
 	}
 	s.V = make([][]float64, s.N)
@@ -89,16 +89,16 @@ func NewSingularValueDecomposition(matrix *Matrix) *SingularValueDecomposition {
 			// Compute 2-norm of k-th column without under/overflow.
 			s.S[k] = 0
 			for i := k; i < s.M; i++ {
-				s.S[k] = math.Hypot(s.S[k], matrix.A[i][k])
+				s.S[k] = math.Hypot(s.S[k], a[i][k])
 			}
 			if s.S[k] != 0.0 {
-				if matrix.A[k][k] < 0.0 {
+				if a[k][k] < 0.0 {
 					s.S[k] = -s.S[k]
 				}
 				for i := k; i < s.M; i++ {
-					matrix.A[i][k] /= s.S[k]
+					a[i][k] /= s.S[k]
 				}
-				matrix.A[k][k] += 1.0
+				a[k][k] += 1.0
 			}
 			s.S[k] = -s.S[k]
 		}
@@ -107,23 +107,23 @@ func NewSingularValueDecomposition(matrix *Matrix) *SingularValueDecomposition {
 				// Apply the transformation.
 				t := 0.0
 				for i := k; i < s.M; i++ {
-					t += matrix.A[i][k] * matrix.A[i][j]
+					t += a[i][k] * a[i][j]
 				}
-				t = -t / matrix.A[k][k]
+				t = -t / a[k][k]
 				for i := k; i < s.M; i++ {
-					matrix.A[i][j] += t * matrix.A[i][k]
+					a[i][j] += t * a[i][k]
 				}
 			}
 			// Place the k-th row of A into e for the
 			// subsequent calculation of the row transformation.
 
-			e[j] = matrix.A[k][j]
+			e[j] = a[k][j]
 		}
 		if wantu && (k < nct) {
 			// Place the transformation in U for subsequent back
 			// multiplication.
 			for i := k; i < s.M; i++ {
-				s.U[i][k] = matrix.A[i][k]
+				s.U[i][k] = a[i][k]
 			}
 		}
 		if k < nrt {
@@ -152,13 +152,13 @@ func NewSingularValueDecomposition(matrix *Matrix) *SingularValueDecomposition {
 				}
 				for j := k + 1; j < s.N; j++ {
 					for i := k + 1; i < s.M; i++ {
-						work[i] += e[j] * matrix.A[i][j]
+						work[i] += e[j] * a[i][j]
 					}
 				}
 				for j := k + 1; j < s.N; j++ {
 					t := -e[j] / e[k+1]
 					for i := k + 1; i < s.M; i++ {
-						matrix.A[i][j] += t * work[i]
+						a[i][j] += t * work[i]
 					}
 				}
 			}
@@ -175,13 +175,13 @@ func NewSingularValueDecomposition(matrix *Matrix) *SingularValueDecomposition {
 	// Set up the final bidiagonal matrix or order p.
 	p := minInt(s.N, s.M+1)
 	if nct < s.N {
-		s.S[nct] = matrix.A[nct][nct]
+		s.S[nct] = a[nct][nct]
 	}
 	if s.M < p {
 		s.S[p-1] = 0.0
 	}
 	if nrt+1 < p {
-		e[nrt] = matrix.A[nrt][p-1]
+		e[nrt] = a[nrt][p-1]
 	}
 	e[p-1] = 0.0
 	// If required, generate U.
@@ -261,7 +261,7 @@ func NewSingularValueDecomposition(matrix *Matrix) *SingularValueDecomposition {
 		//              s(k), ..., s(p) are not negligible (qr step).
 		// kase = 4     if e(p-1) is negligible (convergence).
 
-		for k := p - 2; k >= -1; k-- {
+		for k = p - 2; k >= -1; k-- {
 			if k == -1 {
 				break
 			}
@@ -505,12 +505,9 @@ func (s *SingularValueDecomposition) GetSingularValues() []float64 {
 @return     S
 */
 func (s *SingularValueDecomposition) GetS() *Matrix {
-	X := NewMatrix(s.N, s.N)
-
-	for i := 0; i < s.N; i++ {
-		for j := 0; j < s.N; j++ {
-			X.A[i][j] = 0.0
-		}
+	n := minInt(s.M, s.N)
+	X := NewMatrix(n, n)
+	for i := 0; i < n; i++ {
 		X.A[i][i] = s.S[i]
 	}
 	return X
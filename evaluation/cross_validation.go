@@ -0,0 +1,117 @@
+package evaluation
+
+import (
+	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/jeromelesaux/facerecognition/model"
+	"github.com/pkg/errors"
+)
+
+type Options struct {
+	FeatureTypes      []string
+	FeatureParameters model.Parameters    // parameters of all the feature extractors evaluated
+	Preprocessing     model.Preprocessing // illumination normalization of the images, none if empty
+	Metrics           []string
+	Folds             int // leave one out if lower than 2 or greater than the number of images
+	K                 int
+	NumOfComponents   int // chosen by the feature extractor parameters if 0, n - c by default
+}
+
+func NewOptions() *Options {
+	return &Options{
+		FeatureTypes: []string{model.PCAFeatureType, model.LDAFeatureType, model.LPPFeatureType},
+		Metrics:      []string{model.L1Metric, model.EuclideanMetric, model.CosineDissimilarityMetric},
+		Folds:        0,
+		K:            1,
+	}
+}
+
+// CrossValidate evaluates each feature type with each metric on the dataset
+// and returns one report per couple. The folds are stratified : the images of
+// each identity are spread over all the folds.
+func CrossValidate(ds *Dataset, opts *Options) ([]*Report, error) {
+	if len(ds.Images) < 2 {
+		return nil, errors.New("at least two images are required for a cross validation")
+	}
+	for _, name := range opts.Metrics {
+		if _, err := model.NewMetric(name); err != nil {
+			return nil, err
+		}
+	}
+	folds := assignFolds(ds, opts.Folds)
+	numOfFolds := 0
+	for _, f := range folds {
+		if f+1 > numOfFolds {
+			numOfFolds = f + 1
+		}
+	}
+
+	reports := make([]*Report, 0)
+	for _, featureType := range opts.FeatureTypes {
+		featureReports := make(map[string]*Report)
+		for _, name := range opts.Metrics {
+			r := NewReport(featureType, name, numOfFolds, ds.Identities())
+			featureReports[name] = r
+			reports = append(reports, r)
+		}
+		for fold := 0; fold < numOfFolds; fold++ {
+			trainer, err := trainFold(ds, folds, fold, featureType, opts)
+			if err != nil {
+				logger.Logf("cannot train %s on fold %d : %v", featureType, fold, err)
+				for _, r := range featureReports {
+					r.Error = err.Error()
+				}
+				break
+			}
+			for _, name := range opts.Metrics {
+				trainer.SetMetric(name)
+				for i := range ds.Images {
+					if folds[i] != fold {
+						continue
+					}
+					recognized, _ := trainer.Recognize(ds.Images[i])
+					featureReports[name].Add(ds.Labels[i], recognized)
+				}
+			}
+		}
+		for _, r := range featureReports {
+			r.Compute()
+		}
+	}
+	return reports, nil
+}
+
+// assignFolds returns the fold of each image of the dataset.
+func assignFolds(ds *Dataset, numOfFolds int) []int {
+	folds := make([]int, len(ds.Images))
+	if numOfFolds < 2 || numOfFolds >= len(ds.Images) {
+		for i := range folds {
+			folds[i] = i
+		}
+		return folds
+	}
+	counters := make(map[string]int)
+	for i, label := range ds.Labels {
+		folds[i] = counters[label] % numOfFolds
+		counters[label]++
+	}
+	return folds
+}
+
+func trainFold(ds *Dataset, folds []int, fold int, featureType string, opts *Options) (*model.Trainer, error) {
+	t := model.NewTrainerArgs(featureType, opts.K, opts.NumOfComponents, nil)
+	t.FeatureParameters = opts.FeatureParameters
+	t.Preprocessing = opts.Preprocessing
+	t.Width, t.Height = ds.Width, ds.Height
+	for i := range ds.Images {
+		if folds[i] != fold {
+			t.AddWithSource(ds.Images[i], ds.Labels[i], ds.Sources[i])
+		}
+	}
+	if len(t.TrainingSet) <= opts.K {
+		return nil, errors.Errorf("fold %d has %d training images for K=%d", fold, len(t.TrainingSet), opts.K)
+	}
+	if err := t.Train(); err != nil {
+		return nil, errors.Wrapf(err, "fold %d", fold)
+	}
+	return t, nil
+}
@@ -0,0 +1,98 @@
+package evaluation
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/model"
+	"github.com/pkg/errors"
+)
+
+var imageExtensions = map[string]bool{
+	".pgm":  true,
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+}
+
+// Dataset is a labelled set of vectorized face images.
+type Dataset struct {
+	Images  []*algorithm.Matrix
+	Labels  []string
+	Sources []string
+	// Width and Height are the size of the images, 0 if unknown
+	Width  int
+	Height int
+}
+
+// LoadDataset reads a directory containing one sub directory of images per
+// identity, the sub directory name is the label of its images.
+func LoadDataset(directory string) (*Dataset, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read dataset directory %s", directory)
+	}
+	ds := &Dataset{
+		Images:  make([]*algorithm.Matrix, 0),
+		Labels:  make([]string, 0),
+		Sources: make([]string, 0),
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		identityDirectory := filepath.Join(directory, entry.Name())
+		files, err := os.ReadDir(identityDirectory)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read identity directory %s", identityDirectory)
+		}
+		for _, file := range files {
+			if file.IsDir() || !imageExtensions[strings.ToLower(filepath.Ext(file.Name()))] {
+				continue
+			}
+			path := filepath.Join(identityDirectory, file.Name())
+			m, err := model.ReadMatrix(path)
+			if err != nil {
+				return nil, err
+			}
+			if ds.Width == 0 {
+				ds.Width, ds.Height = m.N, m.M
+			}
+			ds.Add(m.Vectorize(), entry.Name(), path)
+		}
+	}
+	if len(ds.Images) == 0 {
+		return nil, errors.Errorf("no image found in dataset directory %s", directory)
+	}
+	for i := range ds.Images {
+		if ds.Images[i].M != ds.Images[0].M {
+			return nil, errors.Errorf("image %s has dimension %d and %s %d, all images must have the same size",
+				ds.Sources[i], ds.Images[i].M, ds.Sources[0], ds.Images[0].M)
+		}
+	}
+	return ds, nil
+}
+
+func (ds *Dataset) Add(m *algorithm.Matrix, label, source string) {
+	ds.Images = append(ds.Images, m)
+	ds.Labels = append(ds.Labels, label)
+	ds.Sources = append(ds.Sources, source)
+}
+
+// Identities returns the sorted labels of the dataset.
+func (ds *Dataset) Identities() []string {
+	set := make(map[string]bool)
+	for _, l := range ds.Labels {
+		set[l] = true
+	}
+	identities := make([]string, 0, len(set))
+	for l := range set {
+		identities = append(identities, l)
+	}
+	sort.Strings(identities)
+	return identities
+}
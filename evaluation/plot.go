@@ -0,0 +1,136 @@
+package evaluation
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+)
+
+var (
+	plotBackground = color.RGBA{255, 255, 255, 255}
+	plotAxis       = color.RGBA{0, 0, 0, 255}
+	plotGrid       = color.RGBA{220, 220, 220, 255}
+	plotEER        = color.RGBA{200, 200, 200, 255}
+	plotColors     = []color.RGBA{
+		{31, 119, 180, 255},
+		{255, 127, 14, 255},
+		{44, 160, 44, 255},
+		{214, 39, 40, 255},
+		{148, 103, 189, 255},
+		{140, 86, 75, 255},
+		{227, 119, 194, 255},
+		{127, 127, 127, 255},
+		{188, 189, 34, 255},
+	}
+)
+
+const (
+	plotMargin = 20
+	minLogFAR  = -4. // ROC x axis from FAR 1e-4 to 1
+	detLimit   = 3.  // DET axes from the normal deviate -3 to 3 (0.13% to 99.87%)
+)
+
+// PlotROC writes a PNG image of width x height with the ROC curves on the left
+// (TAR against log10 FAR from 1e-4 to 1) and the DET curves on the right (FRR
+// against FAR on normal deviate scales), one color per report in the order
+// of the reports.
+func PlotROC(w io.Writer, reports []*VerificationReport, width, height int) error {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{plotBackground}, image.Point{}, draw.Src)
+
+	roc := image.Rect(plotMargin, plotMargin, width/2-plotMargin/2, height-plotMargin)
+	det := image.Rect(width/2+plotMargin/2, plotMargin, width-plotMargin, height-plotMargin)
+	drawFrame(img, roc, 4, 10)
+	drawFrame(img, det, 6, 6)
+	// the EER points are on the diagonal of the DET
+	drawLine(img, det.Min.X, det.Max.Y, det.Max.X, det.Min.Y, plotEER)
+
+	for n, r := range reports {
+		c := plotColors[n%len(plotColors)]
+		prevROC, prevDET := image.Point{-1, -1}, image.Point{-1, -1}
+		for _, p := range r.Points {
+			if p.FAR > 0 {
+				x := (math.Max(math.Log10(p.FAR), minLogFAR) - minLogFAR) / -minLogFAR
+				cur := toPlot(roc, x, p.TAR)
+				if prevROC.X >= 0 {
+					drawLine(img, prevROC.X, prevROC.Y, cur.X, cur.Y, c)
+				}
+				prevROC = cur
+			}
+			x := (clamp(probit(p.FAR), -detLimit, detLimit) + detLimit) / (2 * detLimit)
+			y := (clamp(probit(p.FRR), -detLimit, detLimit) + detLimit) / (2 * detLimit)
+			cur := toPlot(det, x, y)
+			if prevDET.X >= 0 {
+				drawLine(img, prevDET.X, prevDET.Y, cur.X, cur.Y, c)
+			}
+			prevDET = cur
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// toPlot returns the pixel of the coordinates x and y in [0,1] in the area r.
+func toPlot(r image.Rectangle, x, y float64) image.Point {
+	return image.Point{
+		X: r.Min.X + int(math.Round(x*float64(r.Dx()-1))),
+		Y: r.Max.Y - 1 - int(math.Round(y*float64(r.Dy()-1))),
+	}
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(math.Min(v, max), min)
+}
+
+func drawFrame(img *image.RGBA, r image.Rectangle, xDivisions, yDivisions int) {
+	for i := 1; i < xDivisions; i++ {
+		x := r.Min.X + i*(r.Dx()-1)/xDivisions
+		drawLine(img, x, r.Min.Y, x, r.Max.Y-1, plotGrid)
+	}
+	for i := 1; i < yDivisions; i++ {
+		y := r.Min.Y + i*(r.Dy()-1)/yDivisions
+		drawLine(img, r.Min.X, y, r.Max.X-1, y, plotGrid)
+	}
+	drawLine(img, r.Min.X, r.Min.Y, r.Max.X-1, r.Min.Y, plotAxis)
+	drawLine(img, r.Min.X, r.Max.Y-1, r.Max.X-1, r.Max.Y-1, plotAxis)
+	drawLine(img, r.Min.X, r.Min.Y, r.Min.X, r.Max.Y-1, plotAxis)
+	drawLine(img, r.Max.X-1, r.Min.Y, r.Max.X-1, r.Max.Y-1, plotAxis)
+}
+
+// drawLine draws the segment between (x0,y0) and (x1,y1) with the Bresenham
+// algorithm.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.SetRGBA(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
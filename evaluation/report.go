@@ -0,0 +1,136 @@
+package evaluation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Report is the result of the cross validation of a feature type with a metric.
+type Report struct {
+	FeatureType    string                    `json:"feature_type"`
+	Metric         string                    `json:"metric"`
+	Folds          int                       `json:"folds"`
+	Total          int                       `json:"total"`
+	Correct        int                       `json:"correct"`
+	Accuracy       float64                   `json:"rank1_accuracy"`
+	Identities     []string                  `json:"identities"`
+	Confusion      map[string]map[string]int `json:"confusion_matrix"` // expected identity -> recognized identity -> count
+	PerClassRecall map[string]float64        `json:"per_class_recall"`
+	Error          string                    `json:"error,omitempty"`
+}
+
+func NewReport(featureType, metric string, folds int, identities []string) *Report {
+	r := &Report{
+		FeatureType:    featureType,
+		Metric:         metric,
+		Folds:          folds,
+		Identities:     identities,
+		Confusion:      make(map[string]map[string]int),
+		PerClassRecall: make(map[string]float64),
+	}
+	for _, identity := range identities {
+		r.Confusion[identity] = make(map[string]int)
+	}
+	return r
+}
+
+// Add records the recognition of an image of identity expected.
+func (r *Report) Add(expected, recognized string) {
+	if _, ok := r.Confusion[expected]; !ok {
+		r.Confusion[expected] = make(map[string]int)
+	}
+	r.Confusion[expected][recognized]++
+	r.Total++
+	if expected == recognized {
+		r.Correct++
+	}
+}
+
+// Compute sets the accuracy and the per class recall from the confusion matrix.
+func (r *Report) Compute() {
+	if r.Total > 0 {
+		r.Accuracy = float64(r.Correct) / float64(r.Total)
+	}
+	for expected, row := range r.Confusion {
+		total := 0
+		for _, count := range row {
+			total += count
+		}
+		if total > 0 {
+			r.PerClassRecall[expected] = float64(row[expected]) / float64(total)
+		}
+	}
+}
+
+// WriteText writes the report as a human readable text.
+func (r *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "%s / %s (%d folds)\n", r.FeatureType, r.Metric, r.Folds)
+	if r.Error != "" {
+		fmt.Fprintf(w, "  error : %s\n", r.Error)
+		return
+	}
+	fmt.Fprintf(w, "  rank-1 accuracy : %.4f (%d/%d)\n", r.Accuracy, r.Correct, r.Total)
+	fmt.Fprintf(w, "  per class recall :\n")
+	for _, identity := range r.Identities {
+		fmt.Fprintf(w, "    %-12s %.4f\n", identity, r.PerClassRecall[identity])
+	}
+	fmt.Fprintf(w, "  confusion matrix (rows expected, columns recognized) :\n")
+	fmt.Fprintf(w, "    %-12s", "")
+	for _, identity := range r.Identities {
+		fmt.Fprintf(w, " %6s", truncate(identity, 6))
+	}
+	fmt.Fprintf(w, " %6s\n", "other")
+	for _, expected := range r.Identities {
+		fmt.Fprintf(w, "    %-12s", expected)
+		other := 0
+		for recognized, count := range r.Confusion[expected] {
+			if !r.hasIdentity(recognized) {
+				other += count
+			}
+		}
+		for _, recognized := range r.Identities {
+			fmt.Fprintf(w, " %6d", r.Confusion[expected][recognized])
+		}
+		fmt.Fprintf(w, " %6d\n", other)
+	}
+}
+
+func (r *Report) hasIdentity(identity string) bool {
+	for _, i := range r.Identities {
+		if i == identity {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// WriteReportsText writes the reports as text followed by a summary.
+func WriteReportsText(w io.Writer, reports []*Report) {
+	for _, r := range reports {
+		r.WriteText(w)
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "summary :")
+	for _, r := range reports {
+		status := fmt.Sprintf("%.4f", r.Accuracy)
+		if r.Error != "" {
+			status = "error"
+		}
+		fmt.Fprintf(w, "  %-6s %-20s %s\n", r.FeatureType, r.Metric, strings.TrimSpace(status))
+	}
+}
+
+func WriteReportsJSON(w io.Writer, reports []*Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(reports)
+}
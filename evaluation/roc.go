@@ -0,0 +1,268 @@
+package evaluation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/jeromelesaux/facerecognition/model"
+	"github.com/pkg/errors"
+)
+
+// Scores are the distances between the pairs of images of the same identity
+// (genuine) and of different identities (impostor).
+type Scores struct {
+	Genuine  []float64
+	Impostor []float64
+}
+
+func NewScores() *Scores {
+	return &Scores{
+		Genuine:  make([]float64, 0),
+		Impostor: make([]float64, 0),
+	}
+}
+
+// ComputeScores projects all the images of the dataset with the trainer and
+// returns the distances of all the pairs of images.
+func ComputeScores(t *model.Trainer, ds *Dataset) *Scores {
+	s := NewScores()
+	projections := make([]*algorithm.Matrix, len(ds.Images))
+	for i := range ds.Images {
+		projections[i] = t.Project(ds.Images[i])
+	}
+	for i := 0; i < len(projections); i++ {
+		for j := i + 1; j < len(projections); j++ {
+			d := t.Metric(projections[i], projections[j])
+			if ds.Labels[i] == ds.Labels[j] {
+				s.Genuine = append(s.Genuine, d)
+			} else {
+				s.Impostor = append(s.Impostor, d)
+			}
+		}
+	}
+	return s
+}
+
+func (s *Scores) Merge(o *Scores) {
+	s.Genuine = append(s.Genuine, o.Genuine...)
+	s.Impostor = append(s.Impostor, o.Impostor...)
+}
+
+// ROCPoint is the operating point of a threshold, a pair is accepted if its
+// distance is lower or equal to the threshold.
+type ROCPoint struct {
+	Threshold float64 `json:"threshold"`
+	FAR       float64 `json:"far"` // false accept rate
+	FRR       float64 `json:"frr"` // false reject rate
+	TAR       float64 `json:"tar"` // true accept rate, 1 - FRR
+}
+
+// ROC returns the operating points of all the distinct scores sorted by
+// increasing threshold, so by increasing FAR.
+func (s *Scores) ROC() []ROCPoint {
+	genuine := append(make([]float64, 0, len(s.Genuine)), s.Genuine...)
+	impostor := append(make([]float64, 0, len(s.Impostor)), s.Impostor...)
+	sort.Float64s(genuine)
+	sort.Float64s(impostor)
+	thresholds := append(append(make([]float64, 0, len(genuine)+len(impostor)), genuine...), impostor...)
+	sort.Float64s(thresholds)
+
+	points := make([]ROCPoint, 0, len(thresholds)+1)
+	points = append(points, ROCPoint{Threshold: math.Inf(-1), FAR: 0, FRR: 1, TAR: 0})
+	g, i := 0, 0
+	for k, thr := range thresholds {
+		if k > 0 && thr == thresholds[k-1] {
+			continue
+		}
+		for g < len(genuine) && genuine[g] <= thr {
+			g++
+		}
+		for i < len(impostor) && impostor[i] <= thr {
+			i++
+		}
+		p := ROCPoint{Threshold: thr}
+		if len(genuine) > 0 {
+			p.TAR = float64(g) / float64(len(genuine))
+		}
+		if len(impostor) > 0 {
+			p.FAR = float64(i) / float64(len(impostor))
+		}
+		p.FRR = 1 - p.TAR
+		points = append(points, p)
+	}
+	return points
+}
+
+// EER returns the equal error rate of the operating points, where the FAR
+// equals the FRR, and its threshold.
+func EER(points []ROCPoint) (float64, float64) {
+	for k := 1; k < len(points); k++ {
+		if points[k].FAR < points[k].FRR {
+			continue
+		}
+		// the curves cross between the points k-1 and k
+		prev, cur := points[k-1], points[k]
+		delta := (cur.FAR - prev.FAR) + (prev.FRR - cur.FRR)
+		if delta == 0 {
+			return (cur.FAR + cur.FRR) / 2, cur.Threshold
+		}
+		alpha := (prev.FRR - prev.FAR) / delta
+		eer := prev.FAR + alpha*(cur.FAR-prev.FAR)
+		if math.IsInf(prev.Threshold, -1) {
+			return eer, cur.Threshold
+		}
+		return eer, prev.Threshold + alpha*(cur.Threshold-prev.Threshold)
+	}
+	last := points[len(points)-1]
+	return (last.FAR + last.FRR) / 2, last.Threshold
+}
+
+// TARAtFAR returns the highest true accept rate with a false accept rate
+// lower or equal to far, and its threshold.
+func TARAtFAR(points []ROCPoint, far float64) (float64, float64) {
+	tar, threshold := 0., math.Inf(-1)
+	for _, p := range points {
+		if p.FAR <= far && p.TAR >= tar {
+			tar, threshold = p.TAR, p.Threshold
+		}
+	}
+	return tar, threshold
+}
+
+// VerificationReport describes the verification quality of a feature type
+// with a metric.
+type VerificationReport struct {
+	FeatureType       string     `json:"feature_type"`
+	Metric            string     `json:"metric"`
+	GenuinePairs      int        `json:"genuine_pairs"`
+	ImpostorPairs     int        `json:"impostor_pairs"`
+	EER               float64    `json:"eer"`
+	EERThreshold      float64    `json:"eer_threshold"`
+	TARAtFAR1e3       float64    `json:"tar_at_far_1e-3"`
+	TARAtFAR1e3Thresh float64    `json:"tar_at_far_1e-3_threshold"`
+	Points            []ROCPoint `json:"-"`
+	Error             string     `json:"error,omitempty"`
+}
+
+func NewVerificationReport(featureType, metric string, s *Scores) *VerificationReport {
+	r := &VerificationReport{
+		FeatureType:   featureType,
+		Metric:        metric,
+		GenuinePairs:  len(s.Genuine),
+		ImpostorPairs: len(s.Impostor),
+	}
+	if len(s.Genuine) == 0 || len(s.Impostor) == 0 {
+		r.Error = "genuine and impostor pairs are required"
+		return r
+	}
+	r.Points = s.ROC()
+	r.EER, r.EERThreshold = EER(r.Points)
+	r.TARAtFAR1e3, r.TARAtFAR1e3Thresh = TARAtFAR(r.Points, 1e-3)
+	return r
+}
+
+// AnalyseVerification computes the genuine and impostor scores of each feature
+// type with each metric. The trainer of a fold is trained on the other folds
+// and scores the pairs of images of its fold, so at least 2 folds are used.
+func AnalyseVerification(ds *Dataset, opts *Options) ([]*VerificationReport, error) {
+	if len(ds.Images) < 2 {
+		return nil, errors.New("at least two images are required for a verification analysis")
+	}
+	for _, name := range opts.Metrics {
+		if _, err := model.NewMetric(name); err != nil {
+			return nil, err
+		}
+	}
+	numOfFolds := opts.Folds
+	if numOfFolds < 2 || numOfFolds >= len(ds.Images) {
+		numOfFolds = 2
+	}
+	folds := assignFolds(ds, numOfFolds)
+
+	reports := make([]*VerificationReport, 0)
+	for _, featureType := range opts.FeatureTypes {
+		scores := make(map[string]*Scores)
+		for _, name := range opts.Metrics {
+			scores[name] = NewScores()
+		}
+		var err error
+		for fold := 0; fold < numOfFolds; fold++ {
+			var trainer *model.Trainer
+			trainer, err = trainFold(ds, folds, fold, featureType, opts)
+			if err != nil {
+				logger.Logf("cannot train %s on fold %d : %v", featureType, fold, err)
+				break
+			}
+			test := &Dataset{}
+			for i := range ds.Images {
+				if folds[i] == fold {
+					test.Add(ds.Images[i], ds.Labels[i], ds.Sources[i])
+				}
+			}
+			for _, name := range opts.Metrics {
+				trainer.SetMetric(name)
+				scores[name].Merge(ComputeScores(trainer, test))
+			}
+		}
+		for _, name := range opts.Metrics {
+			if err != nil {
+				reports = append(reports, &VerificationReport{FeatureType: featureType, Metric: name, Error: err.Error()})
+				continue
+			}
+			reports = append(reports, NewVerificationReport(featureType, name, scores[name]))
+		}
+	}
+	return reports, nil
+}
+
+// WriteROCCSV writes the operating points with their DET coordinates, the
+// normal deviates of the FAR and the FRR.
+func WriteROCCSV(w io.Writer, points []ROCPoint) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"threshold", "far", "frr", "tar", "det_far", "det_frr"}); err != nil {
+		return err
+	}
+	for _, p := range points {
+		if math.IsInf(p.Threshold, -1) {
+			continue
+		}
+		record := []string{
+			strconv.FormatFloat(p.Threshold, 'g', -1, 64),
+			strconv.FormatFloat(p.FAR, 'g', -1, 64),
+			strconv.FormatFloat(p.FRR, 'g', -1, 64),
+			strconv.FormatFloat(p.TAR, 'g', -1, 64),
+			strconv.FormatFloat(probit(p.FAR), 'g', 6, 64),
+			strconv.FormatFloat(probit(p.FRR), 'g', 6, 64),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// probit returns the normal deviate of the probability p, clamped to keep
+// the rates 0 and 1 finite.
+func probit(p float64) float64 {
+	p = math.Max(math.Min(p, 1-1e-6), 1e-6)
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+func WriteVerificationReportsText(w io.Writer, reports []*VerificationReport) {
+	fmt.Fprintln(w, "verification :")
+	for _, r := range reports {
+		if r.Error != "" {
+			fmt.Fprintf(w, "  %-6s %-20s error : %s\n", r.FeatureType, r.Metric, r.Error)
+			continue
+		}
+		fmt.Fprintf(w, "  %-6s %-20s EER %.4f (threshold %.4g) TAR@FAR=1e-3 %.4f (threshold %.4g) genuine %d impostor %d\n",
+			r.FeatureType, r.Metric, r.EER, r.EERThreshold, r.TARAtFAR1e3, r.TARAtFAR1e3Thresh, r.GenuinePairs, r.ImpostorPairs)
+	}
+}
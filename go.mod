@@ -3,7 +3,6 @@ module github.com/jeromelesaux/facerecognition
 go 1.19
 
 require (
-	github.com/cnf/structhash v0.0.0-20201127153200-e1b16c1ebc08
 	github.com/disintegration/imaging v1.6.2
 	github.com/jbuchbinder/gopnm v0.0.0-20220507095634-e31f54490ce0
 	github.com/jeromelesaux/facedetection v0.0.0-20230307215915-57b8584ef079
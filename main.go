@@ -2,11 +2,16 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"image"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/evaluation"
 	"github.com/jeromelesaux/facerecognition/logger"
 	"github.com/jeromelesaux/facerecognition/model"
 	"github.com/jeromelesaux/facerecognition/web"
@@ -23,15 +28,25 @@ func (i *stringflags) Set(value string) error {
 	return nil
 }
 
-var imagesfiles stringflags
+var (
+	imagesfiles   stringflags
+	featureparams stringflags
+)
 
 var (
-	httpport  = flag.String("httpport", "", "HTTP port value (default 8099).")
-	firstname = flag.String("firstname", "", "Firstname of the person to add.")
-	lastname  = flag.String("lastname", "", "Lastname ot the person to add.")
-	add       = flag.Bool("add", false, "Add the person in user lib.")
-	recognize = flag.Bool("recognize", false, "Recognize person from image.")
-	config    = flag.String("config", "", "Path to the configuration file.")
+	httpport      = flag.String("httpport", "", "HTTP port value (default 8099).")
+	firstname     = flag.String("firstname", "", "Firstname of the person to add.")
+	lastname      = flag.String("lastname", "", "Lastname ot the person to add.")
+	add           = flag.Bool("add", false, "Add the person in user lib.")
+	recognize     = flag.Bool("recognize", false, "Recognize person from image.")
+	config        = flag.String("config", "", "Path to the configuration file.")
+	evaluate      = flag.String("evaluate", "", "Path to a dataset directory (one sub directory per person) to evaluate the recognition.")
+	folds         = flag.Int("folds", 0, "Number of folds of the cross validation (default leave one out).")
+	report        = flag.String("report", "", "Path to the JSON file of the evaluation report.")
+	metric        = flag.String("metric", "", "Name of the distance metric (default the configuration one or the one of the feature extractor).")
+	feature       = flag.String("feature", "", "Name of the feature extractor (default the configuration one or PCA).")
+	roc           = flag.String("roc", "", "Directory where the ROC/DET curves (CSV and PNG) of the evaluated dataset are written.")
+	preprocessing = flag.String("preprocessing", "", "Illumination normalization of the evaluated images, steps separated by commas with their parameters as :name=value (e.g. gamma:gamma=0.2,dog).")
 )
 
 type Value interface {
@@ -41,11 +56,16 @@ type Value interface {
 
 func main() {
 	flag.Var(&imagesfiles, "imagesfiles", "List of the images files of the person to add in database")
+	flag.Var(&featureparams, "featureparam", "Parameter of the feature extractor as name=value, can be repeated")
 	flag.Parse()
 
 	if flag.NFlag() == 0 {
 		flag.PrintDefaults()
 	} else {
+		if *evaluate != "" {
+			evaluateDataset(*evaluate, *folds, *report, *roc)
+			return
+		}
 		logger.Logf("configuration file %s", *config)
 		if *config != "" {
 			model.SetAndLoad(*config)
@@ -54,9 +74,24 @@ func main() {
 			return
 		}
 		if !*recognize {
+			featureType, params, err := featureExtractor(model.GetConfig())
+			if err != nil {
+				logger.Logf("invalid feature extractor : %v", err)
+				return
+			}
+			metricName := model.GetConfig().Metric
+			if metricName == "" {
+				metricName = model.DefaultMetric(featureType)
+			}
+			if *metric != "" {
+				metricName = *metric
+			}
+			if _, err := model.NewMetric(metricName); err != nil {
+				logger.Logf("invalid metric : %v", err)
+				return
+			}
 			lib := model.GetFaceRecognitionLib()
-			t := lib.GetTrainer(model.PCAFeatureType)
-			t.Train()
+			t := lib.LoadOrTrain(featureType, params, metricName)
 			for _, i := range imagesfiles {
 				f, err := os.Open(i)
 				if err != nil {
@@ -67,14 +102,21 @@ func main() {
 					if err != nil {
 						logger.Logf("error while decoding file %s with error %v", i, err)
 					} else {
-						mats, _ := lib.FindFace(&img)
+						mats := make([]*algorithm.Matrix, 0)
+						for _, f := range lib.Faces(img) {
+							mats = append(mats, f.Vector())
+						}
 						if len(mats) == 0 {
-							mats = append(mats, lib.MatrixNVectorize(&img))
+							mats = append(mats, lib.Vectorize(img))
 						}
 						logger.Logf("found %d faces.", len(mats))
 						for _, m := range mats {
-							p, distance := t.Recognize(m)
-							logger.Log("Found " + p + " distance " + strconv.FormatFloat(distance, 'e', 2, 32))
+							r := t.RecognizeOpenSet(m)
+							if r.Known {
+								logger.Log("Found " + r.Label + " distance " + strconv.FormatFloat(r.Distance, 'e', 2, 32) + " confidence " + strconv.FormatFloat(r.Confidence, 'f', 2, 32))
+							} else {
+								logger.Log("Unknown person, nearest " + r.Nearest + " distance " + strconv.FormatFloat(r.Distance, 'e', 2, 32))
+							}
 						}
 					}
 				}
@@ -92,8 +134,11 @@ func main() {
 
 			} else {
 				if *httpport != "" {
+					http.HandleFunc("/extractors", web.ListFeatureExtractors)
+					http.HandleFunc("/metrics", web.ListMetrics)
 					http.HandleFunc("/train", web.Training)
 					http.HandleFunc("/compare", web.Compare)
+					http.HandleFunc("/verify", web.Verify)
 					http.HandleFunc("/listpersons", web.ListPersons)
 					http.HandleFunc("/person", web.GetPerson)
 					http.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("./static"))))
@@ -106,3 +151,121 @@ func main() {
 		}
 	}
 }
+
+// featureExtractor returns the feature extractor set by the flags, or by the
+// configuration conf if nil or not set, with its validated parameters.
+func featureExtractor(conf *model.Config) (string, model.Parameters, error) {
+	featureType := model.PCAFeatureType
+	params := make(model.Parameters)
+	if conf != nil {
+		featureType = conf.GetFeatureType()
+		params = conf.FeatureParameters.Copy()
+	}
+	if *feature != "" && *feature != featureType {
+		featureType = *feature
+		params = make(model.Parameters)
+	}
+	for _, p := range featureparams {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return "", nil, fmt.Errorf("feature parameter %s is not name=value", p)
+		}
+		params[kv[0]] = kv[1]
+	}
+	r, err := model.GetFeatureExtractorRegistration(featureType)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := r.NormalizeParameters(params); err != nil {
+		return "", nil, err
+	}
+	return featureType, params, nil
+}
+
+func evaluateDataset(directory string, folds int, reportPath string, rocDirectory string) {
+	ds, err := evaluation.LoadDataset(directory)
+	if err != nil {
+		logger.Logf("cannot load dataset : %v", err)
+		return
+	}
+	opts := evaluation.NewOptions()
+	opts.Folds = folds
+	if *feature != "" {
+		featureType, params, err := featureExtractor(nil)
+		if err != nil {
+			logger.Logf("invalid feature extractor : %v", err)
+			return
+		}
+		opts.FeatureTypes = []string{featureType}
+		opts.FeatureParameters = params
+	}
+	if *metric != "" {
+		opts.Metrics = []string{*metric}
+	}
+	if *preprocessing != "" {
+		p, err := model.ParsePreprocessing(*preprocessing)
+		if err != nil {
+			logger.Logf("invalid preprocessing : %v", err)
+			return
+		}
+		opts.Preprocessing = p
+	}
+	reports, err := evaluation.CrossValidate(ds, opts)
+	if err != nil {
+		logger.Logf("cannot evaluate dataset %s : %v", directory, err)
+		return
+	}
+	evaluation.WriteReportsText(os.Stdout, reports)
+	if reportPath != "" {
+		f, err := os.Create(reportPath)
+		if err != nil {
+			logger.Logf("cannot create report file %s : %v", reportPath, err)
+			return
+		}
+		defer f.Close()
+		if err := evaluation.WriteReportsJSON(f, reports); err != nil {
+			logger.Logf("cannot write report file %s : %v", reportPath, err)
+		}
+	}
+	if rocDirectory != "" {
+		analyseVerification(ds, opts, rocDirectory)
+	}
+}
+
+func analyseVerification(ds *evaluation.Dataset, opts *evaluation.Options, directory string) {
+	reports, err := evaluation.AnalyseVerification(ds, opts)
+	if err != nil {
+		logger.Logf("cannot analyse verification : %v", err)
+		return
+	}
+	evaluation.WriteVerificationReportsText(os.Stdout, reports)
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		logger.Logf("cannot create directory %s : %v", directory, err)
+		return
+	}
+	for _, r := range reports {
+		if r.Error != "" {
+			continue
+		}
+		path := filepath.Join(directory, r.FeatureType+"_"+r.Metric+".csv")
+		f, err := os.Create(path)
+		if err != nil {
+			logger.Logf("cannot create file %s : %v", path, err)
+			continue
+		}
+		if err := evaluation.WriteROCCSV(f, r.Points); err != nil {
+			logger.Logf("cannot write file %s : %v", path, err)
+		}
+		f.Close()
+	}
+	path := filepath.Join(directory, "roc.png")
+	f, err := os.Create(path)
+	if err != nil {
+		logger.Logf("cannot create file %s : %v", path, err)
+		return
+	}
+	defer f.Close()
+	if err := evaluation.PlotROC(f, reports, 1200, 600); err != nil {
+		logger.Logf("cannot write file %s : %v", path, err)
+	}
+}
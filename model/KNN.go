@@ -3,6 +3,7 @@ package model
 import (
 	"github.com/jeromelesaux/facerecognition/algorithm"
 	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/pkg/errors"
 )
 
 func AssignLabel(trainingSet []*ProjectedTrainingMatrix, testFace *algorithm.Matrix, k int, computeDistance func(a, b *algorithm.Matrix) float64) (string, float64) {
@@ -11,11 +12,24 @@ func AssignLabel(trainingSet []*ProjectedTrainingMatrix, testFace *algorithm.Mat
 }
 
 func FindKNN(trainingSet []*ProjectedTrainingMatrix, testFace *algorithm.Matrix, k int, computeDistance func(a, b *algorithm.Matrix) float64) []*ProjectedTrainingMatrix {
-	numOfTrainingSet := len(trainingSet)
-	if k > numOfTrainingSet {
-		logger.Log("K is larger than the length of trainingSet!")
+	neighbors, err := NearestNeighbors(trainingSet, testFace, k, computeDistance)
+	if err != nil {
+		logger.Log(err.Error())
 		return nil
 	}
+	return neighbors
+}
+
+// NearestNeighbors returns the k training matrices nearest to testFace, their
+// distance to testFace is stored in them.
+func NearestNeighbors(trainingSet []*ProjectedTrainingMatrix, testFace *algorithm.Matrix, k int, computeDistance func(a, b *algorithm.Matrix) float64) ([]*ProjectedTrainingMatrix, error) {
+	numOfTrainingSet := len(trainingSet)
+	if k < 1 || k > numOfTrainingSet {
+		return nil, errors.Wrapf(ErrInsufficientSamples, "K=%d for %d training matrices", k, numOfTrainingSet)
+	}
+	if trainingSet[0].Matrix.M != testFace.M {
+		return nil, errors.Wrapf(ErrDimensionMismatch, "test face of dimension %d for training matrices of dimension %d", testFace.M, trainingSet[0].Matrix.M)
+	}
 	// initialization
 	neighbors := make([]*ProjectedTrainingMatrix, k)
 	for i := 0; i < k; i++ {
@@ -38,7 +52,7 @@ func FindKNN(trainingSet []*ProjectedTrainingMatrix, testFace *algorithm.Matrix,
 			neighbors[maxIndex] = trainingSet[i]
 		}
 	}
-	return neighbors
+	return neighbors, nil
 }
 
 func Classify(neighbors []*ProjectedTrainingMatrix) (string, float64) {
@@ -0,0 +1,230 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/pkg/errors"
+)
+
+var (
+	KPCAFeatureType = "KPCA"
+
+	RBFKernel        = "rbf"
+	PolynomialKernel = "poly"
+	SigmoidKernel    = "sigmoid"
+)
+
+// KPCA is the kernel principal component analysis : a PCA in the feature space
+// of the kernel, the images are projected with the kernel against the training
+// images, centered with the training kernel matrix.
+type KPCA struct {
+	Kernel            string              `json:"kernel"`
+	Gamma             float64             `json:"gamma"`
+	Degree            int                 `json:"degree"`
+	Coef0             float64             `json:"coef0"`
+	NumOfComponents   int                 `json:"num_of_components"`
+	TrainingSet       []*algorithm.Matrix `json:"training_set"`
+	Alphas            *algorithm.Matrix   `json:"alphas"`              // n x components, eigenvectors of the centered kernel divided by sqrt(eigenvalue)
+	KernelColumnMeans []float64           `json:"kernel_column_means"` // mean of each column of the training kernel matrix
+	KernelMean        float64             `json:"kernel_mean"`         // mean of the training kernel matrix
+	Eigenvalues       []float64           `json:"eigenvalues"`         // variance along each component
+}
+
+func init() {
+	positive := func(value string) error {
+		if v, _ := strconv.ParseFloat(value, 64); v < 0 {
+			return errors.New("must be positive")
+		}
+		return nil
+	}
+	mustRegisterFeatureExtractor(&FeatureExtractorRegistration{
+		Name:        KPCAFeatureType,
+		Description: "kernel principal component analysis",
+		Parameters: []*ParameterDefinition{
+			componentsParameter(),
+			{Name: "kernel", Type: StringParameter, Default: RBFKernel, Values: []string{RBFKernel, PolynomialKernel, SigmoidKernel},
+				Description: "rbf exp(-gamma |x-y|^2), poly (gamma x.y + coef0)^degree or sigmoid tanh(gamma x.y + coef0)"},
+			{Name: "gamma", Type: FloatParameter, Default: "0", Validate: positive,
+				Description: "kernel scale, 1 / mean squared distance (rbf) or 1 / mean squared norm (poly, sigmoid) of the training images if 0"},
+			{Name: "degree", Type: IntParameter, Default: "2", Validate: func(value string) error {
+				if v, _ := strconv.ParseFloat(value, 64); v < 1 {
+					return errors.New("must be at least 1")
+				}
+				return nil
+			}, Description: "degree of the polynomial kernel"},
+			{Name: "coef0", Type: FloatParameter, Default: "1", Description: "constant of the polynomial and sigmoid kernels"},
+		},
+		New: func(p Parameters) (FeatureExtractor, error) {
+			return &KPCA{
+				Kernel:          p.String("kernel"),
+				Gamma:           p.Float("gamma"),
+				Degree:          p.Int("degree"),
+				Coef0:           p.Float("coef0"),
+				NumOfComponents: p.Int("components"),
+			}, nil
+		},
+	})
+}
+
+func (k *KPCA) Name() string {
+	return KPCAFeatureType
+}
+
+func (k *KPCA) kernel(a, b *algorithm.Matrix) float64 {
+	switch k.Kernel {
+	case PolynomialKernel:
+		return math.Pow(k.Gamma*dot(a, b)+k.Coef0, float64(k.Degree))
+	case SigmoidKernel:
+		return math.Tanh(k.Gamma*dot(a, b) + k.Coef0)
+	default:
+		d := 0.
+		for i := 0; i < a.M; i++ {
+			d += (a.A[i][0] - b.A[i][0]) * (a.A[i][0] - b.A[i][0])
+		}
+		return math.Exp(-k.Gamma * d)
+	}
+}
+
+func dot(a, b *algorithm.Matrix) float64 {
+	sum := 0.
+	for i := 0; i < a.M; i++ {
+		sum += a.A[i][0] * b.A[i][0]
+	}
+	return sum
+}
+
+// defaultGamma returns 1 / the mean squared distance between the training
+// images for the rbf kernel, 1 / their mean squared norm otherwise.
+func (k *KPCA) defaultGamma(trainingSet []*algorithm.Matrix) float64 {
+	sum, count := 0., 0
+	for i := range trainingSet {
+		if k.Kernel != RBFKernel {
+			sum += dot(trainingSet[i], trainingSet[i])
+			count++
+			continue
+		}
+		for j := i + 1; j < len(trainingSet); j++ {
+			d := 0.
+			for r := 0; r < trainingSet[i].M; r++ {
+				d += (trainingSet[i].A[r][0] - trainingSet[j].A[r][0]) * (trainingSet[i].A[r][0] - trainingSet[j].A[r][0])
+			}
+			sum += d
+			count++
+		}
+	}
+	if sum == 0 {
+		return 1.
+	}
+	return float64(count) / sum
+}
+
+func (k *KPCA) Fit(trainingSet []*algorithm.Matrix, labels []string) error {
+	n := len(trainingSet)
+	if n < 2 {
+		return errors.New("KPCA needs at least two training images")
+	}
+	components := k.NumOfComponents
+	if components == 0 {
+		components = defaultNumOfComponents(labels)
+	}
+	if components >= n {
+		return errors.Errorf("KPCA cannot compute %d components from %d images", components, n)
+	}
+	if k.Gamma == 0 {
+		k.Gamma = k.defaultGamma(trainingSet)
+	}
+
+	kernel := algorithm.NewMatrix(n, n)
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			v := k.kernel(trainingSet[i], trainingSet[j])
+			kernel.A[i][j] = v
+			kernel.A[j][i] = v
+		}
+	}
+	columnMeans := make([]float64, n)
+	mean := 0.
+	for j := 0; j < n; j++ {
+		for i := 0; i < n; i++ {
+			columnMeans[j] += kernel.A[i][j]
+		}
+		columnMeans[j] /= float64(n)
+		mean += columnMeans[j]
+	}
+	mean /= float64(n)
+	// centered kernel K - 1n K - K 1n + 1n K 1n
+	centered := algorithm.NewMatrix(n, n)
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			c := kernel.A[i][j] - columnMeans[i] - columnMeans[j] + mean
+			centered.A[i][j] = c
+			centered.A[j][i] = c
+		}
+	}
+
+	d, v, err := topEigen(centered, components, AutoSolver)
+	if err != nil {
+		return errors.Wrap(err, "KPCA centered kernel")
+	}
+	alphas := algorithm.NewMatrix(n, components)
+	eigenvalues := make([]float64, components)
+	for c := range d {
+		if d[c] <= 1e-12 {
+			return errors.Errorf("KPCA centered kernel has only %d positive eigenvalues for %d components", c, components)
+		}
+		for i := 0; i < n; i++ {
+			alphas.A[i][c] = v.A[i][c] / math.Sqrt(d[c])
+		}
+		eigenvalues[c] = d[c] / float64(n-1)
+	}
+	k.NumOfComponents = components
+	k.TrainingSet = trainingSet
+	k.Alphas = alphas
+	k.KernelColumnMeans = columnMeans
+	k.KernelMean = mean
+	k.Eigenvalues = eigenvalues
+	return nil
+}
+
+func (k *KPCA) Project(m *algorithm.Matrix) *algorithm.Matrix {
+	n := len(k.TrainingSet)
+	values := make([]float64, n)
+	mean := 0.
+	for i := 0; i < n; i++ {
+		values[i] = k.kernel(m, k.TrainingSet[i])
+		mean += values[i]
+	}
+	mean /= float64(n)
+	projection := algorithm.NewMatrix(k.Alphas.N, 1)
+	for i := 0; i < n; i++ {
+		centered := values[i] - mean - k.KernelColumnMeans[i] + k.KernelMean
+		for c := 0; c < k.Alphas.N; c++ {
+			projection.A[c][0] += k.Alphas.A[i][c] * centered
+		}
+	}
+	return projection
+}
+
+func (k *KPCA) ComponentVariances() []float64 {
+	return k.Eigenvalues
+}
+
+func (k *KPCA) Save() (json.RawMessage, error) {
+	if k.Alphas == nil {
+		return nil, errors.New("KPCA is not fitted")
+	}
+	return json.Marshal(k)
+}
+
+func (k *KPCA) Load(data json.RawMessage) error {
+	if err := json.Unmarshal(data, k); err != nil {
+		return errors.Wrap(err, "cannot decode KPCA")
+	}
+	if k.Alphas == nil || k.Alphas.M != len(k.TrainingSet) || len(k.KernelColumnMeans) != len(k.TrainingSet) {
+		return errors.New("KPCA has inconsistent training kernel")
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"math/bits"
+	"strconv"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/pkg/errors"
+)
+
+var LBPHFeatureType = "LBPH"
+
+// LBPH describes the images by the histograms of their local binary patterns
+// computed on a grid of cells. It learns nothing from the training images, so
+// images are enrolled without retraining.
+type LBPH struct {
+	Radius    int  `json:"radius"`
+	Neighbors int  `json:"neighbors"`
+	GridX     int  `json:"grid_x"`
+	GridY     int  `json:"grid_y"`
+	Uniform   bool `json:"uniform"`
+	Width     int  `json:"width"`
+	Height    int  `json:"height"`
+	// uniformBins maps a pattern to its bin if uniform
+	uniformBins []int
+}
+
+func init() {
+	between := func(min, max int) func(value string) error {
+		return func(value string) error {
+			if v, _ := strconv.Atoi(value); v < min || v > max {
+				return errors.Errorf("must be between %d and %d", min, max)
+			}
+			return nil
+		}
+	}
+	mustRegisterFeatureExtractor(&FeatureExtractorRegistration{
+		Name:          LBPHFeatureType,
+		Description:   "local binary patterns histograms, robust to the lighting changes",
+		DefaultMetric: ChiSquareMetric,
+		Parameters: []*ParameterDefinition{
+			{Name: "radius", Type: IntParameter, Default: "1", Validate: between(1, 8), Description: "radius of the circle of neighbors"},
+			{Name: "neighbors", Type: IntParameter, Default: "8", Validate: between(4, 16), Description: "number of neighbors sampled on the circle"},
+			{Name: "grid_x", Type: IntParameter, Default: "8", Validate: between(1, 64), Description: "number of cells horizontally"},
+			{Name: "grid_y", Type: IntParameter, Default: "8", Validate: between(1, 64), Description: "number of cells vertically"},
+			{Name: "uniform", Type: BoolParameter, Default: "true", Description: "one bin per uniform pattern (at most 2 transitions) and one for all the others"},
+			{Name: "width", Type: IntParameter, Default: "0", Validate: between(0, 1<<16), Description: "width of the images, the one of the trainer if 0"},
+			{Name: "height", Type: IntParameter, Default: "0", Validate: between(0, 1<<16), Description: "height of the images, the one of the trainer if 0"},
+		},
+		New: func(p Parameters) (FeatureExtractor, error) {
+			l := &LBPH{
+				Radius:    p.Int("radius"),
+				Neighbors: p.Int("neighbors"),
+				GridX:     p.Int("grid_x"),
+				GridY:     p.Int("grid_y"),
+				Uniform:   p.Bool("uniform"),
+				Width:     p.Int("width"),
+				Height:    p.Int("height"),
+			}
+			l.computeUniformBins()
+			return l, nil
+		},
+	})
+}
+
+func (l *LBPH) Name() string {
+	return LBPHFeatureType
+}
+
+// SetImageSize sets the size of the images if not set by the parameters.
+func (l *LBPH) SetImageSize(width, height int) {
+	if l.Width == 0 && l.Height == 0 {
+		l.Width = width
+		l.Height = height
+	}
+}
+
+// Incremental returns true, the histograms of an image do not depend on the
+// training images.
+func (l *LBPH) Incremental() bool {
+	return true
+}
+
+func (l *LBPH) check(dimension int) error {
+	if l.Width <= 0 || l.Height <= 0 {
+		return errors.New("LBPH needs the size of the images")
+	}
+	if l.Width*l.Height != dimension {
+		return errors.Errorf("LBPH image size %dx%d does not match the image dimension %d", l.Width, l.Height, dimension)
+	}
+	if l.Width < 2*l.Radius+l.GridX || l.Height < 2*l.Radius+l.GridY {
+		return errors.Errorf("LBPH image size %dx%d is too small for a radius of %d and a grid of %dx%d", l.Width, l.Height, l.Radius, l.GridX, l.GridY)
+	}
+	return nil
+}
+
+func (l *LBPH) Fit(trainingSet []*algorithm.Matrix, labels []string) error {
+	if len(trainingSet) == 0 {
+		return errors.New("LBPH needs at least one training image")
+	}
+	return l.check(trainingSet[0].M)
+}
+
+func (l *LBPH) bins() int {
+	if l.Uniform {
+		// P (P - 1) + 2 uniform patterns and one bin for the others
+		return l.Neighbors*(l.Neighbors-1) + 3
+	}
+	return 1 << uint(l.Neighbors)
+}
+
+// bin returns the histogram bin of the pattern code.
+func (l *LBPH) bin(code int) int {
+	if !l.Uniform {
+		return code
+	}
+	return l.uniformBins[code]
+}
+
+// computeUniformBins numbers the uniform patterns, the patterns with at most
+// 2 transitions between consecutive bits, the other ones share the last bin.
+func (l *LBPH) computeUniformBins() {
+	l.uniformBins = make([]int, 1<<uint(l.Neighbors))
+	next := 0
+	for c := range l.uniformBins {
+		rotated := (c >> 1) | ((c & 1) << uint(l.Neighbors-1))
+		if bits.OnesCount(uint(c^rotated)) <= 2 {
+			l.uniformBins[c] = next
+			next++
+		} else {
+			l.uniformBins[c] = -1
+		}
+	}
+	for c := range l.uniformBins {
+		if l.uniformBins[c] < 0 {
+			l.uniformBins[c] = next
+		}
+	}
+}
+
+// Project returns the concatenated histograms of the cells of the image m
+// vectorized by columns, each histogram is normalized by its number of pixels.
+func (l *LBPH) Project(m *algorithm.Matrix) *algorithm.Matrix {
+	pixel := func(x, y int) float64 {
+		return m.A[x*l.Height+y][0]
+	}
+	// bilinear interpolation of the image at (x, y)
+	sample := func(x, y float64) float64 {
+		x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+		x1, y1 := x0+1, y0+1
+		if x1 >= l.Width {
+			x1 = x0
+		}
+		if y1 >= l.Height {
+			y1 = y0
+		}
+		fx, fy := x-float64(x0), y-float64(y0)
+		return (1-fx)*(1-fy)*pixel(x0, y0) + fx*(1-fy)*pixel(x1, y0) +
+			(1-fx)*fy*pixel(x0, y1) + fx*fy*pixel(x1, y1)
+	}
+	offsetsX := make([]float64, l.Neighbors)
+	offsetsY := make([]float64, l.Neighbors)
+	for p := 0; p < l.Neighbors; p++ {
+		angle := 2 * math.Pi * float64(p) / float64(l.Neighbors)
+		offsetsX[p] = float64(l.Radius) * math.Cos(angle)
+		offsetsY[p] = -float64(l.Radius) * math.Sin(angle)
+		// remove the rounding errors of the points on the pixels
+		if math.Abs(offsetsX[p]-math.Round(offsetsX[p])) < 1e-6 {
+			offsetsX[p] = math.Round(offsetsX[p])
+		}
+		if math.Abs(offsetsY[p]-math.Round(offsetsY[p])) < 1e-6 {
+			offsetsY[p] = math.Round(offsetsY[p])
+		}
+	}
+
+	bins := l.bins()
+	histograms := algorithm.NewMatrix(l.GridX*l.GridY*bins, 1)
+	counts := make([]int, l.GridX*l.GridY)
+	innerWidth, innerHeight := l.Width-2*l.Radius, l.Height-2*l.Radius
+	for x := l.Radius; x < l.Width-l.Radius; x++ {
+		for y := l.Radius; y < l.Height-l.Radius; y++ {
+			center := pixel(x, y)
+			code := 0
+			for p := 0; p < l.Neighbors; p++ {
+				if sample(float64(x)+offsetsX[p], float64(y)+offsetsY[p]) >= center {
+					code |= 1 << uint(p)
+				}
+			}
+			cell := ((y-l.Radius)*l.GridY/innerHeight)*l.GridX + (x-l.Radius)*l.GridX/innerWidth
+			histograms.A[cell*bins+l.bin(code)][0]++
+			counts[cell]++
+		}
+	}
+	for cell, count := range counts {
+		for b := 0; b < bins; b++ {
+			histograms.A[cell*bins+b][0] /= float64(count)
+		}
+	}
+	return histograms
+}
+
+func (l *LBPH) Save() (json.RawMessage, error) {
+	if l.Width <= 0 || l.Height <= 0 {
+		return nil, errors.New("LBPH is not fitted")
+	}
+	return json.Marshal(l)
+}
+
+func (l *LBPH) Load(data json.RawMessage) error {
+	if err := json.Unmarshal(data, l); err != nil {
+		return errors.Wrap(err, "cannot decode LBPH")
+	}
+	if l.Neighbors < 1 || l.Neighbors > 16 {
+		return errors.Errorf("LBPH cannot have %d neighbors", l.Neighbors)
+	}
+	l.computeUniformBins()
+	return l.check(l.Width * l.Height)
+}
@@ -1,57 +1,139 @@
 package model
 
 import (
+	"encoding/json"
+	"math"
+
 	"github.com/jeromelesaux/facerecognition/algorithm"
 	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/pkg/errors"
+)
+
+var (
+	// FisherLDA is the classic fisherfaces : a PCA on n - c components then the
+	// eigenvectors of Sw^-1 Sb, it needs at least 2 images per identity.
+	FisherLDA = "fisher"
+	// RegularizedLDA shrinks the within class scatter towards a multiple of the
+	// identity, it works with 1 image per identity.
+	RegularizedLDA = "regularized"
+	// DirectLDA diagonalizes the between class scatter first, then keeps the
+	// directions with the smallest within class scatter.
+	DirectLDA = "direct"
 )
 
 type LDA struct {
 	FeatureExtraction *FeatureExtraction
+	components        int     // dimension of the PCA run before the LDA, see fit
+	mode              string  // FisherLDA, RegularizedLDA or DirectLDA
+	shrinkage         float64 // weight of the identity in the regularized within class scatter
 }
 
+// NewLDA computes the classic fisherfaces, the feature extraction is empty if
+// they cannot be computed.
 func NewLDA(trainingSet []*algorithm.Matrix, labels []string, numOfComponents int) *LDA {
-	l := &LDA{FeatureExtraction: NewFeatureExtraction()}
+	l, err := FitLDA(trainingSet, labels, numOfComponents)
+	if err != nil {
+		logger.Log(err.Error())
+		return &LDA{FeatureExtraction: NewFeatureExtraction(), components: numOfComponents, mode: FisherLDA}
+	}
+	return l
+}
+
+// FitLDA returns the classic fisherfaces of the training set, see NewLDA.
+func FitLDA(trainingSet []*algorithm.Matrix, labels []string, numOfComponents int) (*LDA, error) {
+	l := &LDA{FeatureExtraction: NewFeatureExtraction(), components: numOfComponents, mode: FisherLDA}
+	fe, err := l.fit(trainingSet, labels, numOfComponents)
+	if err != nil {
+		return nil, err
+	}
+	l.FeatureExtraction = fe
+	return l, nil
+}
+
+// fit returns the LDA feature extraction of the training set, components is
+// the dimension of the PCA run before the LDA, n - c for the fisher mode and
+// n - 1 for the others if 0.
+func (l *LDA) fit(trainingSet []*algorithm.Matrix, labels []string, components int) (*FeatureExtraction, error) {
+	if err := checkTrainingSet(trainingSet, labels); err != nil {
+		return nil, errors.Wrap(err, "LDA")
+	}
 	n := len(trainingSet)
-	tempSet := make(map[string]int)
+	classes := make(map[string]int)
 	for i := 0; i < len(labels); i++ {
-		if _, ok := tempSet[labels[i]]; !ok {
-			tempSet[labels[i]] = 1
-		} else {
-			tempSet[labels[i]] += 1
+		classes[labels[i]]++
+	}
+	c := len(classes)
+	if c < 2 {
+		return nil, errors.Wrapf(ErrInsufficientSamples, "LDA needs at least two identities and gets %d", c)
+	}
+
+	// dimension of the PCA run before the LDA
+	dimension := n - 1
+	if l.mode == FisherLDA {
+		if components > 0 && components < n-c {
+			return nil, errors.Wrapf(ErrInsufficientSamples, "LDA components %d is smaller than n - c = %d", components, n-c)
 		}
+		if n < 2*c {
+			return nil, errors.Wrapf(ErrInsufficientSamples, "LDA needs n >= 2c and gets %d images for %d identities, use the %s or %s mode", n, c, RegularizedLDA, DirectLDA)
+		}
+		dimension = n - c
+	} else if components > 0 && components < dimension {
+		dimension = components
+	}
+	if dimension < c-1 {
+		return nil, errors.Wrapf(ErrInsufficientSamples, "LDA cannot compute %d components from a PCA of dimension %d", c-1, dimension)
 	}
-	c := len(tempSet)
-	if numOfComponents < n-c {
-		logger.Log("the input components is smaller than n - c!")
-		return l
+	if trainingSet[0].M < dimension {
+		return nil, errors.Wrapf(ErrDimensionMismatch, "LDA cannot compute a PCA of dimension %d from images of dimension %d", dimension, trainingSet[0].M)
 	}
-	if n < 2*c {
-		logger.Log("n is smaller than 2c!")
-		return l
+	pca, err := FitPCA(trainingSet, labels, dimension)
+	if err != nil {
+		return nil, errors.Wrap(err, "LDA")
 	}
-	// process in PCA
-	pca := NewPCA(trainingSet, labels, n-c)
-	// classify
-	meanTotal := algorithm.NewMatrix(n-c, 1)
+
+	sw, sb := l.Scatters(pca.FeatureExtraction.ProjectedTrainingSet, dimension)
+	var selectedEigenVectors *algorithm.Matrix
+	switch l.mode {
+	case DirectLDA:
+		selectedEigenVectors, err = l.direct(sw, sb, c-1)
+	case RegularizedLDA:
+		selectedEigenVectors, err = l.fisher(l.regularize(sw), sb, c-1)
+	default:
+		selectedEigenVectors, err = l.fisher(sw, sb, c-1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fe := NewFeatureExtraction()
+	fe.TrainingSet = trainingSet
+	fe.Labels = labels
+	fe.NumOfComponents = selectedEigenVectors.N
+	fe.MeanMatrix = pca.FeatureExtraction.MeanMatrix
+	fe.W = pca.FeatureExtraction.W.TimesMatrix(selectedEigenVectors)
+	// Construct projectedTrainingMatrix
+	fe.ProjectedTrainingSet = make([]*ProjectedTrainingMatrix, 0)
+	for i := 0; i < len(trainingSet); i++ {
+		ptm := NewProjectedTrainingMatrix(fe.Project(trainingSet[i]), labels[i])
+		fe.ProjectedTrainingSet = append(fe.ProjectedTrainingSet, ptm)
+	}
+	return fe, nil
+}
+
+// Scatters returns the within class and between class scatter matrices of
+// the projected training images.
+func (l *LDA) Scatters(projected []*ProjectedTrainingMatrix, dimension int) (*algorithm.Matrix, *algorithm.Matrix) {
+	meanTotal := algorithm.NewMatrix(dimension, 1)
 	mmap := make(map[string][]algorithm.Matrix, 0)
-	pcaTrain := pca.FeatureExtraction.ProjectedTrainingSet
-	for i := 0; i < len(pcaTrain); i++ {
-		key := pcaTrain[i].Label
-		meanTotal.PlusEqual(pcaTrain[i].Matrix)
-		if _, ok := mmap[key]; !ok {
-			temp := make([]algorithm.Matrix, 0)
-			temp = append(temp, *pcaTrain[i].Matrix)
-			mmap[key] = temp
-		} else {
-			temp := mmap[key]
-			temp = append(temp, *pcaTrain[i].Matrix)
-			mmap[key] = temp
-		}
+	for i := 0; i < len(projected); i++ {
+		key := projected[i].Label
+		meanTotal.PlusEqual(projected[i].Matrix)
+		mmap[key] = append(mmap[key], *projected[i].Matrix)
 	}
-	meanTotal.Times(1.0 / float64(n))
+	meanTotal = meanTotal.Times(1.0 / float64(len(projected)))
 
-	sw := algorithm.NewMatrix(n-c, n-c)
-	sb := algorithm.NewMatrix(n-c, n-c)
+	sw := algorithm.NewMatrix(dimension, dimension)
+	sb := algorithm.NewMatrix(dimension, dimension)
 	for key := range mmap {
 		matrixWithinThatClass := mmap[key]
 		meanOfCurrentClass := l.GetMean(matrixWithinThatClass)
@@ -63,28 +145,86 @@ func NewLDA(trainingSet []*algorithm.Matrix, labels []string, numOfComponents in
 		temp := meanOfCurrentClass.Minus(meanTotal)
 		temp = temp.TimesMatrix(temp.Transpose()).Times(float64(len(matrixWithinThatClass)))
 		sb.PlusEqual(temp)
+	}
+	return sw, sb
+}
 
+// regularize returns (1 - shrinkage) Sw + shrinkage trace(Sw) / d I, the
+// identity alone if Sw is null (one image per identity).
+func (l *LDA) regularize(sw *algorithm.Matrix) *algorithm.Matrix {
+	trace := 0.
+	for i := 0; i < sw.M; i++ {
+		trace += sw.A[i][i]
 	}
-	// calculate the eigenvalues and vectors of Sw^-1 * Sb
-	targetForEigen := sw.Inverse().TimesMatrix(sb)
-	feature := targetForEigen.Eig()
-	d := feature.Getd()
-	if len(d) < c-1 {
-		logger.Log("Ensure that the number of eigenvalues is larger than c - 1")
-		return l
+	scale := trace / float64(sw.M)
+	shrinkage := l.shrinkage
+	if scale <= 0 {
+		scale, shrinkage = 1., 1.
 	}
-	indexes := GetIndexesOfKEigenvalues(d, c-1)
-	eigenVectors := feature.GetV()
-	selectedEigenVectors := eigenVectors.GetMatrix2(0, eigenVectors.RowsDimension(), indexes)
-	l.FeatureExtraction.W = pca.FeatureExtraction.W.TimesMatrix(selectedEigenVectors)
-	// Construct projectedTrainingMatrix
-	l.FeatureExtraction.ProjectedTrainingSet = make([]*ProjectedTrainingMatrix, 0)
-	for i := 0; i < len(trainingSet); i++ {
-		ptm := NewProjectedTrainingMatrix(l.FeatureExtraction.W.Transpose().TimesMatrix(trainingSet[i].Minus(pca.FeatureExtraction.MeanMatrix)), labels[i])
-		l.FeatureExtraction.ProjectedTrainingSet = append(l.FeatureExtraction.ProjectedTrainingSet, ptm)
+	regularized := algorithm.NewMatrix(sw.M, sw.N)
+	for i := 0; i < sw.M; i++ {
+		for j := 0; j < sw.N; j++ {
+			regularized.A[i][j] = (1 - shrinkage) * sw.A[i][j]
+		}
+		regularized.A[i][i] += shrinkage * scale
 	}
-	l.FeatureExtraction.MeanMatrix = pca.FeatureExtraction.MeanMatrix
-	return l
+	return regularized
+}
+
+// fisher returns the k solutions of Sb v = λ Sw v with the largest
+// eigenvalues, normalized so that v^T Sw v = 1.
+func (l *LDA) fisher(sw, sb *algorithm.Matrix, k int) (*algorithm.Matrix, error) {
+	d, eigenVectors, err := algorithm.GeneralizedSymmetricEigen(sb, sw)
+	if err != nil {
+		return nil, errors.Wrap(err, "LDA within class scatter matrix, use a shrinkage")
+	}
+	if len(d) < k {
+		return nil, errors.Wrapf(ErrInsufficientSamples, "LDA has %d eigenvalues for %d components", len(d), k)
+	}
+	// the eigenvalues are in increasing order
+	indexes := make([]int, k)
+	for i := range indexes {
+		indexes[i] = len(d) - 1 - i
+	}
+	return eigenVectors.GetMatrix2(0, eigenVectors.RowsDimension()-1, indexes), nil
+}
+
+// direct returns the directions of the range of Sb whitened by its
+// eigenvalues, sorted by increasing within class scatter and weighted by its
+// inverse square root (Yu and Yang).
+func (l *LDA) direct(sw, sb *algorithm.Matrix, k int) (*algorithm.Matrix, error) {
+	d, v, err := topEigen(sb, k, AutoSolver)
+	if err != nil {
+		return nil, errors.Wrap(err, "LDA between class scatter matrix")
+	}
+	rank := 0
+	for rank < k && d[rank] > 1e-10*d[0] {
+		rank++
+	}
+	if rank == 0 {
+		return nil, errors.Wrap(ErrInsufficientSamples, "LDA between class scatter matrix is null")
+	}
+	// Z = Y Db^-1/2 so that Z^T Sb Z = I
+	z := algorithm.NewMatrix(sb.M, rank)
+	for c := 0; c < rank; c++ {
+		scale := 1. / math.Sqrt(d[c])
+		for i := 0; i < sb.M; i++ {
+			z.A[i][c] = v.A[i][c] * scale
+		}
+	}
+	within := z.TransposeTimesMatrix(sw).TimesMatrix(z).SymEig()
+	// A = Z U Dw^-1/2, the eigenvalues of the symmetric solver are in
+	// increasing order so the most discriminant directions come first
+	dw := within.GetRealEigenvalues()
+	epsilon := math.Max(1e-10*dw[len(dw)-1], 1e-12)
+	a := z.TimesMatrix(within.GetV())
+	for c := 0; c < a.N; c++ {
+		scale := 1. / math.Sqrt(math.Max(dw[c], epsilon))
+		for i := 0; i < a.M; i++ {
+			a.A[i][c] *= scale
+		}
+	}
+	return a, nil
 }
 
 func (l *LDA) GetMean(m []algorithm.Matrix) *algorithm.Matrix {
@@ -99,6 +239,37 @@ func (l *LDA) GetMean(m []algorithm.Matrix) *algorithm.Matrix {
 	for i := 0; i < num; i++ {
 		mean.PlusEqual(&m[i])
 	}
-	mean.Times(1.0 / float64(num))
-	return mean
+	return mean.Times(1.0 / float64(num))
+}
+
+func (l *LDA) Name() string {
+	return LDAFeatureType
+}
+
+func (l *LDA) Fit(trainingSet []*algorithm.Matrix, labels []string) error {
+	fe, err := l.fit(trainingSet, labels, l.components)
+	if err != nil {
+		return err
+	}
+	return l.FeatureExtraction.update(LDAFeatureType, fe)
+}
+
+func (l *LDA) Project(m *algorithm.Matrix) *algorithm.Matrix {
+	return l.FeatureExtraction.Project(m)
+}
+
+func (l *LDA) Save() (json.RawMessage, error) {
+	return l.FeatureExtraction.save()
+}
+
+func (l *LDA) Load(data json.RawMessage) error {
+	return l.FeatureExtraction.load(data)
+}
+
+func (l *LDA) ComponentVariances() []float64 {
+	return l.FeatureExtraction.Eigenvalues
+}
+
+func (l *LDA) Extraction() *FeatureExtraction {
+	return l.FeatureExtraction
 }
@@ -1,86 +1,140 @@
 package model
 
 import (
-	"github.com/cnf/structhash"
+	"encoding/json"
+	"math"
+	"sort"
+
 	"github.com/jeromelesaux/facerecognition/algorithm"
 	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/pkg/errors"
 )
 
 type LPP struct {
 	FeatureExtraction *FeatureExtraction
+	components        int     // requested number of components, n - c if 0
+	neighbors         int     // number of nearest neighbors joined in the graph
+	t                 float64 // heat kernel width, binary weights if 0
+	supervised        bool    // join only the images of the same label
 }
 
+// NewLPP computes the laplacianfaces with the 3 nearest neighbors graph and
+// binary weights, the feature extraction is empty if they cannot be computed.
 func NewLPP(trainingSet []*algorithm.Matrix, labels []string, numOfComponents int) *LPP {
-	lpp := &LPP{FeatureExtraction: NewFeatureExtraction()}
-	// n := len(trainingSet)
-	tempSet := make(map[string]int)
-	for i := 0; i < len(labels); i++ {
-		if _, ok := tempSet[labels[i]]; !ok {
-			tempSet[labels[i]] = 1
-		} else {
-			tempSet[labels[i]] += 1
-		}
+	lpp, err := FitLPP(trainingSet, labels, numOfComponents)
+	if err != nil {
+		logger.Log(err.Error())
+		return &LPP{FeatureExtraction: NewFeatureExtraction(), components: numOfComponents, neighbors: 3}
+	}
+	return lpp
+}
+
+// FitLPP returns the laplacianfaces of the training set, see NewLPP.
+func FitLPP(trainingSet []*algorithm.Matrix, labels []string, numOfComponents int) (*LPP, error) {
+	lpp := &LPP{FeatureExtraction: NewFeatureExtraction(), components: numOfComponents, neighbors: 3}
+	fe, err := lpp.fit(trainingSet, labels, numOfComponents)
+	if err != nil {
+		return nil, err
+	}
+	lpp.FeatureExtraction = fe
+	return lpp, nil
+}
+
+// fit returns the LPP of the training set projected on a PCA of components
+// dimensions : the solutions of X L X^T v = λ X D X^T v with the smallest
+// eigenvalues, the directions best preserving the neighborhood graph.
+func (lpp *LPP) fit(trainingSet []*algorithm.Matrix, labels []string, components int) (*FeatureExtraction, error) {
+	if err := checkTrainingSet(trainingSet, labels); err != nil {
+		return nil, errors.Wrap(err, "LPP")
+	}
+	n := len(trainingSet)
+	if n < 2 {
+		return nil, errors.Wrap(ErrInsufficientSamples, "LPP needs at least two training images")
+	}
+	if components < 1 || components >= n {
+		return nil, errors.Wrapf(ErrInsufficientSamples, "LPP cannot compute a PCA of dimension %d from %d images", components, n)
 	}
-	c := len(tempSet)
 	// process in PCA
-	pca := NewPCA(trainingSet, labels, numOfComponents)
+	pca, err := FitPCA(trainingSet, labels, components)
+	if err != nil {
+		return nil, errors.Wrap(err, "LPP")
+	}
+	projected := make([]*algorithm.Matrix, n)
+	for i, p := range pca.FeatureExtraction.ProjectedTrainingSet {
+		projected[i] = p.Matrix
+	}
 	// construct the nearest neighbor graph
-	s := construcNearestNeighborGraph(pca.FeatureExtraction.ProjectedTrainingSet)
+	s := NearestNeighborGraph(projected, labels, lpp.neighbors, lpp.t, lpp.supervised)
 	dd := constructD(s)
 	l := dd.Minus(s)
 	// reconstruct the trainingSet into required X;
-	x := constructTrainingMatrix(pca.FeatureExtraction.ProjectedTrainingSet)
+	x := constructTrainingMatrix(projected)
 	xlxt := x.TimesMatrix(l).TimesMatrix(x.Transpose())
 	xdxt := x.TimesMatrix(dd).TimesMatrix(x.Transpose())
 
-	// calculate the eignevalues and eigenvectors of (XDXT)^-1 * (XLXT)
-	targetForEigen := xdxt.Inverse().TimesMatrix(xlxt)
-	feature := targetForEigen.Eig()
-	d := feature.Getd()
-	if len(d) < c-1 {
-		logger.Logf("Ensure that the number of eigenvalues is larger than c - 1 (%d,%d)", len(d), (c - 1))
-		return lpp
-	}
-	indexes := GetIndexesOfKEigenvalues(d, len(d))
-	eigenVectors := feature.GetV()
-	selectedEigenVectors := eigenVectors.GetMatrix2(0, eigenVectors.RowsDimension()-1, indexes)
-	lpp.FeatureExtraction.W = pca.FeatureExtraction.W.TimesMatrix(selectedEigenVectors)
-	lpp.FeatureExtraction.ProjectedTrainingSet = make([]*ProjectedTrainingMatrix, 0)
+	// solve X L X^T v = λ X D X^T v, the eigenvalues are in increasing order
+	d, eigenVectors, err := algorithm.GeneralizedSymmetricEigen(xlxt, xdxt)
+	if err != nil {
+		return nil, errors.Wrap(err, "LPP matrix X D X^T, increase the number of neighbors")
+	}
+	if len(d) < components {
+		return nil, errors.Wrapf(ErrInsufficientSamples, "LPP has %d eigenvalues for %d components", len(d), components)
+	}
+	selectedEigenVectors := eigenVectors.GetMatrix3(0, eigenVectors.RowsDimension()-1, 0, components-1)
+
+	fe := NewFeatureExtraction()
+	fe.TrainingSet = trainingSet
+	fe.Labels = labels
+	fe.NumOfComponents = components
+	fe.MeanMatrix = pca.FeatureExtraction.MeanMatrix
+	fe.W = pca.FeatureExtraction.W.TimesMatrix(selectedEigenVectors)
 	// Construct projectedTrainingMatrix
+	fe.ProjectedTrainingSet = make([]*ProjectedTrainingMatrix, 0)
 	for i := 0; i < len(trainingSet); i++ {
-		ptm := NewProjectedTrainingMatrix(lpp.FeatureExtraction.W.Transpose().TimesMatrix(trainingSet[i].Minus(pca.FeatureExtraction.MeanMatrix)), labels[i])
-		lpp.FeatureExtraction.ProjectedTrainingSet = append(lpp.FeatureExtraction.ProjectedTrainingSet, ptm)
+		ptm := NewProjectedTrainingMatrix(fe.Project(trainingSet[i]), labels[i])
+		fe.ProjectedTrainingSet = append(fe.ProjectedTrainingSet, ptm)
 	}
-
-	lpp.FeatureExtraction.MeanMatrix = pca.FeatureExtraction.MeanMatrix
-	return lpp
+	return fe, nil
 }
 
-func construcNearestNeighborGraph(input []*ProjectedTrainingMatrix) *algorithm.Matrix {
-	size := len(input)
+// NearestNeighborGraph returns the symmetric weights of the graph joining each
+// point to its k nearest neighbors, the ones of the same label only if
+// supervised. The weight of an edge is exp(-|xi - xj|^2 / t), 1 if t is 0.
+func NearestNeighborGraph(points []*algorithm.Matrix, labels []string, k int, t float64, supervised bool) *algorithm.Matrix {
+	size := len(points)
 	s := algorithm.NewMatrix(size, size)
-	euclidean := &Euclidean{}
-	trainArray := NewSliceProjectedTrainingMatrix(input)
+	distances := algorithm.NewMatrix(size, size)
 	for i := 0; i < size; i++ {
-		neighbors := FindKNN(trainArray, input[i].Matrix, 3, euclidean.GetDistance)
-		hashIndexes := hashMatrices(neighbors)
-		for j := 0; j < len(neighbors); j++ {
-			hash := structhash.Md5(input[i], 1)
-			if index, ok := hashIndexes[string(hash)]; ok {
-				s.A[i][index] = 1.0
-				s.A[index][i] = 1.0
+		for j := i + 1; j < size; j++ {
+			d := 0.
+			for r := 0; r < points[i].M; r++ {
+				d += (points[i].A[r][0] - points[j].A[r][0]) * (points[i].A[r][0] - points[j].A[r][0])
 			}
+			distances.A[i][j] = d
+			distances.A[j][i] = d
 		}
 	}
-	return s
-}
-
-func hashMatrices(input []*ProjectedTrainingMatrix) map[string]int {
-	hashIndexes := make(map[string]int, len(input))
-	for i := 0; i < len(input); i++ {
-		hashIndexes[string(structhash.Md5(input[i], 1))] = i
+	for i := 0; i < size; i++ {
+		neighbors := make([]int, 0, size-1)
+		for j := 0; j < size; j++ {
+			if j != i && (!supervised || labels[i] == labels[j]) {
+				neighbors = append(neighbors, j)
+			}
+		}
+		sort.SliceStable(neighbors, func(a, b int) bool { return distances.A[i][neighbors[a]] < distances.A[i][neighbors[b]] })
+		if len(neighbors) > k {
+			neighbors = neighbors[:k]
+		}
+		for _, j := range neighbors {
+			w := 1.
+			if t > 0 {
+				w = math.Exp(-distances.A[i][j] / t)
+			}
+			s.A[i][j] = w
+			s.A[j][i] = w
+		}
 	}
-	return hashIndexes
+	return s
 }
 
 func constructD(s *algorithm.Matrix) *algorithm.Matrix {
@@ -96,14 +150,50 @@ func constructD(s *algorithm.Matrix) *algorithm.Matrix {
 	return d
 }
 
-func constructTrainingMatrix(input []*ProjectedTrainingMatrix) *algorithm.Matrix {
-	row := input[0].Matrix.RowsDimension()
+func constructTrainingMatrix(input []*algorithm.Matrix) *algorithm.Matrix {
+	row := input[0].RowsDimension()
 	column := len(input)
 	x := algorithm.NewMatrix(row, column)
 
 	for i := 0; i < column; i++ {
-		x.SetMatrix(0, row-1, i, i, input[i].Matrix)
+		x.SetMatrix(0, row-1, i, i, input[i])
 	}
 
 	return x
 }
+
+func (lpp *LPP) Name() string {
+	return LPPFeatureType
+}
+
+func (lpp *LPP) Fit(trainingSet []*algorithm.Matrix, labels []string) error {
+	components := lpp.components
+	if components == 0 {
+		components = defaultNumOfComponents(labels)
+	}
+	fe, err := lpp.fit(trainingSet, labels, components)
+	if err != nil {
+		return err
+	}
+	return lpp.FeatureExtraction.update(LPPFeatureType, fe)
+}
+
+func (lpp *LPP) Project(m *algorithm.Matrix) *algorithm.Matrix {
+	return lpp.FeatureExtraction.Project(m)
+}
+
+func (lpp *LPP) Save() (json.RawMessage, error) {
+	return lpp.FeatureExtraction.save()
+}
+
+func (lpp *LPP) Load(data json.RawMessage) error {
+	return lpp.FeatureExtraction.load(data)
+}
+
+func (lpp *LPP) ComponentVariances() []float64 {
+	return lpp.FeatureExtraction.Eigenvalues
+}
+
+func (lpp *LPP) Extraction() *FeatureExtraction {
+	return lpp.FeatureExtraction
+}
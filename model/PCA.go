@@ -1,30 +1,98 @@
 package model
 
 import (
+	"encoding/json"
 	"github.com/jeromelesaux/facerecognition/algorithm"
 	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/pkg/errors"
 	"math"
 	"strconv"
 )
 
+var (
+	CountSelection    = "count"
+	VarianceSelection = "variance"
+	ElbowSelection    = "elbow"
+
+	// AutoSolver computes the eigenvectors with the Lanczos solver for the
+	// large training sets and the count selection, with the full one otherwise.
+	AutoSolver = "auto"
+	// FullSolver computes all the eigenvalues, see algorithm.SymEig.
+	FullSolver = "full"
+	// LanczosSolver computes only the eigenvalues kept, see algorithm.TopEigen.
+	LanczosSolver = "lanczos"
+	// SVDSolver computes the thin singular value decomposition of the centered
+	// images instead of the eigenvectors of X^T X, whose condition number is
+	// the square of the one of X.
+	SVDSolver = "svd"
+)
+
 type PCA struct {
 	FeatureExtraction *FeatureExtraction
+	components        int     // requested number of components, n - c if 0
+	selection         string  // CountSelection, VarianceSelection or ElbowSelection
+	variance          float64 // explained variance ratio of the VarianceSelection
+	solver            string  // AutoSolver, FullSolver, LanczosSolver or SVDSolver
 }
 
 func NewPCA(trainingSet []*algorithm.Matrix, labels []string, numOfComponents int) *PCA {
 	p := &PCA{FeatureExtraction: NewFeatureExtraction()}
+	if err := p.compute(trainingSet, labels, numOfComponents); err != nil {
+		logger.Log(err.Error())
+		p.FeatureExtraction.W = &algorithm.Matrix{}
+	}
+	return p
+}
+
+// FitPCA returns the PCA of numOfComponents components of the training set.
+func FitPCA(trainingSet []*algorithm.Matrix, labels []string, numOfComponents int) (*PCA, error) {
+	p := &PCA{FeatureExtraction: NewFeatureExtraction()}
+	if err := p.compute(trainingSet, labels, numOfComponents); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// checkTrainingSet returns an error if the training set is empty, if its
+// images have not the same dimension or if the labels do not match them.
+func checkTrainingSet(trainingSet []*algorithm.Matrix, labels []string) error {
+	if len(trainingSet) == 0 {
+		return errors.Wrap(ErrInsufficientSamples, "training set is empty")
+	}
+	if len(labels) != len(trainingSet) {
+		return errors.Wrapf(ErrDimensionMismatch, "%d training images and %d labels", len(trainingSet), len(labels))
+	}
+	for i := range trainingSet {
+		if trainingSet[i].M != trainingSet[0].M || trainingSet[i].N != 1 {
+			return errors.Wrapf(ErrDimensionMismatch, "training image %d is %dx%d and image 0 %dx1", i, trainingSet[i].M, trainingSet[i].N, trainingSet[0].M)
+		}
+	}
+	return nil
+}
+
+// compute fits the PCA on the training set, numOfComponents is the number of
+// components of the CountSelection.
+func (p *PCA) compute(trainingSet []*algorithm.Matrix, labels []string, numOfComponents int) error {
+	if err := checkTrainingSet(trainingSet, labels); err != nil {
+		return err
+	}
 	p.FeatureExtraction.TrainingSet = trainingSet
 	p.FeatureExtraction.Labels = labels
 	p.FeatureExtraction.NumOfComponents = numOfComponents
 	p.FeatureExtraction.MeanMatrix = p.GetMean(p.FeatureExtraction.TrainingSet)
-	p.FeatureExtraction.W = p.GetFeature(p.FeatureExtraction.TrainingSet, p.FeatureExtraction.NumOfComponents)
+	w, err := p.getFeature(p.FeatureExtraction.TrainingSet, p.FeatureExtraction.NumOfComponents)
+	if err != nil {
+		return err
+	}
+	p.FeatureExtraction.W = w
+	p.FeatureExtraction.NumOfComponents = w.N
 	// Construct projectedTrainingMatrix
 	p.FeatureExtraction.ProjectedTrainingSet = make([]*ProjectedTrainingMatrix, 0)
 	for i := 0; i < len(trainingSet); i++ {
-		ptm := NewProjectedTrainingMatrix(p.FeatureExtraction.W.Transpose().TimesMatrix(trainingSet[i].Minus(p.FeatureExtraction.MeanMatrix)), labels[i])
+		ptm := NewProjectedTrainingMatrix(p.FeatureExtraction.W.TransposeTimesMatrix(trainingSet[i].Minus(p.FeatureExtraction.MeanMatrix)), labels[i])
 		p.FeatureExtraction.ProjectedTrainingSet = append(p.FeatureExtraction.ProjectedTrainingSet, ptm)
 	}
-	return p
+	return nil
 }
 
 func (p *PCA) GetMean(input []*algorithm.Matrix) *algorithm.Matrix {
@@ -39,6 +107,17 @@ func (p *PCA) GetMean(input []*algorithm.Matrix) *algorithm.Matrix {
 }
 
 func (p *PCA) GetFeature(input []*algorithm.Matrix, k int) *algorithm.Matrix {
+	w, err := p.getFeature(input, k)
+	if err != nil {
+		logger.Log(err.Error())
+		return &algorithm.Matrix{}
+	}
+	return w
+}
+
+// getFeature returns the k normalized eigenvectors of the scatter matrix of
+// input with the largest eigenvalues.
+func (p *PCA) getFeature(input []*algorithm.Matrix, k int) (*algorithm.Matrix, error) {
 	row := input[0].RowsDimension()
 	column := len(input)
 	//logger.Log("row,column:"+strconv.Itoa(row)+","+strconv.Itoa(column))
@@ -48,18 +127,62 @@ func (p *PCA) GetFeature(input []*algorithm.Matrix, k int) *algorithm.Matrix {
 		x.SetMatrix(0, row-1, i, i, input[i].Minus(p.FeatureExtraction.MeanMatrix))
 	}
 
-	xt := x.Transpose()
-	xtx := xt.TimesMatrix(x)
-	feature := xtx.Eig()
-	d := feature.Getd()
-
-	if len(d) < k {
-		logger.Log("number of eigenvalues is less than K")
-		return &algorithm.Matrix{}
+	scale := math.Max(float64(column-1), 1)
+	var values []float64
+	var selectedEigenVectors *algorithm.Matrix
+	switch {
+	case p.solver == SVDSolver:
+		// X = U S V^T, the eigenvectors of the scatter matrix X X^T are the
+		// columns of U and its eigenvalues the squared singular values
+		svd := x.SVD()
+		singularValues := svd.GetSingularValues()
+		p.FeatureExtraction.Spectrum = make([]float64, len(singularValues))
+		for i := range p.FeatureExtraction.Spectrum {
+			p.FeatureExtraction.Spectrum[i] = singularValues[i] * singularValues[i] / scale
+		}
+		k = p.selectComponents(k)
+		if k < 1 || len(p.FeatureExtraction.Spectrum) < k {
+			return nil, errors.Wrapf(ErrInsufficientSamples, "PCA has %d singular values for %d components", len(p.FeatureExtraction.Spectrum), k)
+		}
+		values = make([]float64, k)
+		for i := range values {
+			values[i] = singularValues[i] * singularValues[i]
+		}
+		u := svd.GetU()
+		selectedEigenVectors = u.GetMatrix3(0, u.RowsDimension()-1, 0, k-1)
+	case p.selection == CountSelection && truncatedSolver(p.solver, column, k):
+		// the spectrum is unknown, only the k eigenvalues are computed
+		p.FeatureExtraction.Spectrum = nil
+		var vectors *algorithm.Matrix
+		var err error
+		if values, vectors, err = algorithm.TopEigen(x.TransposeTimesMatrix(x), k); err != nil {
+			return nil, errors.Wrap(ErrInsufficientSamples, err.Error())
+		}
+		selectedEigenVectors = x.TimesMatrix(vectors)
+	default:
+		feature := x.TransposeTimesMatrix(x).SymEig()
+		d := feature.Getd()
+		// the eigenvalues of X^T X are the ones of X X^T, the scatter matrix
+		p.FeatureExtraction.Spectrum = make([]float64, len(d))
+		for i, index := range GetIndexesOfKEigenvalues(d, len(d)) {
+			p.FeatureExtraction.Spectrum[i] = math.Max(d[index], 0) / scale
+		}
+		k = p.selectComponents(k)
+		if k < 1 || len(d) < k {
+			return nil, errors.Wrapf(ErrInsufficientSamples, "PCA has %d eigenvalues for %d components", len(d), k)
+		}
+		indexes := GetIndexesOfKEigenvalues(d, k)
+		values = make([]float64, k)
+		for i, index := range indexes {
+			values[i] = d[index]
+		}
+		v := feature.GetV()
+		selectedEigenVectors = x.TimesMatrix(v.GetMatrix2(0, v.RowsDimension()-1, indexes))
+	}
+	p.FeatureExtraction.Eigenvalues = make([]float64, k)
+	for i := range values {
+		p.FeatureExtraction.Eigenvalues[i] = values[i] / scale
 	}
-	indexes := GetIndexesOfKEigenvalues(d, k)
-	eigenVectors := x.TimesMatrix(feature.GetV())
-	selectedEigenVectors := eigenVectors.GetMatrix2(0, eigenVectors.RowsDimension()-1, indexes)
 	// normalize the eigenvectors
 	row = selectedEigenVectors.RowsDimension()
 	column = selectedEigenVectors.ColumnsDimension()
@@ -73,5 +196,108 @@ func (p *PCA) GetFeature(input []*algorithm.Matrix, k int) *algorithm.Matrix {
 			selectedEigenVectors.A[j][i] /= temp
 		}
 	}
-	return selectedEigenVectors
+	return selectedEigenVectors, nil
+}
+
+// selectComponents returns the number of components of the selection given
+// the spectrum, k for the count selection.
+func (p *PCA) selectComponents(k int) int {
+	switch p.selection {
+	case VarianceSelection:
+		return ComponentsByVariance(p.FeatureExtraction.Spectrum, p.variance)
+	case ElbowSelection:
+		return ComponentsByElbow(p.FeatureExtraction.Spectrum)
+	}
+	return k
+}
+
+func (p *PCA) Name() string {
+	return PCAFeatureType
+}
+
+func (p *PCA) Fit(trainingSet []*algorithm.Matrix, labels []string) error {
+	components := p.components
+	if components == 0 {
+		components = defaultNumOfComponents(labels)
+	}
+	fitted := &PCA{FeatureExtraction: NewFeatureExtraction(), selection: p.selection, variance: p.variance, solver: p.solver}
+	if err := fitted.compute(trainingSet, labels, components); err != nil {
+		return err
+	}
+	return p.FeatureExtraction.update(PCAFeatureType, fitted.FeatureExtraction)
+}
+
+func (p *PCA) Project(m *algorithm.Matrix) *algorithm.Matrix {
+	return p.FeatureExtraction.Project(m)
+}
+
+func (p *PCA) Save() (json.RawMessage, error) {
+	return p.FeatureExtraction.save()
+}
+
+func (p *PCA) Load(data json.RawMessage) error {
+	return p.FeatureExtraction.load(data)
+}
+
+func (p *PCA) ComponentVariances() []float64 {
+	return p.FeatureExtraction.Eigenvalues
+}
+
+func (p *PCA) Spectrum() []float64 {
+	return p.FeatureExtraction.Spectrum
+}
+
+func (p *PCA) Extraction() *FeatureExtraction {
+	return p.FeatureExtraction
+}
+
+// ExplainedVariance returns the ratio of the total variance explained by the
+// first 1, 2, ... components of the spectrum sorted in decreasing order.
+func ExplainedVariance(spectrum []float64) []float64 {
+	total := 0.
+	for _, v := range spectrum {
+		total += v
+	}
+	explained := make([]float64, len(spectrum))
+	sum := 0.
+	for i, v := range spectrum {
+		sum += v
+		if total > 0 {
+			explained[i] = sum / total
+		}
+	}
+	return explained
+}
+
+// ComponentsByVariance returns the smallest number of components explaining
+// at least the ratio of the total variance.
+func ComponentsByVariance(spectrum []float64, ratio float64) int {
+	for i, explained := range ExplainedVariance(spectrum) {
+		if explained >= ratio-1e-12 {
+			return i + 1
+		}
+	}
+	return len(spectrum)
+}
+
+// ComponentsByElbow returns the number of components at the elbow of the scree
+// plot of the positive eigenvalues : the point farthest from the line joining
+// the first and the last ones.
+func ComponentsByElbow(spectrum []float64) int {
+	m := 0
+	for m < len(spectrum) && spectrum[m] > 1e-10*spectrum[0] {
+		m++
+	}
+	if m < 3 {
+		return m
+	}
+	first, last := spectrum[0], spectrum[m-1]
+	best, elbow := 0., 1
+	for i := 1; i < m-1; i++ {
+		d := math.Abs((last-first)*float64(i) - float64(m-1)*(spectrum[i]-first))
+		if d > best {
+			best, elbow = d, i+1
+		}
+	}
+	return elbow
 }
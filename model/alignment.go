@@ -0,0 +1,162 @@
+package model
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/pkg/errors"
+)
+
+var (
+	// canonical eye positions relative to the size of the face
+	leftEyeX  = 0.3
+	rightEyeX = 0.7
+	eyesY     = 0.38
+)
+
+// AlignmentPolicy is the geometric normalization of the faces, applied by the
+// trainer to the images before the preprocessing chain. It is persisted in the
+// trained model so the probes are aligned as the training images.
+type AlignmentPolicy struct {
+	Align      bool   `json:"align"`           // rotate and scale the faces to put their eyes at canonical positions
+	Mask       bool   `json:"elliptical_mask"` // set the pixels outside the ellipse inscribed in the face to black
+	EyeCascade string `json:"eye_cascade"`     // OpenCV Haar cascade of the eyes, as haarcascade_eye.xml, required to align
+}
+
+// check returns an error if the eye cascade of the policy cannot be read.
+func (p AlignmentPolicy) check() error {
+	if !p.Align {
+		return nil
+	}
+	_, err := eyeDetector(p.EyeCascade)
+	return errors.Wrap(err, "cannot align the faces")
+}
+
+// Apply returns the image m vectorized by columns, of width x height pixels,
+// aligned and masked as required by the policy. If the eyes are not found the
+// error is ErrEyesNotFound and the image is returned masked only.
+func (p AlignmentPolicy) Apply(m *algorithm.Matrix, width, height int) (*algorithm.Matrix, error) {
+	if !p.Align && !p.Mask {
+		return m, nil
+	}
+	if width <= 0 || height <= 0 || m.N != 1 || m.M != width*height {
+		return nil, errors.Wrapf(ErrDimensionMismatch, "cannot align an image of %dx%d as %dx%d pixels", m.M, m.N, width, height)
+	}
+	face := ToImage(imageOfVector(m, width, height))
+	var alignErr error
+	if p.Align {
+		detector, err := eyeDetector(p.EyeCascade)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot align the face")
+		}
+		aligned, err := AlignFace(face, detector)
+		if err == nil {
+			face = aligned
+		}
+		alignErr = err
+	}
+	if p.Mask {
+		face = MaskFace(face)
+	}
+	return GrayMatrix(face).Vectorize(), alignErr
+}
+
+// LocateEyes returns the centers of the eyes of the face found by the
+// detector, the best detections of the left and right halves of the face.
+// The error is ErrEyesNotFound if an eye is missing or if they are not
+// placed like eyes, too close or too tilted.
+func LocateEyes(face *image.Gray, detector *EyeDetector) (left, right image.Point, err error) {
+	b := face.Bounds()
+	middle := b.Min.X + b.Dx()/2
+	leftFound, rightFound := false, false
+	for _, eye := range detector.Detect(face) {
+		c := center(eye)
+		if c.X < middle && !leftFound {
+			left, leftFound = c, true
+		} else if c.X >= middle && !rightFound {
+			right, rightFound = c, true
+		}
+	}
+	if !leftFound || !rightFound {
+		return left, right, errors.Wrapf(ErrEyesNotFound, "left eye found %t, right eye found %t", leftFound, rightFound)
+	}
+	dx, dy := right.X-left.X, right.Y-left.Y
+	if 4*dx < b.Dx() || 10*dx > 7*b.Dx() || 2*abs(dy) > dx {
+		return left, right, errors.Wrapf(ErrEyesNotFound, "eyes at %v and %v are not placed like eyes", left, right)
+	}
+	return left, right, nil
+}
+
+// AlignFace returns the face rotated and scaled so that its eyes are at the
+// canonical positions, the error of LocateEyes if they cannot be located.
+func AlignFace(face *image.Gray, detector *EyeDetector) (*image.Gray, error) {
+	left, right, err := LocateEyes(face, detector)
+	if err != nil {
+		return nil, err
+	}
+	b := face.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	// similarity mapping the canonical eyes onto the located ones
+	ax, ay := leftEyeX*w, eyesY*h
+	distance := (rightEyeX - leftEyeX) * w
+	cos := float64(right.X-left.X) / distance
+	sin := float64(right.Y-left.Y) / distance
+	aligned := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			dx, dy := float64(x)-ax, float64(y)-ay
+			sx := float64(left.X) + cos*dx - sin*dy
+			sy := float64(left.Y) + sin*dx + cos*dy
+			aligned.SetGray(x, y, bilinear(face, sx, sy))
+		}
+	}
+	return aligned, nil
+}
+
+// MaskFace returns a copy of the face with the pixels outside its inscribed
+// ellipse set to black, they carry the background and the hair.
+func MaskFace(face *image.Gray) *image.Gray {
+	b := face.Bounds()
+	masked := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(masked, masked.Bounds(), face, b.Min, draw.Src)
+	cx, cy := float64(b.Dx())/2, float64(b.Dy())/2
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			u, v := (float64(x)+0.5-cx)/cx, (float64(y)+0.5-cy)/cy
+			if u*u+v*v > 1 {
+				masked.SetGray(x, y, color.Gray{})
+			}
+		}
+	}
+	return masked
+}
+
+// bilinear returns the gray level of img interpolated at (x, y), the borders
+// are extended outside the image.
+func bilinear(img *image.Gray, x, y float64) color.Gray {
+	b := img.Bounds()
+	x = math.Max(float64(b.Min.X), math.Min(x, float64(b.Max.X-1)))
+	y = math.Max(float64(b.Min.Y), math.Min(y, float64(b.Max.Y-1)))
+	x0, y0 := int(x), int(y)
+	x1, y1 := x0+1, y0+1
+	if x1 >= b.Max.X {
+		x1 = x0
+	}
+	if y1 >= b.Max.Y {
+		y1 = y0
+	}
+	fx, fy := x-float64(x0), y-float64(y0)
+	top := (1-fx)*float64(img.GrayAt(x0, y0).Y) + fx*float64(img.GrayAt(x1, y0).Y)
+	bottom := (1-fx)*float64(img.GrayAt(x0, y1).Y) + fx*float64(img.GrayAt(x1, y1).Y)
+	return color.Gray{uint8(math.Round((1-fy)*top + fy*bottom))}
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"math"
+	"sort"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/logger"
+)
+
+// Candidate is a person proposed by RecognizeTopN.
+type Candidate struct {
+	Label          string   `json:"label"`
+	Distance       float64  `json:"distance"`        // distance to the nearest training image of the person
+	Votes          int      `json:"votes"`           // number of training images of the person in the K nearest neighbors
+	Score          float64  `json:"score"`           // similarity normalized over all the persons of the model
+	TrainingImages []string `json:"training_images"` // sources of the training images the score is computed from
+}
+
+// RecognizeTopN returns the n most similar persons of matrix, the most similar
+// first. A person similarity is the inverse distance sum of its training
+// images in the K nearest neighbors (the one used by Classify) or the inverse
+// distance of its nearest training image if it has no vote.
+// All the persons are returned if n <= 0.
+func (t *Trainer) RecognizeTopN(matrix *algorithm.Matrix, n int) []*Candidate {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if err := t.checkProbe(matrix); err != nil {
+		logger.Logf("cannot recognize : %v", err)
+		return make([]*Candidate, 0)
+	}
+	testCase := t.project(matrix)
+	neighbors := make([]*ProjectedTrainingMatrix, len(t.Model))
+	for i, p := range t.Model {
+		neighbors[i] = &ProjectedTrainingMatrix{Matrix: p.Matrix, Label: p.Label, Source: p.Source, Distance: t.Metric(p.Matrix, testCase)}
+	}
+	sort.SliceStable(neighbors, func(i, j int) bool { return neighbors[i].Distance < neighbors[j].Distance })
+
+	candidates := make([]*Candidate, 0)
+	byLabel := make(map[string]*Candidate)
+	similarities := make(map[string]float64)
+	for i, p := range neighbors {
+		c, ok := byLabel[p.Label]
+		if !ok {
+			// neighbors are sorted, the first one is the nearest
+			c = &Candidate{Label: p.Label, Distance: p.Distance, TrainingImages: make([]string, 0)}
+			byLabel[p.Label] = c
+			candidates = append(candidates, c)
+		}
+		if i < t.K {
+			c.Votes++
+		} else if ok {
+			continue
+		}
+		similarities[p.Label] += 1 / p.Distance
+		if p.Source != "" {
+			c.TrainingImages = append(c.TrainingImages, p.Source)
+		}
+	}
+
+	total := 0.
+	for _, s := range similarities {
+		total += s
+	}
+	for _, c := range candidates {
+		// a null distance gives an infinite similarity
+		if math.IsInf(total, 1) {
+			if math.IsInf(similarities[c.Label], 1) {
+				c.Score = 1
+			}
+		} else if total > 0 {
+			c.Score = similarities[c.Label] / total
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Distance < candidates[j].Distance
+	})
+	if n > 0 && n < len(candidates) {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
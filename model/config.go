@@ -10,14 +10,62 @@ import (
 )
 
 type Config struct {
-	FaceDetectionConfigurationFile string `json:"opencvfile"`
-	FaceRecognitionBasePath        string `json:"facerecognitionbasepath"`
+	FaceDetectionConfigurationFile string        `json:"opencvfile"`
+	FaceRecognitionBasePath        string        `json:"facerecognitionbasepath"`
+	FeatureType                    string        `json:"feature_type"`       // registered feature extractor, PCA if empty
+	FeatureParameters              Parameters    `json:"feature_parameters"` // parameters of the feature extractor
+	Metric                         string        `json:"metric"`             // registered metric, the default one of the feature extractor if empty
+	Preprocessing                  Preprocessing `json:"preprocessing"`      // illumination normalization of the images, none if empty
+	OpenSet                        bool          `json:"openset"`
+	RejectionThreshold             float64       `json:"rejection_threshold"`
+	ThresholdPerIdentity           bool          `json:"threshold_per_identity"`
+	FaceWidth                      int           `json:"face_width"`  // width of the normalized faces, 92 if 0
+	FaceHeight                     int           `json:"face_height"` // height of the normalized faces, 92 if 0
+	RetrainPolicy
+	AlignmentPolicy
 }
 
 func (conf *Config) GetDataLib() string {
 	return conf.FaceRecognitionBasePath + separator + "data_library.json"
 }
 
+func (conf *Config) GetFeatureType() string {
+	if conf.FeatureType == "" {
+		return PCAFeatureType
+	}
+	return conf.FeatureType
+}
+
+func (conf *Config) GetMetric() string {
+	if conf.Metric == "" {
+		return DefaultMetric(conf.GetFeatureType())
+	}
+	return conf.Metric
+}
+
+// GetFaceSize returns the size of the normalized faces, the faces of the
+// library and the images of the trained models have this size.
+func (conf *Config) GetFaceSize() (int, int) {
+	width, height := defaultFaceWidth, defaultFaceHeight
+	if conf != nil && conf.FaceWidth > 0 {
+		width = conf.FaceWidth
+	}
+	if conf != nil && conf.FaceHeight > 0 {
+		height = conf.FaceHeight
+	}
+	return width, height
+}
+
+func (conf *Config) GetTrainedModel() string {
+	return conf.FaceRecognitionBasePath + separator + "trained_model.json"
+}
+
+// GetOriginalsDirectory returns the directory keeping the stored faces as they
+// were before their normalization to another face size.
+func (conf *Config) GetOriginalsDirectory() string {
+	return conf.FaceRecognitionBasePath + separator + "originals" + separator
+}
+
 func (conf *Config) GetTmpDirectory() string {
 	return conf.FaceRecognitionBasePath + separator + "tmp" + separator
 }
@@ -31,6 +79,9 @@ var (
 	configLoadOnce sync.Once
 	configFile     string
 	separator      = string(filepath.Separator)
+	// default size of the normalized faces
+	defaultFaceWidth  = 92
+	defaultFaceHeight = 92
 )
 
 func SetConfigFile(filepath string) {
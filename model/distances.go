@@ -1,15 +1,36 @@
 package model
 
 import (
-	"github.com/jeromelesaux/facerecognition/algorithm"
 	"math"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
 )
 
 var MAX_FLOAT_VALUE = 10000000.
 
+var (
+	L1Metric                  = "L1"
+	EuclideanMetric           = "Euclidean"
+	CosineDissimilarityMetric = "CosineDissimilarity"
+)
+
+// GetMetric returns the distance function of the metric registered under
+// name, a fitted metric must be set with Trainer.SetMetric to be fitted.
+func GetMetric(name string) (func(a, b *algorithm.Matrix) float64, error) {
+	m, err := NewMetric(name)
+	if err != nil {
+		return nil, err
+	}
+	return m.GetDistance, nil
+}
+
 type CosineDissimilarity struct {
 }
 
+func (c *CosineDissimilarity) Name() string {
+	return CosineDissimilarityMetric
+}
+
 func (c *CosineDissimilarity) GetDistance(a, b *algorithm.Matrix) float64 {
 	if a.RowsDimension() != b.RowsDimension() {
 		return MAX_FLOAT_VALUE
@@ -47,6 +68,10 @@ func (c *CosineDissimilarity) GetDistance(a, b *algorithm.Matrix) float64 {
 type Euclidean struct {
 }
 
+func (e *Euclidean) Name() string {
+	return EuclideanMetric
+}
+
 func (e *Euclidean) GetDistance(a, b *algorithm.Matrix) float64 {
 	size := a.RowsDimension()
 	sum := 0.
@@ -60,6 +85,10 @@ func (e *Euclidean) GetDistance(a, b *algorithm.Matrix) float64 {
 type L1 struct {
 }
 
+func (l *L1) Name() string {
+	return L1Metric
+}
+
 func (l *L1) GetDistance(a, b *algorithm.Matrix) float64 {
 	size := a.RowsDimension()
 	sum := 0.
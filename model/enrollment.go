@@ -0,0 +1,227 @@
+package model
+
+import (
+	"math"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/pkg/errors"
+)
+
+// RetrainPolicy decides when the images enrolled without retraining justify a
+// full retraining of the feature extraction.
+type RetrainPolicy struct {
+	MaxPending int     `json:"retrain_max_pending"` // number of enrolled images, 0 disables the rule
+	MaxDrift   float64 `json:"retrain_max_drift"`   // mean relative reconstruction error of the enrolled images, 0 disables the rule
+}
+
+type enrollment struct {
+	pending int
+	drift   float64
+	done    chan struct{} // closed at the end of the background retraining, nil if none
+}
+
+// Enroll projects the image m of label in the current feature space and adds it
+// to the model, the person can be recognized immediately. A retraining is
+// started in background when the retrain policy requires it.
+func (t *Trainer) Enroll(m *algorithm.Matrix, label string, source string) error {
+	return t.EnrollImages([]*algorithm.Matrix{m}, []string{label}, []string{source})
+}
+
+// EnrollImages enrolls the images of labels read from the files sources as
+// Enroll, all of them or none. The rejection threshold is calibrated once for
+// all the images.
+func (t *Trainer) EnrollImages(images []*algorithm.Matrix, labels []string, sources []string) error {
+	if len(labels) != len(images) || len(sources) != len(images) {
+		return errors.Errorf("cannot enroll %d images with %d labels and %d sources", len(images), len(labels), len(sources))
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, m := range images {
+		if err := t.checkProbe(m); err != nil {
+			return errors.Wrap(err, "cannot enroll")
+		}
+	}
+	if len(images) == 0 {
+		return nil
+	}
+	t.forgetMetricTrainers()
+	for i, m := range images {
+		t.TrainingSet = append(t.TrainingSet, m)
+		t.TrainingLabels = append(t.TrainingLabels, labels[i])
+		t.TrainingSources = append(t.TrainingSources, sources[i])
+		ptm := NewProjectedTrainingMatrix(t.project(m), labels[i])
+		ptm.Source = sources[i]
+		t.Model = append(t.Model, ptm)
+		if !t.incremental() {
+			t.enrollment.pending++
+			t.enrollment.drift += t.residual(m)
+		}
+	}
+	t.FeatureExtraction.ProjectedTrainingSet = t.Model
+
+	if t.RejectionThreshold <= 0 {
+		if err := t.Calibrate(); err != nil {
+			logger.Logf("cannot calibrate the rejection threshold after enrollment : %v", err)
+		}
+	}
+	if t.retrainRequired() && t.enrollment.done == nil {
+		t.enrollment.done = make(chan struct{})
+		go t.retrain(t.enrollment.done)
+	}
+	return nil
+}
+
+// Drift returns the mean relative reconstruction error of the images enrolled
+// since the last training, 0 means they are perfectly described by the feature
+// space.
+func (t *Trainer) Drift() float64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.drift()
+}
+
+func (t *Trainer) drift() float64 {
+	if t.enrollment.pending == 0 {
+		return 0.
+	}
+	return t.enrollment.drift / float64(t.enrollment.pending)
+}
+
+func (t *Trainer) retrainRequired() bool {
+	p := t.RetrainPolicy
+	return (p.MaxPending > 0 && t.enrollment.pending >= p.MaxPending) ||
+		(p.MaxDrift > 0 && t.drift() > p.MaxDrift)
+}
+
+// residual returns the distance between m and its reconstruction from the
+// feature space, relative to the distance between m and the mean face. It is
+// 0 for the extractors not linear.
+func (t *Trainer) residual(m *algorithm.Matrix) float64 {
+	w := t.FeatureExtraction.W
+	if w == nil || w.M == 0 {
+		return 0.
+	}
+	centered := t.preprocess(m).Minus(t.FeatureExtraction.MeanMatrix)
+	// orthogonal projection on the columns of W : W (W^T W)^-1 W^T x
+	coefficients := w.TransposeTimesMatrix(w).Solve(w.TransposeTimesMatrix(centered))
+	if coefficients.M == 0 {
+		return 0.
+	}
+	diff := centered.Minus(w.TimesMatrix(coefficients))
+	norm, errorNorm := 0., 0.
+	for i := 0; i < centered.M; i++ {
+		norm += centered.A[i][0] * centered.A[i][0]
+		errorNorm += diff.A[i][0] * diff.A[i][0]
+	}
+	if norm == 0 {
+		return 0.
+	}
+	return math.Sqrt(errorNorm / norm)
+}
+
+// retrain trains a new feature extraction from all the training images and
+// replaces the current one, the images enrolled meanwhile are projected in
+// the new feature space. done is closed when the new feature extraction is in
+// place and AfterRetrain returned, or when the retraining failed.
+func (t *Trainer) retrain(done chan struct{}) {
+	defer func() {
+		t.lock.Lock()
+		if t.enrollment.done == done {
+			t.enrollment.done = nil
+		}
+		t.lock.Unlock()
+		close(done)
+	}()
+	t.lock.RLock()
+	rt := NewTrainerArgs(t.FeatureType, t.K, t.NumOfComponents, t.Metric)
+	rt.MetricName = t.MetricName
+	rt.FeatureParameters = t.FeatureParameters.Copy()
+	rt.Alignment = t.Alignment
+	rt.Preprocessing = t.Preprocessing
+	rt.Width = t.Width
+	rt.Height = t.Height
+	rt.OpenSet = t.OpenSet
+	rt.RejectionThreshold = t.RejectionThreshold
+	rt.PerIdentityThreshold = t.PerIdentityThreshold
+	// a trainer loaded from a file only keeps the images enrolled since,
+	// the other ones are read again from their sources
+	missing := make([]*ProjectedTrainingMatrix, 0)
+	if len(t.TrainingSet) < len(t.Model) {
+		missing = append(missing, t.Model[:len(t.Model)-len(t.TrainingSet)]...)
+	}
+	n := len(t.TrainingSet)
+	set := append(make([]*algorithm.Matrix, 0, n), t.TrainingSet...)
+	labels := append(make([]string, 0, n), t.TrainingLabels...)
+	sources := append(make([]string, 0, n), t.TrainingSources...)
+	t.lock.RUnlock()
+
+	err := func() error {
+		for _, p := range missing {
+			if p.Source == "" {
+				return errors.Errorf("training image of %s has no source", p.Label)
+			}
+			m, err := ReadMatrix(p.Source)
+			if err != nil {
+				return err
+			}
+			rt.AddWithSource(m.Vectorize(), p.Label, p.Source)
+		}
+		for i := range set {
+			rt.AddWithSource(set[i], labels[i], sources[i])
+		}
+		logger.Logf("retraining %s with %d images", rt.FeatureType, len(rt.TrainingSet))
+		return rt.Train()
+	}()
+
+	t.lock.Lock()
+	if err != nil {
+		t.lock.Unlock()
+		logger.Logf("cannot retrain : %v", err)
+		return
+	}
+	// images enrolled while retraining
+	enrolled := len(t.TrainingSet) - n
+	t.TrainingSet = append(rt.TrainingSet, t.TrainingSet[n:]...)
+	t.TrainingLabels = append(rt.TrainingLabels, t.TrainingLabels[n:]...)
+	t.TrainingSources = append(rt.TrainingSources, t.TrainingSources[n:]...)
+	t.Extractor = rt.Extractor
+	t.FeatureExtraction = rt.FeatureExtraction
+	t.DistanceMetric = rt.DistanceMetric
+	t.Metric = rt.Metric
+	t.Model = rt.Model
+	t.enrollment = enrollment{done: t.enrollment.done}
+	for i := len(t.TrainingSet) - enrolled; i < len(t.TrainingSet); i++ {
+		ptm := NewProjectedTrainingMatrix(t.project(t.TrainingSet[i]), t.TrainingLabels[i])
+		ptm.Source = t.TrainingSources[i]
+		t.Model = append(t.Model, ptm)
+		t.enrollment.pending++
+		t.enrollment.drift += t.residual(t.TrainingSet[i])
+	}
+	t.FeatureExtraction.ProjectedTrainingSet = t.Model
+	t.UpdateThreshold()
+	t.lock.Unlock()
+	logger.Logf("retraining %s done", rt.FeatureType)
+
+	if t.AfterRetrain != nil {
+		t.AfterRetrain(t)
+	}
+}
+
+// SetLibraryFingerprint records the library the trainer is up to date with.
+func (t *Trainer) SetLibraryFingerprint(fingerprint string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.LibraryFingerprint = fingerprint
+}
+
+// WaitRetrain blocks until the background retraining, if any, is done and
+// its model handed to AfterRetrain, which saves it for the library.
+func (t *Trainer) WaitRetrain() {
+	t.lock.RLock()
+	done := t.enrollment.done
+	t.lock.RUnlock()
+	if done != nil {
+		<-done
+	}
+}
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/pkg/errors"
+)
+
+// Errors returned, wrapped with the details, by the functions of the package,
+// test them with errors.Is. The historical functions logging the errors and
+// returning empty results are kept as wrappers.
+var (
+	ErrDimensionMismatch   = algorithm.ErrDimensionMismatch
+	ErrSingularMatrix      = algorithm.ErrSingularMatrix
+	ErrInsufficientSamples = errors.New("insufficient samples")
+	ErrUndecodableImage    = errors.New("undecodable image")
+	ErrNotTrained          = errors.New("trainer is not trained")
+	ErrEyesNotFound        = errors.New("eyes not found")
+)
@@ -0,0 +1,330 @@
+package model
+
+import (
+	"encoding/xml"
+	"image"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jeromelesaux/facedetection/facedetector"
+	"github.com/pkg/errors"
+)
+
+var (
+	// sizes of the eyes searched relative to the width of the face
+	eyeMinSize = 0.1
+	eyeMaxSize = 0.4
+	// rows searched for the eyes relative to the height of the face
+	eyesRowMax = 0.6
+	// detections overlapping an eye needed to accept it
+	eyeMinNeighbors = 2
+	eyeScaleFactor  = 1.1
+
+	eyeDetectors     = make(map[string]*EyeDetector)
+	eyeDetectorsLock sync.Mutex
+)
+
+// EyeDetector finds the eyes with an OpenCV Haar cascade of stumps in the old
+// format, as haarcascade_eye.xml of OpenCV 2.4. The cascade is decoded with the
+// types of the facedetection dependency, which only runs its own face cascade
+// on a window of 24x24 pixels.
+type EyeDetector struct {
+	Width  int // size of the window of the cascade
+	Height int
+	Stages []*facedetector.Stage
+}
+
+// eyeCascadeFile is an OpenCV storage holding a cascade under any name.
+type eyeCascadeFile struct {
+	Cascade struct {
+		Size   string               `xml:"size"`
+		Stages *facedetector.Stages `xml:"stages"`
+	} `xml:",any"`
+}
+
+// eyeCascadeTilts holds the tilted flags of the features of each stage, which
+// facedetector.Feature does not read as its tag is misspelled "tiltded".
+type eyeCascadeTilts struct {
+	Cascade struct {
+		Stages []struct {
+			Tilted []int `xml:"trees>_>_>feature>tilted"`
+		} `xml:"stages>_"`
+	} `xml:",any"`
+}
+
+// LoadEyeDetector reads the Haar cascade of the eyes of the file path. The
+// tilted features, rotated by 45 degrees, are not supported.
+func LoadEyeDetector(path string) (*EyeDetector, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open eye cascade %s", path)
+	}
+	file := &eyeCascadeFile{}
+	if err := xml.Unmarshal(content, file); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode eye cascade %s", path)
+	}
+	tilts := &eyeCascadeTilts{}
+	if err := xml.Unmarshal(content, tilts); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode eye cascade %s", path)
+	}
+	for i, stage := range tilts.Cascade.Stages {
+		for _, tilted := range stage.Tilted {
+			if tilted != 0 {
+				return nil, errors.Errorf("eye cascade %s stage %d has a tilted feature", path, i)
+			}
+		}
+	}
+	d := &EyeDetector{}
+	size := strings.Fields(file.Cascade.Size)
+	if len(size) == 2 {
+		d.Width, _ = strconv.Atoi(size[0])
+		d.Height, _ = strconv.Atoi(size[1])
+	}
+	if d.Width <= 0 || d.Height <= 0 || file.Cascade.Stages == nil || len(file.Cascade.Stages.Stage) == 0 {
+		return nil, errors.Errorf("eye cascade %s has no window size or no stage", path)
+	}
+	d.Stages = file.Cascade.Stages.Stage
+	for i, stage := range d.Stages {
+		if stage.Trees == nil || len(stage.Trees.Trees) == 0 {
+			return nil, errors.Errorf("eye cascade %s stage %d has no tree", path, i)
+		}
+		for _, tree := range stage.Trees.Trees {
+			if tree.RootNode == nil || tree.RootNode.Feature == nil {
+				return nil, errors.Errorf("eye cascade %s stage %d has a tree which is not a stump", path, i)
+			}
+			if err := parseEyeFeature(tree.RootNode.Feature); err != nil {
+				return nil, errors.Wrapf(err, "eye cascade %s stage %d", path, i)
+			}
+		}
+	}
+	return d, nil
+}
+
+// parseEyeFeature reads the rectangles "x y width height weight" of the
+// feature in the fields named by facedetector.Rect.Parse, without printing
+// the errors.
+func parseEyeFeature(feature *facedetector.Feature) error {
+	for _, r := range feature.Rects {
+		r.Rects = r.Rects[:0]
+		for _, v := range r.V {
+			fields := strings.Fields(v)
+			if len(fields) != 5 {
+				return errors.Errorf("feature rectangle %q is not x y width height weight", v)
+			}
+			values := make([]int, 4)
+			for i := range values {
+				value, err := strconv.Atoi(fields[i])
+				if err != nil {
+					return errors.Wrapf(err, "feature rectangle %q", v)
+				}
+				values[i] = value
+			}
+			weight, err := strconv.ParseFloat(fields[4], 64)
+			if err != nil {
+				return errors.Wrapf(err, "feature rectangle %q", v)
+			}
+			r.Rects = append(r.Rects, &facedetector.RectValue{X1: values[0], X2: values[1], Y1: values[2], Y2: values[3], Weight: weight})
+		}
+	}
+	return nil
+}
+
+// eyeDetector returns the detector of the cascade path, read once.
+func eyeDetector(path string) (*EyeDetector, error) {
+	if path == "" {
+		return nil, errors.New("no eye cascade configured")
+	}
+	eyeDetectorsLock.Lock()
+	defer eyeDetectorsLock.Unlock()
+	if d, ok := eyeDetectors[path]; ok {
+		return d, nil
+	}
+	d, err := LoadEyeDetector(path)
+	if err != nil {
+		return nil, err
+	}
+	eyeDetectors[path] = d
+	return d, nil
+}
+
+// eyeCandidate is a group of overlapping detections of an eye.
+type eyeCandidate struct {
+	rect      image.Rectangle
+	neighbors int
+}
+
+// Detect returns the eyes found in the upper part of the face, the ones
+// confirmed by the most overlapping detections first.
+func (d *EyeDetector) Detect(face *image.Gray) []image.Rectangle {
+	b := face.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// summed area tables of the gray levels and of their squares
+	sums := make([]float64, (w+1)*(h+1))
+	squares := make([]float64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := float64(face.GrayAt(b.Min.X+x, b.Min.Y+y).Y)
+			i := (y+1)*(w+1) + x + 1
+			sums[i] = v + sums[i-w-1] + sums[i-1] - sums[i-w-2]
+			squares[i] = v*v + squares[i-w-1] + squares[i-1] - squares[i-w-2]
+		}
+	}
+	area := func(table []float64, x, y, width, height int) float64 {
+		return table[(y+height)*(w+1)+x+width] - table[y*(w+1)+x+width] - table[(y+height)*(w+1)+x] + table[y*(w+1)+x]
+	}
+	rows := int(eyesRowMax * float64(h))
+	detections := make([]image.Rectangle, 0)
+	for scale := math.Max(1, eyeMinSize*float64(w)/float64(d.Width)); ; scale *= eyeScaleFactor {
+		width, height := int(scale*float64(d.Width)), int(scale*float64(d.Height))
+		if float64(width) > eyeMaxSize*float64(w) || width > w || height > rows {
+			break
+		}
+		invArea := 1 / float64(width*height)
+		features := d.scaledFeatures(scale)
+		step := int(math.Max(1, 0.1*float64(width)))
+		for y := 0; y+height <= rows; y += step {
+			for x := 0; x+width <= w; x += step {
+				mean := area(sums, x, y, width, height) * invArea
+				variance := area(squares, x, y, width, height)*invArea - mean*mean
+				vnorm := 1.
+				if variance > 1 {
+					vnorm = math.Sqrt(variance)
+				}
+				if d.pass(features, func(r *scaledRect) float64 {
+					return area(sums, x+r.x, y+r.y, r.width, r.height)
+				}, invArea, vnorm) {
+					detections = append(detections, image.Rect(x, y, x+width, y+height).Add(b.Min))
+				}
+			}
+		}
+	}
+	candidates := groupEyes(detections)
+	eyes := make([]image.Rectangle, 0, len(candidates))
+	for _, c := range candidates {
+		if c.neighbors >= eyeMinNeighbors {
+			eyes = append(eyes, c.rect)
+		}
+	}
+	return eyes
+}
+
+// scaledRect is a rectangle of a feature scaled in the window.
+type scaledRect struct {
+	x, y, width, height int
+	weight              float64
+}
+
+// scaledFeatures returns the rectangles of the features of each tree of each
+// stage scaled in the window. As OpenCV the weight of the first rectangle is
+// set again so that the feature stays null on a uniform window once the
+// rectangles are rounded.
+func (d *EyeDetector) scaledFeatures(scale float64) [][][]*scaledRect {
+	stages := make([][][]*scaledRect, len(d.Stages))
+	for i, stage := range d.Stages {
+		stages[i] = make([][]*scaledRect, len(stage.Trees.Trees))
+		for j, tree := range stage.Trees.Trees {
+			rects := make([]*scaledRect, 0)
+			for _, feature := range tree.RootNode.Feature.Rects {
+				for _, r := range feature.Rects {
+					// the fields as named by facedetector.Rect.Parse
+					rects = append(rects, &scaledRect{x: int(scale * float64(r.X1)), y: int(scale * float64(r.X2)),
+						width: int(scale * float64(r.Y1)), height: int(scale * float64(r.Y2)), weight: r.Weight})
+				}
+			}
+			if len(rects) > 1 && rects[0].width*rects[0].height > 0 {
+				sum := 0.
+				for _, r := range rects[1:] {
+					sum += r.weight * float64(r.width*r.height)
+				}
+				rects[0].weight = -sum / float64(rects[0].width*rects[0].height)
+			}
+			stages[i][j] = rects
+		}
+	}
+	return stages
+}
+
+// pass returns true if the window passes all the stages of the cascade of the
+// scaled features, sum returns the sum of the gray levels of a rectangle.
+func (d *EyeDetector) pass(features [][][]*scaledRect, sum func(r *scaledRect) float64, invArea, vnorm float64) bool {
+	for i, stage := range d.Stages {
+		total := 0.
+		for j, tree := range stage.Trees.Trees {
+			node := tree.RootNode
+			value := 0.
+			for _, r := range features[i][j] {
+				value += r.weight * sum(r)
+			}
+			if value*invArea < node.Threshold*vnorm {
+				total += node.LeftVal
+			} else {
+				total += node.RightVal
+			}
+		}
+		if total < stage.Threshold {
+			return false
+		}
+	}
+	return true
+}
+
+// groupEyes merges the detections of similar centers and sizes, directly or
+// through other detections, in their mean rectangle, the groups of the most
+// detections first.
+func groupEyes(detections []image.Rectangle) []*eyeCandidate {
+	parents := make([]int, len(detections))
+	for i := range parents {
+		parents[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parents[i] != i {
+			parents[i] = root(parents[i])
+		}
+		return parents[i]
+	}
+	for i := range detections {
+		for j := 0; j < i; j++ {
+			if similarEyes(detections[i], detections[j]) {
+				parents[root(i)] = root(j)
+			}
+		}
+	}
+	indexes := make(map[int]int)
+	groups := make([][]image.Rectangle, 0)
+	for i, r := range detections {
+		index, ok := indexes[root(i)]
+		if !ok {
+			index = len(groups)
+			indexes[root(i)] = index
+			groups = append(groups, nil)
+		}
+		groups[index] = append(groups[index], r)
+	}
+	candidates := make([]*eyeCandidate, len(groups))
+	for i, g := range groups {
+		var sum image.Rectangle
+		for _, r := range g {
+			sum.Min, sum.Max = sum.Min.Add(r.Min), sum.Max.Add(r.Max)
+		}
+		candidates[i] = &eyeCandidate{rect: image.Rectangle{Min: sum.Min.Div(len(g)), Max: sum.Max.Div(len(g))}, neighbors: len(g)}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].neighbors > candidates[j].neighbors })
+	return candidates
+}
+
+// similarEyes returns true if the edges of a and b are closer than a fifth of
+// their size, as the grouping of the detections of OpenCV.
+func similarEyes(a, b image.Rectangle) bool {
+	delta := 0.2 * float64(minInt(a.Dx(), b.Dx())+minInt(a.Dy(), b.Dy())) / 2
+	return math.Abs(float64(a.Min.X-b.Min.X)) <= delta && math.Abs(float64(a.Min.Y-b.Min.Y)) <= delta &&
+		math.Abs(float64(a.Max.X-b.Max.X)) <= delta && math.Abs(float64(a.Max.Y-b.Max.Y)) <= delta
+}
+
+func center(r image.Rectangle) image.Point {
+	return r.Min.Add(r.Max).Div(2)
+}
@@ -2,10 +2,13 @@ package model
 
 import (
 	"bufio"
-	"fmt"
 	"image"
 	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
 	_ "image/png"
+	"io"
+	"math"
 	"os"
 	"strconv"
 	"strings"
@@ -14,166 +17,253 @@ import (
 	pnm "github.com/jbuchbinder/gopnm"
 	"github.com/jeromelesaux/facerecognition/algorithm"
 	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/pkg/errors"
 )
 
-var (
-	Height = 100
-	Width  = 100
-)
-
-func StreamToVector(img image.Image) []float64 {
-	i := Resize(img)
-	width := i.Bounds().Max.X - i.Bounds().Min.X
-	height := i.Bounds().Max.Y - i.Bounds().Min.Y
-	minX := i.Bounds().Min.X
-	minY := i.Bounds().Min.Y
-
-	face := make([]float64, width*height)
+// All the images are converted to gray levels between 0 and 255 : the PGM
+// samples are scaled by their maximum value and the colors are weighted as in
+// ITU-R BT.601 (0.299 R + 0.587 G + 0.114 B), like color.GrayModel. The
+// vectors of pixels are stored by columns, as Matrix.Vectorize does.
 
-	// iterate through image row by row
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			color := i.At(x-minX, y-minY)
-			// ORL database images are 16-bit grayscale, so can use any of RGB values
-			value, _, _, _ := color.RGBA()
-			face[y+x] = float64(value)
-		}
-	}
-	return face
+// StreamToVector returns the gray levels of img resized to width x height,
+// vectorized by columns.
+func StreamToVector(img image.Image, width, height int) []float64 {
+	return ImageMatrix(Resize(img, width, height)).Vectorize().Data
 }
 
 func ToVector(path string) (int, int, []float64) {
-	f, err := os.Open(path)
+	width, height, face, err := ReadVector(path)
 	if err != nil {
 		logger.Log(err.Error())
 		return 0, 0, make([]float64, 0)
 	}
-	defer f.Close()
-	i, _, _ := image.Decode(f)
-	// i = Resize(i)
-	width := i.Bounds().Max.X - i.Bounds().Min.X
-	height := i.Bounds().Max.Y - i.Bounds().Min.Y
-	minX := i.Bounds().Min.X
-	minY := i.Bounds().Min.Y
-
-	face := make([]float64, width*height)
+	return width, height, face
+}
 
-	// iterate through image row by row
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			c := i.At(x-minX, y-minY)
-			// ORL database images are 16-bit grayscale, so can use any of RGB values
-			r, g, b, _ := c.RGBA()
-			grayValue := (19595*r + 38470*g + 7471*b + 1<<15) >> 24
-			face[y+x] = float64(uint8(grayValue))
-		}
+// ReadVector returns the size and the gray levels of the image path
+// vectorized by columns.
+func ReadVector(path string) (int, int, []float64, error) {
+	m, err := ReadMatrix(path)
+	if err != nil {
+		return 0, 0, nil, err
 	}
-	return width, height, face
+	return m.N, m.M, m.Vectorize().Data, nil
 }
 
-func ToImage(face *algorithm.Matrix) *image.Gray16 {
-	bounds := image.Rect(0, 0, face.N, face.M)
-	im := image.NewGray16(bounds)
+// ToImage returns the gray levels of face, a matrix of height rows and width
+// columns, as an 8 bits gray image, the values are rounded and clamped
+// between 0 and 255.
+func ToImage(face *algorithm.Matrix) *image.Gray {
+	im := image.NewGray(image.Rect(0, 0, face.N, face.M))
 	for y := 0; y < face.M; y++ {
 		for x := 0; x < face.N; x++ {
-			// ORL database images are 16-bit grayscale
-			value := uint16(face.A[y][x])
-			im.SetGray16(x, y, color.Gray16{value})
+			im.SetGray(x, y, color.Gray{uint8(grayLevel(face.A[y][x]))})
 		}
 	}
 	return im
 }
 
-func Resize(img image.Image) *image.NRGBA {
-	return imaging.Resize(img, Width, Height, imaging.Lanczos)
+func Resize(img image.Image, width, height int) *image.NRGBA {
+	return imaging.Resize(img, width, height, imaging.Lanczos)
 }
 
-//	func ToMatrix(width, height int, face []float64) *algorithm.Matrix {
-//		m := height
-//		n := width
-//		fmt.Println(face)
-//		mat := algorithm.NewMatrix(n * m,1)
-//		for p:= 0; p < n; p++ {
-//			for  q := 0; q < m; q++ {
-//				mat.A[p*m +q][0] = face[q*p]
-//			}
-//		}
-//		fmt.Println(mat)
-//		return mat
-//	}
 func ToMatrix(path string) *algorithm.Matrix {
-	f, err := os.Open(path)
+	mat, err := ReadMatrix(path)
 	if err != nil {
 		logger.Log(err.Error())
 		return algorithm.NewMatrix(0, 0)
 	}
+	return mat
+}
+
+// ReadMatrix returns the gray levels of the image path in a matrix of height
+// rows and width columns, see DecodeMatrix.
+func ReadMatrix(path string) (*algorithm.Matrix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open image %s", path)
+	}
 	defer f.Close()
-	if strings.HasSuffix(path, ".pgm") {
-		bf := bufio.NewReader(f)
-		bf.ReadLine()
-		d, _, _ := bf.ReadLine()
-		dimensions := string(d[:])
-		s := strings.Split(dimensions, " ")
-		width, _ := strconv.Atoi(s[0])
-		height, _ := strconv.Atoi(s[1])
-		// fmt.Printf("%d %d", width, height)
-		mat := algorithm.NewMatrix(height, width)
-		bf.ReadLine()
-		for row := 0; row < height; row++ {
-			for col := 0; col < width; col++ {
-				value, _ := bf.ReadByte()
-				mat.A[row][col] = float64(value)
+	m, err := DecodeMatrix(f)
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	return m, nil
+}
+
+// DecodeMatrix returns the gray levels of the image read from r in a matrix of
+// height rows and width columns. The PGM images, plain (P2) or raw (P5) of 8
+// or 16 bits, are read with their maximum value, the other formats registered
+// in the image package (PNG, JPEG, GIF, PNM) are converted by ImageMatrix.
+// The error is ErrUndecodableImage if the content cannot be read.
+func DecodeMatrix(r io.Reader) (*algorithm.Matrix, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && (string(magic) == "P2" || string(magic) == "P5") {
+		return decodePGM(br)
+	}
+	img, _, err := image.Decode(br)
+	if err != nil {
+		return nil, errors.Wrapf(ErrUndecodableImage, "%v", err)
+	}
+	return ImageMatrix(img), nil
+}
+
+// ImageMatrix returns the gray levels of img in a matrix of height rows and
+// width columns.
+func ImageMatrix(img image.Image) *algorithm.Matrix {
+	b := img.Bounds()
+	m := algorithm.NewMatrix(b.Dy(), b.Dx())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			switch c := img.At(b.Min.X+x, b.Min.Y+y).(type) {
+			case color.Gray:
+				m.A[y][x] = float64(c.Y)
+			case color.Gray16:
+				m.A[y][x] = float64(c.Y) / 257
+			default:
+				// the weights of color.GrayModel on 16 bits
+				r, g, b, _ := c.RGBA()
+				m.A[y][x] = float64((19595*r+38470*g+7471*b+1<<15)>>16) / 257
 			}
 		}
-		return mat
-	} else {
-
-		i, _, _ := image.Decode(f)
-		// i = Resize(i)
-		width := i.Bounds().Max.X - i.Bounds().Min.X
-		height := i.Bounds().Max.Y - i.Bounds().Min.Y
-		minX := i.Bounds().Min.X
-		minY := i.Bounds().Min.Y
-		matrix := algorithm.NewMatrix(height, width)
-
-		// iterate through image row by row
-		for y := 0; y < height; y++ {
-			for x := 0; x < width; x++ {
-				c := i.At(x-minX, y-minY)
-				// ORL database images are 16-bit grayscale, so can use any of RGB values
-				pixel := color.GrayModel.Convert(c)
-				r, g, b, _ := pixel.RGBA()
-
-				// grayValue := (19595*r + 38470*g + 7471*b + 1<<15) >> 24
-				grayValue := 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
-				matrix.A[y][x] = float64(uint8(grayValue / 256))
+	}
+	return m
+}
+
+// GrayMatrix returns the gray levels of img in a matrix of height rows and
+// width columns.
+func GrayMatrix(img *image.Gray) *algorithm.Matrix {
+	b := img.Bounds()
+	m := algorithm.NewMatrix(b.Dy(), b.Dx())
+	for y := 0; y < b.Dy(); y++ {
+		offset := img.PixOffset(b.Min.X, b.Min.Y+y)
+		for x, value := range img.Pix[offset : offset+b.Dx()] {
+			m.A[y][x] = float64(value)
+		}
+	}
+	return m
+}
+
+// decodePGM reads a plain (P2) or raw (P5) PGM image, the comments of the
+// header are skipped and the samples are scaled from the maximum value of
+// the file to 255.
+func decodePGM(r *bufio.Reader) (*algorithm.Matrix, error) {
+	magic, err := pgmToken(r)
+	if err != nil {
+		return nil, errors.Wrapf(ErrUndecodableImage, "PGM has no magic number : %v", err)
+	}
+	header := make([]int, 3)
+	for i, name := range []string{"width", "height", "maximum value"} {
+		token, err := pgmToken(r)
+		if err != nil {
+			return nil, errors.Wrapf(ErrUndecodableImage, "PGM has no %s : %v", name, err)
+		}
+		if header[i], err = strconv.Atoi(token); err != nil || header[i] <= 0 {
+			return nil, errors.Wrapf(ErrUndecodableImage, "PGM has an invalid %s %q", name, token)
+		}
+	}
+	width, height, maxValue := header[0], header[1], header[2]
+	if maxValue > 65535 {
+		return nil, errors.Wrapf(ErrUndecodableImage, "PGM maximum value %d is greater than 65535", maxValue)
+	}
+	scale := 255 / float64(maxValue)
+	m := algorithm.NewMatrix(height, width)
+	// the samples of more than 8 bits are 2 bytes, most significant first
+	sampleSize := 1
+	if maxValue > 255 {
+		sampleSize = 2
+	}
+	row := make([]byte, width*sampleSize)
+	for y := 0; y < height; y++ {
+		if magic == "P5" {
+			if _, err := io.ReadFull(r, row); err != nil {
+				return nil, errors.Wrapf(ErrUndecodableImage, "PGM is truncated : %v", err)
 			}
 		}
+		for x := 0; x < width; x++ {
+			var sample int
+			if magic == "P5" && sampleSize == 1 {
+				sample = int(row[x])
+			} else if magic == "P5" {
+				sample = int(row[2*x])<<8 | int(row[2*x+1])
+			} else {
+				token, err := pgmToken(r)
+				if err != nil {
+					return nil, errors.Wrapf(ErrUndecodableImage, "PGM is truncated : %v", err)
+				}
+				if sample, err = strconv.Atoi(token); err != nil {
+					return nil, errors.Wrapf(ErrUndecodableImage, "PGM has an invalid sample %q", token)
+				}
+			}
+			if sample < 0 || sample > maxValue {
+				return nil, errors.Wrapf(ErrUndecodableImage, "PGM sample %d is not between 0 and %d", sample, maxValue)
+			}
+			m.A[y][x] = math.Min(255, float64(sample)*scale)
+		}
+	}
+	return m, nil
+}
 
-		return matrix
+// pgmToken returns the next token of a PGM header or plain raster, the
+// comments from # to the end of the line are skipped. The whitespace ending
+// the token is consumed, the raster of a raw PGM starts right after it.
+func pgmToken(r *bufio.Reader) (string, error) {
+	token := make([]byte, 0, 8)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(token) > 0 {
+				return string(token), nil
+			}
+			return "", err
+		}
+		switch {
+		case c == '#':
+			if _, err := r.ReadString('\n'); err != nil && err != io.EOF {
+				return "", err
+			}
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f':
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		default:
+			token = append(token, c)
+		}
 	}
 }
 
 func ToPgm(path string) string {
-	index := strings.LastIndex(path, ".")
-	pgmPath := path[0:index] + ".pgm"
-	f, err := os.Create(pgmPath)
+	pgmPath, err := ConvertToPgm(path)
 	if err != nil {
 		logger.Log(err.Error())
 		return ""
 	}
-	defer f.Close()
-	f2, err := os.Open(path)
+	logger.Log("File : " + pgmPath + " created.")
+	return pgmPath
+}
+
+// ConvertToPgm writes the gray levels of the image path in an 8 bits PGM file
+// next to it and returns the path of the PGM file.
+func ConvertToPgm(path string) (string, error) {
+	index := strings.LastIndex(path, ".")
+	if index < 0 {
+		return "", errors.Wrapf(ErrUndecodableImage, "%s has no extension", path)
+	}
+	m, err := ReadMatrix(path)
 	if err != nil {
-		logger.Log(err.Error())
-		return ""
+		return "", err
 	}
-	defer f2.Close()
-	imgSrc, _, _ := image.Decode(f2)
-	err = pnm.Encode(f, imgSrc, pnm.PGM)
+	pgmPath := path[0:index] + ".pgm"
+	f, err := os.Create(pgmPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error while encoding png file [%s], %x\n", path, err)
+		return "", errors.Wrapf(err, "cannot create %s", pgmPath)
 	}
-	logger.Log("File : " + pgmPath + " created.")
-	return pgmPath
+	defer f.Close()
+	if err := pnm.Encode(f, ToImage(m), pnm.PGM); err != nil {
+		return "", errors.Wrapf(err, "error while encoding pgm file %s", pgmPath)
+	}
+	return pgmPath, nil
 }
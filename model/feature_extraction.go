@@ -1,7 +1,9 @@
 package model
 
 import (
+	"encoding/json"
 	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/pkg/errors"
 	"sort"
 	"strconv"
 )
@@ -12,6 +14,8 @@ type FeatureExtraction struct {
 	NumOfComponents      int
 	MeanMatrix           *algorithm.Matrix
 	W                    *algorithm.Matrix
+	Eigenvalues          []float64 // variance along each column of W, if known
+	Spectrum             []float64 // variance along all the principal components in decreasing order, if known
 	ProjectedTrainingSet []*ProjectedTrainingMatrix
 }
 
@@ -23,6 +27,55 @@ func NewFeatureExtraction() *FeatureExtraction {
 
 }
 
+// linearExtraction is the persisted state of a linear feature extraction.
+type linearExtraction struct {
+	NumOfComponents int               `json:"num_of_components"`
+	MeanMatrix      *algorithm.Matrix `json:"mean_matrix"`
+	W               *algorithm.Matrix `json:"w"`
+	Eigenvalues     []float64         `json:"eigenvalues,omitempty"`
+	Spectrum        []float64         `json:"spectrum,omitempty"`
+}
+
+// Project returns W^T (m - mean).
+func (fe *FeatureExtraction) Project(m *algorithm.Matrix) *algorithm.Matrix {
+	return fe.W.TransposeTimesMatrix(m.Minus(fe.MeanMatrix))
+}
+
+// update replaces fe by the result fitted of the feature extraction name.
+func (fe *FeatureExtraction) update(name string, fitted *FeatureExtraction) error {
+	if fitted.W == nil || fitted.W.M == 0 || fitted.MeanMatrix == nil {
+		return errors.Errorf("%s feature extraction is empty", name)
+	}
+	*fe = *fitted
+	return nil
+}
+
+func (fe *FeatureExtraction) save() (json.RawMessage, error) {
+	if fe.W == nil || fe.W.M == 0 {
+		return nil, errors.New("feature extraction is not fitted")
+	}
+	return json.Marshal(&linearExtraction{NumOfComponents: fe.NumOfComponents, MeanMatrix: fe.MeanMatrix, W: fe.W, Eigenvalues: fe.Eigenvalues, Spectrum: fe.Spectrum})
+}
+
+func (fe *FeatureExtraction) load(data json.RawMessage) error {
+	le := &linearExtraction{}
+	if err := json.Unmarshal(data, le); err != nil {
+		return errors.Wrap(err, "cannot decode feature extraction")
+	}
+	if le.W == nil || le.MeanMatrix == nil || le.W.M == 0 || le.W.M != le.MeanMatrix.M {
+		return errors.New("feature extraction has inconsistent projection matrices")
+	}
+	fe.NumOfComponents = le.NumOfComponents
+	fe.MeanMatrix = le.MeanMatrix
+	fe.W = le.W
+	fe.Eigenvalues = nil
+	if len(le.Eigenvalues) == le.W.N {
+		fe.Eigenvalues = le.Eigenvalues
+	}
+	fe.Spectrum = le.Spectrum
+	return nil
+}
+
 type mix struct {
 	Index int
 	Value float64
@@ -62,6 +115,42 @@ func (m *MixArray) Swap(i, j int) {
 	m.Mixes[i], m.Mixes[j] = m.Mixes[j], m.Mixes[i]
 }
 
+// truncatedEigenSize is the dimension from which the auto solver computes the
+// top eigenvectors of a symmetric matrix with the Lanczos solver.
+var truncatedEigenSize = 300
+
+// truncatedSolver returns true if the k top eigenpairs of a n x n symmetric
+// matrix are computed with the Lanczos solver.
+func truncatedSolver(solver string, n, k int) bool {
+	switch solver {
+	case LanczosSolver:
+		return true
+	case FullSolver:
+		return false
+	}
+	return n >= truncatedEigenSize && 4*k <= n
+}
+
+// topEigen returns the k largest eigenvalues of the symmetric matrix m in
+// decreasing order and their eigenvectors, see truncatedSolver.
+func topEigen(m *algorithm.Matrix, k int, solver string) ([]float64, *algorithm.Matrix, error) {
+	if truncatedSolver(solver, m.M, k) {
+		return algorithm.TopEigen(m, k)
+	}
+	feature := m.SymEig()
+	d := feature.Getd()
+	if k < 1 || len(d) < k {
+		return nil, nil, errors.Wrapf(ErrInsufficientSamples, "%d eigenvalues for %d components", len(d), k)
+	}
+	indexes := GetIndexesOfKEigenvalues(d, k)
+	values := make([]float64, k)
+	for i, index := range indexes {
+		values[i] = d[index]
+	}
+	v := feature.GetV()
+	return values, v.GetMatrix2(0, v.RowsDimension()-1, indexes), nil
+}
+
 func GetIndexesOfKEigenvalues(d []float64, k int) []int {
 	mixes := NewMixArrays(len(d))
 	for i := 0; i < len(d); i++ {
@@ -0,0 +1,380 @@
+package model
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/pkg/errors"
+)
+
+// FeatureExtractor computes a feature space from the training images and
+// projects the images in it.
+type FeatureExtractor interface {
+	Name() string
+	Fit(trainingSet []*algorithm.Matrix, labels []string) error
+	Project(m *algorithm.Matrix) *algorithm.Matrix
+	// Save returns the fitted state encoded in JSON, Load restores it.
+	Save() (json.RawMessage, error)
+	Load(data json.RawMessage) error
+}
+
+// LinearFeatureExtractor is a feature extractor projecting the images with
+// W^T (x - mean).
+type LinearFeatureExtractor interface {
+	FeatureExtractor
+	Extraction() *FeatureExtraction
+}
+
+// VarianceFeatureExtractor is a feature extractor knowing the variance of the
+// training images along each component, the eigenvalues for a PCA.
+type VarianceFeatureExtractor interface {
+	ComponentVariances() []float64
+}
+
+// SpectrumFeatureExtractor is a feature extractor knowing the variance along
+// all its candidate components, in decreasing order.
+type SpectrumFeatureExtractor interface {
+	Spectrum() []float64
+}
+
+// ImageFeatureExtractor is a feature extractor working on the pixels of the
+// images, it needs their size to read the vectorized images.
+type ImageFeatureExtractor interface {
+	SetImageSize(width, height int)
+}
+
+// IncrementalFeatureExtractor is a feature extractor whose projection does
+// not depend on the training images if Incremental returns true, the enrolled
+// images never require a retraining.
+type IncrementalFeatureExtractor interface {
+	Incremental() bool
+}
+
+var (
+	IntParameter    = "int"
+	FloatParameter  = "float"
+	BoolParameter   = "bool"
+	StringParameter = "string"
+)
+
+// Parameters are the parameters of a feature extractor by name.
+type Parameters map[string]string
+
+func (p Parameters) Int(name string) int {
+	v, _ := strconv.Atoi(p[name])
+	return v
+}
+
+func (p Parameters) Float(name string) float64 {
+	v, _ := strconv.ParseFloat(p[name], 64)
+	return v
+}
+
+func (p Parameters) Bool(name string) bool {
+	v, _ := strconv.ParseBool(p[name])
+	return v
+}
+
+func (p Parameters) String(name string) string {
+	return p[name]
+}
+
+func (p Parameters) Copy() Parameters {
+	c := make(Parameters, len(p))
+	for k, v := range p {
+		c[k] = v
+	}
+	return c
+}
+
+type ParameterDefinition struct {
+	Name        string                   `json:"name"`
+	Type        string                   `json:"type"`
+	Default     string                   `json:"default"`
+	Description string                   `json:"description"`
+	Values      []string                 `json:"values,omitempty"` // allowed values, all if empty
+	Validate    func(value string) error `json:"-"`                // optional check of the value
+}
+
+func (d *ParameterDefinition) check(value string) error {
+	var err error
+	switch d.Type {
+	case IntParameter:
+		_, err = strconv.Atoi(value)
+	case FloatParameter:
+		_, err = strconv.ParseFloat(value, 64)
+	case BoolParameter:
+		_, err = strconv.ParseBool(value)
+	case StringParameter:
+	default:
+		return errors.Errorf("parameter %s has an unknown type %s", d.Name, d.Type)
+	}
+	if err != nil {
+		return errors.Errorf("parameter %s expects a %s value and gets %s", d.Name, d.Type, value)
+	}
+	if len(d.Values) > 0 {
+		allowed := false
+		for _, v := range d.Values {
+			allowed = allowed || v == value
+		}
+		if !allowed {
+			return errors.Errorf("parameter %s expects one of %v and gets %s", d.Name, d.Values, value)
+		}
+	}
+	if d.Validate != nil {
+		return errors.Wrapf(d.Validate(value), "parameter %s", d.Name)
+	}
+	return nil
+}
+
+// FeatureExtractorRegistration describes a feature extractor and its parameters.
+type FeatureExtractorRegistration struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  []*ParameterDefinition `json:"parameters"`
+	// DefaultMetric is the metric used with the extractor if none is set, L1 if empty
+	DefaultMetric string `json:"default_metric,omitempty"`
+	// New returns a feature extractor not fitted, the parameters are validated
+	// and contain all the parameters defined.
+	New func(p Parameters) (FeatureExtractor, error) `json:"-"`
+}
+
+func (r *FeatureExtractorRegistration) parameter(name string) *ParameterDefinition {
+	for _, d := range r.Parameters {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// NormalizeParameters validates the parameters p and returns them with the
+// default value of the parameters not set.
+func (r *FeatureExtractorRegistration) NormalizeParameters(p Parameters) (Parameters, error) {
+	return normalizeParameters("feature extractor "+r.Name, r.Parameters, p)
+}
+
+// normalizeParameters validates the parameters p of owner defined by
+// definitions and returns them with the default values of the parameters not
+// set.
+func normalizeParameters(owner string, definitions []*ParameterDefinition, p Parameters) (Parameters, error) {
+	normalized := make(Parameters, len(definitions))
+	for name := range p {
+		defined := false
+		for _, d := range definitions {
+			defined = defined || d.Name == name
+		}
+		if !defined {
+			return nil, errors.Errorf("%s has no parameter %s", owner, name)
+		}
+	}
+	for _, d := range definitions {
+		value, ok := p[d.Name]
+		if !ok {
+			value = d.Default
+		}
+		if err := d.check(value); err != nil {
+			return nil, errors.Wrap(err, owner)
+		}
+		normalized[d.Name] = value
+	}
+	return normalized, nil
+}
+
+var (
+	extractors     = make(map[string]*FeatureExtractorRegistration)
+	extractorsLock sync.RWMutex
+)
+
+// RegisterFeatureExtractor makes a feature extractor available by its name.
+func RegisterFeatureExtractor(r *FeatureExtractorRegistration) error {
+	if r.Name == "" || r.New == nil {
+		return errors.New("feature extractor registration needs a name and a constructor")
+	}
+	for _, d := range r.Parameters {
+		if err := d.check(d.Default); err != nil {
+			return errors.Wrapf(err, "feature extractor %s default value", r.Name)
+		}
+	}
+	extractorsLock.Lock()
+	defer extractorsLock.Unlock()
+	if _, ok := extractors[r.Name]; ok {
+		return errors.Errorf("feature extractor %s is already registered", r.Name)
+	}
+	extractors[r.Name] = r
+	return nil
+}
+
+func mustRegisterFeatureExtractor(r *FeatureExtractorRegistration) {
+	if err := RegisterFeatureExtractor(r); err != nil {
+		panic(err)
+	}
+}
+
+// GetFeatureExtractorRegistration returns the registration of the feature
+// extractor name.
+func GetFeatureExtractorRegistration(name string) (*FeatureExtractorRegistration, error) {
+	extractorsLock.RLock()
+	defer extractorsLock.RUnlock()
+	r, ok := extractors[name]
+	if !ok {
+		return nil, errors.Errorf("unknown feature extractor %s", name)
+	}
+	return r, nil
+}
+
+// FeatureExtractors returns the registered feature extractors sorted by name.
+func FeatureExtractors() []*FeatureExtractorRegistration {
+	extractorsLock.RLock()
+	defer extractorsLock.RUnlock()
+	registrations := make([]*FeatureExtractorRegistration, 0, len(extractors))
+	for _, r := range extractors {
+		registrations = append(registrations, r)
+	}
+	sort.Slice(registrations, func(i, j int) bool { return registrations[i].Name < registrations[j].Name })
+	return registrations
+}
+
+// NewFeatureExtractor returns the feature extractor name, not fitted, with
+// the parameters p.
+func NewFeatureExtractor(name string, p Parameters) (FeatureExtractor, error) {
+	r, err := GetFeatureExtractorRegistration(name)
+	if err != nil {
+		return nil, err
+	}
+	normalized, err := r.NormalizeParameters(p)
+	if err != nil {
+		return nil, err
+	}
+	return r.New(normalized)
+}
+
+// DefaultMetric returns the metric used with the feature extractor name if
+// none is set.
+func DefaultMetric(name string) string {
+	if r, err := GetFeatureExtractorRegistration(name); err == nil && r.DefaultMetric != "" {
+		return r.DefaultMetric
+	}
+	return L1Metric
+}
+
+// componentsParameter is the number of components of the linear extractors.
+func componentsParameter() *ParameterDefinition {
+	return &ParameterDefinition{
+		Name:        "components",
+		Type:        IntParameter,
+		Default:     "0",
+		Description: "number of components, n - c (images - identities) if 0",
+		Validate: func(value string) error {
+			if v, _ := strconv.Atoi(value); v < 0 {
+				return errors.New("must be positive")
+			}
+			return nil
+		},
+	}
+}
+
+// defaultNumOfComponents returns n - c, the number of training images minus
+// the number of identities, at least 1.
+func defaultNumOfComponents(labels []string) int {
+	classes := make(map[string]bool)
+	for _, l := range labels {
+		classes[l] = true
+	}
+	if len(labels)-len(classes) < 1 {
+		return 1
+	}
+	return len(labels) - len(classes)
+}
+
+func init() {
+	mustRegisterFeatureExtractor(&FeatureExtractorRegistration{
+		Name:        PCAFeatureType,
+		Description: "principal component analysis (eigenfaces)",
+		Parameters: []*ParameterDefinition{
+			componentsParameter(),
+			{Name: "selection", Type: StringParameter, Default: CountSelection, Values: []string{CountSelection, VarianceSelection, ElbowSelection},
+				Description: "components chosen by count (components), by cumulative explained variance (variance) or at the elbow of the scree plot"},
+			{Name: "variance", Type: FloatParameter, Default: "0.95", Description: "ratio of the variance explained by the components of the variance selection",
+				Validate: func(value string) error {
+					if v, _ := strconv.ParseFloat(value, 64); v <= 0 || v > 1 {
+						return errors.New("must be greater than 0 and at most 1")
+					}
+					return nil
+				}},
+			{Name: "solver", Type: StringParameter, Default: AutoSolver, Values: []string{AutoSolver, FullSolver, LanczosSolver, SVDSolver},
+				Description: "eigenvectors computed by the full symmetric solver, by the Lanczos solver for the count selection, by the latter for the large training sets (auto) or by the thin SVD of the centered images"},
+		},
+		New: func(p Parameters) (FeatureExtractor, error) {
+			if p.String("solver") == LanczosSolver && p.String("selection") != CountSelection {
+				return nil, errors.Errorf("the %s solver needs the %s selection, the other ones need all the eigenvalues", LanczosSolver, CountSelection)
+			}
+			return &PCA{
+				FeatureExtraction: NewFeatureExtraction(),
+				components:        p.Int("components"),
+				selection:         p.String("selection"),
+				variance:          p.Float("variance"),
+				solver:            p.String("solver"),
+			}, nil
+		},
+	})
+	mustRegisterFeatureExtractor(&FeatureExtractorRegistration{
+		Name:        LDAFeatureType,
+		Description: "linear discriminant analysis on a PCA (fisherfaces)",
+		Parameters: []*ParameterDefinition{
+			{Name: "components", Type: IntParameter, Default: "0", Validate: componentsParameter().Validate,
+				Description: "dimension of the PCA run before the LDA, n - c (fisher) or n - 1 (regularized, direct) if 0"},
+			{Name: "mode", Type: StringParameter, Default: FisherLDA, Values: []string{FisherLDA, RegularizedLDA, DirectLDA},
+				Description: "fisher needs 2 images per identity, regularized shrinks the within class scatter, direct diagonalizes the between class scatter first"},
+			{Name: "shrinkage", Type: FloatParameter, Default: "0.1", Description: "weight of the identity in the within class scatter of the regularized mode",
+				Validate: func(value string) error {
+					if v, _ := strconv.ParseFloat(value, 64); v < 0 || v > 1 {
+						return errors.New("must be between 0 and 1")
+					}
+					return nil
+				}},
+		},
+		New: func(p Parameters) (FeatureExtractor, error) {
+			return &LDA{
+				FeatureExtraction: NewFeatureExtraction(),
+				components:        p.Int("components"),
+				mode:              p.String("mode"),
+				shrinkage:         p.Float("shrinkage"),
+			}, nil
+		},
+	})
+	mustRegisterFeatureExtractor(&FeatureExtractorRegistration{
+		Name:        LPPFeatureType,
+		Description: "locality preserving projections on a PCA (laplacianfaces)",
+		Parameters: []*ParameterDefinition{
+			componentsParameter(),
+			{Name: "neighbors", Type: IntParameter, Default: "3", Description: "number of nearest neighbors joined in the graph",
+				Validate: func(value string) error {
+					if v, _ := strconv.Atoi(value); v < 1 {
+						return errors.New("must be at least 1")
+					}
+					return nil
+				}},
+			{Name: "t", Type: FloatParameter, Default: "0", Description: "heat kernel width of the weights exp(-|xi - xj|^2 / t), binary weights if 0",
+				Validate: func(value string) error {
+					if v, _ := strconv.ParseFloat(value, 64); v < 0 {
+						return errors.New("must be positive")
+					}
+					return nil
+				}},
+			{Name: "supervised", Type: BoolParameter, Default: "false", Description: "join only the neighbors of the same identity"},
+		},
+		New: func(p Parameters) (FeatureExtractor, error) {
+			return &LPP{
+				FeatureExtraction: NewFeatureExtraction(),
+				components:        p.Int("components"),
+				neighbors:         p.Int("neighbors"),
+				t:                 p.Float("t"),
+				supervised:        p.Bool("supervised"),
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,282 @@
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/pkg/errors"
+)
+
+// Metric is a distance between two projected images, the lower the closer.
+type Metric interface {
+	Name() string
+	GetDistance(a, b *algorithm.Matrix) float64
+}
+
+// FittedMetric is a metric learning its parameters from the projected
+// training images, its state is persisted with the trained model.
+type FittedMetric interface {
+	Metric
+	Fit(projections []*algorithm.Matrix, labels []string) error
+	Save() (json.RawMessage, error)
+	Load(data json.RawMessage) error
+}
+
+var (
+	ChiSquareMetric        = "ChiSquare"
+	ChebyshevMetric        = "Chebyshev"
+	MahalanobisMetric      = "Mahalanobis"
+	WeightedAngleMetric    = "WeightedAngle"
+	WhitenedCosineMetric   = "WhitenedCosine"
+	MahalanobisAngleMetric = "MahalanobisAngle"
+)
+
+// EigenvalueMetric is a metric weighting the coordinates with the eigenvalues
+// of the feature extraction, they replace the fitted variances when known.
+type EigenvalueMetric interface {
+	SetEigenvalues(eigenvalues []float64)
+}
+
+var (
+	metrics     = make(map[string]func() Metric)
+	metricsLock sync.RWMutex
+)
+
+// RegisterMetric makes the metric returned by newMetric available by its name.
+func RegisterMetric(name string, newMetric func() Metric) error {
+	if name == "" || newMetric == nil {
+		return errors.New("metric registration needs a name and a constructor")
+	}
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	if _, ok := metrics[name]; ok {
+		return errors.Errorf("metric %s is already registered", name)
+	}
+	metrics[name] = newMetric
+	return nil
+}
+
+func mustRegisterMetric(name string, newMetric func() Metric) {
+	if err := RegisterMetric(name, newMetric); err != nil {
+		panic(err)
+	}
+}
+
+// NewMetric returns a new instance of the metric name, not fitted.
+func NewMetric(name string) (Metric, error) {
+	metricsLock.RLock()
+	defer metricsLock.RUnlock()
+	newMetric, ok := metrics[name]
+	if !ok {
+		return nil, errors.Errorf("unknown metric %s", name)
+	}
+	return newMetric(), nil
+}
+
+// Metrics returns the sorted names of the registered metrics.
+func Metrics() []string {
+	metricsLock.RLock()
+	defer metricsLock.RUnlock()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	mustRegisterMetric(L1Metric, func() Metric { return &L1{} })
+	mustRegisterMetric(EuclideanMetric, func() Metric { return &Euclidean{} })
+	mustRegisterMetric(CosineDissimilarityMetric, func() Metric { return &CosineDissimilarity{} })
+	mustRegisterMetric(ChiSquareMetric, func() Metric { return &ChiSquare{} })
+	mustRegisterMetric(ChebyshevMetric, func() Metric { return &Chebyshev{} })
+	mustRegisterMetric(MahalanobisMetric, func() Metric { return &Mahalanobis{} })
+	mustRegisterMetric(WeightedAngleMetric, func() Metric { return &WeightedAngle{} })
+	mustRegisterMetric(WhitenedCosineMetric, func() Metric { return &WhitenedCosine{} })
+	mustRegisterMetric(MahalanobisAngleMetric, func() Metric { return &MahalanobisAngle{} })
+}
+
+// ChiSquare is sum (a - b)^2 / (|a| + |b|), suited to histograms.
+type ChiSquare struct {
+}
+
+func (c *ChiSquare) Name() string {
+	return ChiSquareMetric
+}
+
+func (c *ChiSquare) GetDistance(a, b *algorithm.Matrix) float64 {
+	sum := 0.
+	for i := 0; i < a.RowsDimension(); i++ {
+		d := math.Abs(a.A[i][0]) + math.Abs(b.A[i][0])
+		if d > 0 {
+			sum += (a.A[i][0] - b.A[i][0]) * (a.A[i][0] - b.A[i][0]) / d
+		}
+	}
+	return sum
+}
+
+// Chebyshev is the largest absolute difference of the coordinates.
+type Chebyshev struct {
+}
+
+func (c *Chebyshev) Name() string {
+	return ChebyshevMetric
+}
+
+func (c *Chebyshev) GetDistance(a, b *algorithm.Matrix) float64 {
+	max := 0.
+	for i := 0; i < a.RowsDimension(); i++ {
+		max = math.Max(max, math.Abs(a.A[i][0]-b.A[i][0]))
+	}
+	return max
+}
+
+// variances is the variance of each coordinate of the projected training
+// images, the state of the metrics weighting the coordinates.
+type variances struct {
+	Variances []float64 `json:"variances"`
+}
+
+func (v *variances) Fit(projections []*algorithm.Matrix, labels []string) error {
+	if len(projections) < 2 {
+		return errors.New("at least two projections are required to compute the variances")
+	}
+	size := projections[0].RowsDimension()
+	mean := make([]float64, size)
+	for _, p := range projections {
+		for i := 0; i < size; i++ {
+			mean[i] += p.A[i][0]
+		}
+	}
+	for i := range mean {
+		mean[i] /= float64(len(projections))
+	}
+	v.Variances = make([]float64, size)
+	for _, p := range projections {
+		for i := 0; i < size; i++ {
+			v.Variances[i] += (p.A[i][0] - mean[i]) * (p.A[i][0] - mean[i])
+		}
+	}
+	for i := range v.Variances {
+		v.Variances[i] /= float64(len(projections) - 1)
+	}
+	return nil
+}
+
+func (v *variances) SetEigenvalues(eigenvalues []float64) {
+	v.Variances = append(make([]float64, 0, len(eigenvalues)), eigenvalues...)
+}
+
+// weight returns 1 / variance of the coordinate i, 1 if not fitted.
+func (v *variances) weight(i int) float64 {
+	if i >= len(v.Variances) || v.Variances[i] <= 0 {
+		return 1.
+	}
+	return 1. / v.Variances[i]
+}
+
+func (v *variances) Save() (json.RawMessage, error) {
+	return json.Marshal(v)
+}
+
+func (v *variances) Load(data json.RawMessage) error {
+	return errors.Wrap(json.Unmarshal(data, v), "cannot decode metric variances")
+}
+
+// Mahalanobis is the euclidean distance of the coordinates divided by their
+// standard deviation (the square root of the eigenvalues for a PCA), the
+// euclidean distance until fitted.
+type Mahalanobis struct {
+	variances
+}
+
+func (m *Mahalanobis) Name() string {
+	return MahalanobisMetric
+}
+
+func (m *Mahalanobis) GetDistance(a, b *algorithm.Matrix) float64 {
+	sum := 0.
+	for i := 0; i < a.RowsDimension(); i++ {
+		sum += (a.A[i][0] - b.A[i][0]) * (a.A[i][0] - b.A[i][0]) * m.weight(i)
+	}
+	return math.Sqrt(sum)
+}
+
+// WeightedAngle is 1 - sum(z_i a_i b_i) / sqrt(sum(z_i a_i^2) sum(z_i b_i^2))
+// with z_i = 1 / standard deviation of the coordinate i, 1 - cosine until fitted.
+type WeightedAngle struct {
+	variances
+}
+
+func (w *WeightedAngle) Name() string {
+	return WeightedAngleMetric
+}
+
+func (w *WeightedAngle) GetDistance(a, b *algorithm.Matrix) float64 {
+	sum, aNorm, bNorm := 0., 0., 0.
+	for i := 0; i < a.RowsDimension(); i++ {
+		z := math.Sqrt(w.weight(i))
+		sum += z * a.A[i][0] * b.A[i][0]
+		aNorm += z * a.A[i][0] * a.A[i][0]
+		bNorm += z * b.A[i][0] * b.A[i][0]
+	}
+	if aNorm == 0 || bNorm == 0 {
+		return MAX_FLOAT_VALUE
+	}
+	return 1. - sum/math.Sqrt(aNorm*bNorm)
+}
+
+// whitenedCosine returns the cosine of a and b once their coordinates are
+// divided by their standard deviation.
+func (v *variances) whitenedCosine(a, b *algorithm.Matrix) (float64, bool) {
+	sum, aNorm, bNorm := 0., 0., 0.
+	for i := 0; i < a.RowsDimension(); i++ {
+		z := v.weight(i)
+		sum += z * a.A[i][0] * b.A[i][0]
+		aNorm += z * a.A[i][0] * a.A[i][0]
+		bNorm += z * b.A[i][0] * b.A[i][0]
+	}
+	if aNorm == 0 || bNorm == 0 {
+		return 0, false
+	}
+	return math.Max(-1, math.Min(1, sum/math.Sqrt(aNorm*bNorm))), true
+}
+
+// WhitenedCosine is 1 - the cosine of the whitened coordinates, the
+// coordinates divided by their standard deviation.
+type WhitenedCosine struct {
+	variances
+}
+
+func (w *WhitenedCosine) Name() string {
+	return WhitenedCosineMetric
+}
+
+func (w *WhitenedCosine) GetDistance(a, b *algorithm.Matrix) float64 {
+	cosine, ok := w.whitenedCosine(a, b)
+	if !ok {
+		return MAX_FLOAT_VALUE
+	}
+	return 1. - cosine
+}
+
+// MahalanobisAngle is the angle in radians between the whitened coordinates.
+type MahalanobisAngle struct {
+	variances
+}
+
+func (m *MahalanobisAngle) Name() string {
+	return MahalanobisAngleMetric
+}
+
+func (m *MahalanobisAngle) GetDistance(a, b *algorithm.Matrix) float64 {
+	cosine, ok := m.whitenedCosine(a, b)
+	if !ok {
+		return MAX_FLOAT_VALUE
+	}
+	return math.Acos(cosine)
+}
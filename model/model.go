@@ -1,25 +1,25 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"image"
-	"image/draw"
 	_ "image/jpeg"
 	"image/png"
 	"math"
-	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"sync"
-	"time"
 
-	pnm "github.com/jbuchbinder/gopnm"
 	"github.com/jeromelesaux/facedetection/facedetector"
 	"github.com/jeromelesaux/facerecognition/algorithm"
 	"github.com/jeromelesaux/facerecognition/logger"
-	"github.com/nfnt/resize"
+	"github.com/pkg/errors"
 )
 
 type User struct {
@@ -43,7 +43,7 @@ type FaceRecognitionItem struct {
 type FaceRecognitionLib struct {
 	Items                  map[string]*FaceRecognitionItem `json:"facerecognition_lib"`
 	MinimalNumOfComponents int
-	Width                  int
+	Width                  int // size of the stored faces, the face size of the configuration
 	Height                 int
 }
 
@@ -54,37 +54,43 @@ var (
 )
 
 func NewFaceRecognitionLib() *FaceRecognitionLib {
+	width, height := GetConfig().GetFaceSize()
 	return &FaceRecognitionLib{
 		Items:                  make(map[string]*FaceRecognitionItem, 0),
 		MinimalNumOfComponents: 10,
-		Width:                  92,
-		Height:                 92,
+		Width:                  width,
+		Height:                 height,
 	}
 }
 
 func GetFaceRecognitionLib() *FaceRecognitionLib {
 	loadUserLibOnce.Do(func() {
-		lib = NewFaceRecognitionLib()
-		_, err := os.Stat(GetConfig().GetDataLib())
+		lib = LoadFaceRecognitionLib()
+	})
+	return lib
+}
+
+// LoadFaceRecognitionLib reads the library of the configuration base path,
+// GetFaceRecognitionLib returns the one read at the first call.
+func LoadFaceRecognitionLib() *FaceRecognitionLib {
+	fl := NewFaceRecognitionLib()
+	_, err := os.Stat(GetConfig().GetDataLib())
+	if err != nil {
+		err = os.MkdirAll(GetConfig().GetDataLib(), os.ModePerm)
 		if err != nil {
-			err = os.MkdirAll(GetConfig().GetDataLib(), os.ModePerm)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error while creating directories [%s] : %v\n", GetConfig().GetDataLib(), err)
-			}
+			fmt.Fprintf(os.Stderr, "error while creating directories [%s] : %v\n", GetConfig().GetDataLib(), err)
 		}
-		_, err = os.Stat(GetConfig().GetTmpDirectory())
+	}
+	_, err = os.Stat(GetConfig().GetTmpDirectory())
+	if err != nil {
+		err = os.MkdirAll(GetConfig().GetTmpDirectory(), os.ModePerm)
 		if err != nil {
-			err = os.MkdirAll(GetConfig().GetTmpDirectory(), os.ModePerm)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error while creating directories [%s] : %v\n", GetConfig().GetTmpDirectory(), err)
-			}
+			fmt.Fprintf(os.Stderr, "error while creating directories [%s] : %v\n", GetConfig().GetTmpDirectory(), err)
 		}
-		lib.load()
-	})
-	if len(lib.Items) > 0 {
-		lib.NormalizeImageLength()
 	}
-	return lib
+	fl.load()
+	fl.migrate()
+	return fl
 }
 
 func (fl *FaceRecognitionLib) load() {
@@ -104,40 +110,68 @@ func (fl *FaceRecognitionLib) load() {
 	// frl.MinimalNumOfComponents = len(frl.Items)
 }
 
+// migrate records the face size of the configuration in the library and
+// normalizes the stored faces to it when it changed, the trained models of the
+// former size no longer match the library and are retrained.
+func (fl *FaceRecognitionLib) migrate() {
+	width, height := GetConfig().GetFaceSize()
+	if fl.Width == width && fl.Height == height {
+		return
+	}
+	logger.Logf("face size changed from %dx%d to %dx%d, normalizing the stored faces", fl.Width, fl.Height, width, height)
+	fl.Width, fl.Height = width, height
+	fl.NormalizeImageLength()
+	if len(fl.Items) > 0 {
+		if err := fl.Save(); err != nil {
+			logger.Log(err.Error())
+		}
+	}
+}
+
+// loadItems completes the training images saved with the library with the
+// files of the user directories, the images are repeated to reach
+// MinimalNumOfComponents. The images already listed are kept, so a library
+// saved and loaded again is unchanged.
 func (fl *FaceRecognitionLib) loadItems() {
-	for key := range fl.Items {
-		userDir := fl.Items[key].GetKey()
+	for key, item := range fl.Items {
+		userDir := item.GetKey()
 		directoryToScan := GetConfig().GetFaceRecognitionBasePath() + userDir
 		fs, err := os.ReadDir(directoryToScan)
 		if err != nil {
 			logger.Logf("error while scanning directory %s, with error :%v", directoryToScan, err)
 			continue
 		}
-
-		diff := fl.MinimalNumOfComponents - len(fs)
-		for i, file := range fs {
-			if i >= fl.MinimalNumOfComponents {
+		listed := make(map[string]bool, len(item.TrainingImages))
+		for _, path := range item.TrainingImages {
+			listed[path] = true
+		}
+		for _, file := range fs {
+			if len(item.TrainingImages) >= fl.MinimalNumOfComponents {
 				break
 			}
 			filePath := directoryToScan + separator + file.Name()
-			fl.Items[key].TrainingImages = append(fl.Items[key].TrainingImages, filePath)
+			if !listed[filePath] {
+				listed[filePath] = true
+				item.TrainingImages = append(item.TrainingImages, filePath)
+			}
 		}
 		// to be compliant with the number of MinimalNumOfComponents
-		if diff > 0 {
-			i := diff
-			for i > 0 {
-				for j := len(fs) - 1; j >= 0 && i > 0; j-- {
-					filePath := directoryToScan + separator + fs[j].Name()
-					logger.Logf("extra adding to %s file %s", key, filePath)
-					fl.Items[key].TrainingImages = append(fl.Items[key].TrainingImages, filePath)
-					i--
-				}
-			}
+		for i := 0; len(fs) > 0 && len(item.TrainingImages) < fl.MinimalNumOfComponents; i++ {
+			filePath := directoryToScan + separator + fs[len(fs)-1-i%len(fs)].Name()
+			logger.Logf("extra adding to %s file %s", key, filePath)
+			item.TrainingImages = append(item.TrainingImages, filePath)
 		}
 	}
 }
 
 func (fl *FaceRecognitionLib) AddUserFace(u *FaceRecognitionItem) {
+	if err := fl.addUserFace(u); err != nil {
+		logger.Log(err.Error())
+	}
+}
+
+// addUserFace adds the training images of u to the library and saves it.
+func (fl *FaceRecognitionLib) addUserFace(u *FaceRecognitionItem) error {
 	if old, ok := fl.Items[u.GetKey()]; ok {
 		u.TrainingImages = append(u.TrainingImages, old.TrainingImages...)
 	}
@@ -146,145 +180,98 @@ func (fl *FaceRecognitionLib) AddUserFace(u *FaceRecognitionItem) {
 	if len(u.TrainingImages) > 0 && len(u.TrainingImages) < 4 {
 		fl.MinimalNumOfComponents = len(u.TrainingImages)
 	}
-	fl.Save()
+	return fl.Save()
 }
 
-func (fl *FaceRecognitionLib) Save() {
+func (fl *FaceRecognitionLib) Save() error {
 	userLibLock.Lock()
 	defer userLibLock.Unlock()
 	f, err := os.Create(GetConfig().GetDataLib())
 	if err != nil {
-		logger.Logf("cannot create datalib %s, error:%v", GetConfig().GetDataLib(), err.Error())
-		return
+		return errors.Wrapf(err, "cannot create datalib %s", GetConfig().GetDataLib())
 	}
 	defer f.Close()
-	err = json.NewEncoder(f).Encode(fl)
-	if err != nil {
-		logger.Logf("cannot encode to json datalib file %s, with error %v", GetConfig().GetDataLib(), err.Error())
-		return
+	if err := json.NewEncoder(f).Encode(fl); err != nil {
+		return errors.Wrapf(err, "cannot encode to json datalib file %s", GetConfig().GetDataLib())
 	}
+	return nil
 }
 
+// NormalizeImageLength resizes the stored faces to the size of the library,
+// they are cropped to its aspect ratio first. The faces are normalized from
+// their original, moved to the originals directory the first time, so the
+// successive face sizes do not degrade them.
 func (fl *FaceRecognitionLib) NormalizeImageLength() {
-	width := 100000
-	height := 100000
 	var wc sync.WaitGroup
-
 	for _, user := range fl.Items {
 		wc.Add(1)
 		go func(item *FaceRecognitionItem) {
 			defer wc.Done()
-			for _, img := range item.TrainingImages {
-				f, err := os.Open(img)
-				if err == nil {
-					defer f.Close()
-					i, _, err := image.Decode(f)
-					if err == nil {
-						imgWidth := i.Bounds().Max.X
-						imgHeight := i.Bounds().Max.Y
-						if imgWidth < width {
-							width = imgWidth
-						}
-						if imgHeight < height {
-							height = imgHeight
-						}
-					}
+			originals := GetConfig().GetOriginalsDirectory() + item.GetKey()
+			done := make(map[string]bool)
+			for _, path := range item.TrainingImages {
+				if done[path] {
+					continue
+				}
+				done[path] = true
+				if err := normalizeImage(path, filepath.Join(originals, filepath.Base(path)), fl.Width, fl.Height); err != nil {
+					logger.Log(err.Error())
 				}
 			}
 		}(user)
 	}
 	wc.Wait()
-	for _, user := range fl.Items {
-		for _, img := range user.TrainingImages {
-			normalizeImage(fl, img)
-		}
-	}
 }
 
-func normalizeImage(fl *FaceRecognitionLib, path string) {
-	f, err := os.Open(path)
-	if err == nil {
-		defer f.Close()
-		i, _, err := image.Decode(f)
-		if err == nil {
-			ir := resize.Resize(uint(fl.Width), uint(fl.Height), i, resize.Lanczos3)
-			fw, err := os.Create(path)
-			if err == nil {
-				defer fw.Close()
-				err = pnm.Encode(fw, ir, pnm.PGM)
-				if err != nil {
-					logger.Logf("cannot encode to pnm file %s with error %v", path, err.Error())
-				}
-			} else {
-				logger.Logf("cannot create file %s with error %v", path, err.Error())
-			}
-		} else {
-			logger.Logf("cannot decode image file %s, with error :%v", path, err.Error())
+// normalizeImage writes in path the face of original in gray levels at the
+// size width x height, the face of path is first moved to original if there
+// is none.
+func normalizeImage(path, original string, width, height int) error {
+	if _, err := os.Stat(original); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(original), os.ModePerm); err != nil {
+			return errors.Wrapf(err, "cannot create directory %s", filepath.Dir(original))
+		}
+		if err := os.Rename(path, original); err != nil {
+			return errors.Wrapf(err, "cannot keep the original face %s", path)
 		}
 	}
+	m, err := ReadMatrix(original)
+	if err != nil {
+		return err
+	}
+	img := ToImage(m)
+	face := &Face{Image: resizeFace(grayCrop(img, centeredCrop(img.Bounds(), width, height)), width, height)}
+	return face.Save(path)
 }
 
+// MatrixNVectorize returns the vector of the whole image img, see Vectorize.
 func (fl *FaceRecognitionLib) MatrixNVectorize(img *image.Image) *algorithm.Matrix {
-	filename := GetConfig().GetTmpDirectory() + "raw.pgm"
-	f, err := os.Create(filename)
-	if err != nil {
-		logger.Logf("cannot create file %s with error %v", filename, err.Error())
-		return &algorithm.Matrix{}
-	}
-	defer f.Close()
-	err = pnm.Encode(f, *img, pnm.PGM)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error while encoding png file [%s] : %v\n", filename, err)
-	}
-	normalizeImage(fl, filename)
-	return ToMatrix(filename).Vectorize()
+	return fl.Vectorize(*img)
 }
 
+// FindFace returns the vectors of the faces detected in img and writes them in
+// PGM files of the temporary directory, use Faces to keep them in memory.
 func (fl *FaceRecognitionLib) FindFace(img *image.Image) ([]*algorithm.Matrix, []string) {
-	mats := make([]*algorithm.Matrix, 0)
-	filesnames := make([]string, 0)
-	fd := facedetector.NewFaceDetector(*img, GetConfig().FaceDetectionConfigurationFile)
-	var wc sync.WaitGroup
-
-	for i, r := range fd.GetFaces() {
-		wc.Add(1)
-		go func(r *facedetector.FoundRect, index int) {
-			defer wc.Done()
-			b := make([]byte, 16)
-			rand.Read(b)
-			id := fmt.Sprintf("tofind-%X", b)
-			dstRect := image.Rect(r.X, r.Y, (r.X + r.Width), (r.Y + r.Height))
-			dst := image.NewRGBA(dstRect)
-			draw.Draw(dst, dstRect, fd.Image, image.Point{r.X, r.Y}, draw.Src)
-			filename := GetConfig().GetTmpDirectory() + "face_" + id + "_" + strconv.Itoa(r.X) + "_" + strconv.Itoa(r.Y) + "_" + strconv.Itoa(r.Width) + "_" + strconv.Itoa(r.Height) + strconv.Itoa(index) + ".png"
-			fdst, _ := os.Create(filename)
-			defer fdst.Close()
-			err := png.Encode(fdst, dst)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error while encode png file [%s], %x\n", filename, err)
-			}
-			logger.Log("File " + filename + "saved as png.")
-			newFilename := ToPgm(filename)
-			err = os.Remove(filename)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error while removing file [%s], %x\n", filename, err)
-			}
-			normalizeImage(fl, newFilename)
-			mats = append(mats, ToMatrix(newFilename).Vectorize())
-			filesnames = append(filesnames, newFilename)
-		}(r, i)
-	}
-
-	filename := GetConfig().GetTmpDirectory() + "final-faces-found.png"
-	fdst, _ := os.Create(filename)
-	defer fdst.Close()
-	if err := png.Encode(fdst, fd.DrawFaces()); err != nil {
-		logger.Logf("cannot encode to png file %s with error : %v", filename, err)
+	faces := fl.Faces(*img)
+	mats := make([]*algorithm.Matrix, 0, len(faces))
+	filesnames := make([]string, 0, len(faces))
+	for i, f := range faces {
+		filename := GetConfig().GetTmpDirectory() + faceFilename("tofind-"+uniqueID(), f.Rect, i)
+		if err := f.Save(filename); err != nil {
+			logger.Log(err.Error())
+			continue
+		}
+		mats = append(mats, f.Vector())
+		filesnames = append(filesnames, filename)
 	}
-	wc.Wait()
 	return mats, filesnames
 }
 
+// faceFilename returns the name of the PGM file of the face at r.
+func faceFilename(id string, r image.Rectangle, index int) string {
+	return "face_" + id + "_" + strconv.Itoa(r.Min.X) + "_" + strconv.Itoa(r.Min.Y) + "_" + strconv.Itoa(r.Dx()) + "_" + strconv.Itoa(r.Dy()) + strconv.Itoa(index) + ".pgm"
+}
+
 func NewFaceRecognitionItem() *FaceRecognitionItem {
 	return &FaceRecognitionItem{User: User{}}
 }
@@ -304,39 +291,27 @@ func (fi *FaceRecognitionItem) DetectFacesFromImages(images []image.Image) {
 	}
 	for _, img := range images {
 		fd := facedetector.NewFaceDetector(img, GetConfig().FaceDetectionConfigurationFile)
-		fi.storeImages(fd, userBasePath)
+		fi.TrainingImages = append(fi.TrainingImages, fi.storeImages(fd, userBasePath)...)
 	}
 }
 
-func (fi *FaceRecognitionItem) storeImages(fd *facedetector.FaceDetector, basePath string) {
-	rand.Seed(time.Now().UTC().UnixNano())
-	var wc sync.WaitGroup
-
-	for i, r := range fd.GetFaces() {
-		wc.Add(1)
-		go func(r *facedetector.FoundRect, index int) {
-			defer wc.Done()
-			b := make([]byte, 16)
-			rand.Read(b)
-			id := fmt.Sprintf("%s-%X", fi.User.Key(), b)
-			dstRect := image.Rect(r.X, r.Y, (r.X + r.Width), (r.Y + r.Height))
-			dst := image.NewRGBA(dstRect)
-			draw.Draw(dst, dstRect, fd.Image, image.Point{r.X, r.Y}, draw.Src)
-			filename := basePath + string(filepath.Separator) + "face_" + id + "_" + strconv.Itoa(r.X) + "_" + strconv.Itoa(r.Y) + "_" + strconv.Itoa(r.Width) + "_" + strconv.Itoa(r.Height) + strconv.Itoa(index) + ".png"
-			fdst, _ := os.Create(filename)
-			defer fdst.Close()
-			err := png.Encode(fdst, dst)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error while encoding png file [%s] : %v\n", filename, err)
-			}
-			logger.Log("File " + filename + "saved as png.")
-			newFilename := ToPgm(filename)
-			fi.TrainingImages = append(fi.TrainingImages, newFilename)
-			os.Remove(filename)
-			logger.Log("File " + filename + " removed")
-		}(r, i)
+// storeImages writes the faces found by fd in gray levels, at the face size of
+// the configuration, in the directory basePath and returns the paths of the
+// files.
+func (fi *FaceRecognitionItem) storeImages(fd *facedetector.FaceDetector, basePath string) []string {
+	paths := make([]string, 0)
+	width, height := GetConfig().GetFaceSize()
+	for i, f := range detect(fd) {
+		f.Image = resizeFace(f.Image, width, height)
+		filename := basePath + string(filepath.Separator) + faceFilename(fi.User.Key()+"-"+uniqueID(), f.Rect, i)
+		if err := f.Save(filename); err != nil {
+			logger.Log(err.Error())
+			continue
+		}
+		logger.Log("File " + filename + " saved as pgm.")
+		paths = append(paths, filename)
 	}
-	wc.Wait()
+	return paths
 }
 
 func (fi *FaceRecognitionItem) DetectFaces(images []string) int {
@@ -348,6 +323,7 @@ func (fi *FaceRecognitionItem) DetectFaces(images []string) int {
 		}
 	}
 	var wc sync.WaitGroup
+	var lock sync.Mutex
 
 	for _, img := range images {
 		wc.Add(1)
@@ -355,11 +331,14 @@ func (fi *FaceRecognitionItem) DetectFaces(images []string) int {
 			defer wc.Done()
 			logger.Log("Searching faces in image file : " + imageFilename)
 			fd := facedetector.NewFaceDetector(imageFilename, GetConfig().FaceDetectionConfigurationFile)
-			fi.storeImages(fd, userBasePath)
+			paths := fi.storeImages(fd, userBasePath)
+			lock.Lock()
+			fi.TrainingImages = append(fi.TrainingImages, paths...)
+			lock.Unlock()
 		}(img)
 	}
-	logger.Log("Found " + strconv.Itoa(len(fi.TrainingImages)) + " faces.")
 	wc.Wait()
+	logger.Log("Found " + strconv.Itoa(len(fi.TrainingImages)) + " faces.")
 	return len(fi.TrainingImages)
 }
 
@@ -371,14 +350,147 @@ func (fl *FaceRecognitionLib) ImportIntoDB(face *facedetector.FaceDetector, user
 			fmt.Fprintf(os.Stderr, "error while creating directories [%s], %x\n", basePath, err)
 		}
 	}
-	user.storeImages(face, basePath)
+	user.TrainingImages = append(user.TrainingImages, user.storeImages(face, basePath)...)
 	fl.AddUserFace(user)
 	return user
 }
 
-func (fl *FaceRecognitionLib) Train(featureType string) {
+func (fl *FaceRecognitionLib) Train(featureType string) error {
 	t := fl.GetTrainer(featureType)
-	t.Train()
+	return t.Train()
+}
+
+// LoadOrTrain returns the trainer persisted in the configuration base path if it
+// matches the library and the feature type, otherwise trains a new one and saves it.
+func (fl *FaceRecognitionLib) LoadOrTrain(featureType string, params Parameters, metric string) *Trainer {
+	path := GetConfig().GetTrainedModel()
+	t, err := LoadTrainer(path)
+	if err == nil {
+		if t.FeatureType != featureType {
+			err = fmt.Errorf("trained model feature type %s is not %s", t.FeatureType, featureType)
+		} else if !sameFeatureParameters(featureType, t.FeatureParameters, params) {
+			err = fmt.Errorf("trained model feature parameters %v are not %v", t.FeatureParameters, params)
+		} else if !samePreprocessing(t.Preprocessing, GetConfig().Preprocessing) {
+			err = fmt.Errorf("trained model preprocessing %q is not %q", t.Preprocessing, GetConfig().Preprocessing)
+		} else if t.Alignment != GetConfig().AlignmentPolicy {
+			err = fmt.Errorf("trained model alignment %+v is not %+v", t.Alignment, GetConfig().AlignmentPolicy)
+		} else {
+			err = t.CheckLibrary(fl)
+		}
+	}
+	if err == nil {
+		logger.Logf("trained model %s loaded", path)
+		t.AfterRetrain = saveAfterRetrain(path)
+		conf := GetConfig()
+		t.RetrainPolicy = conf.RetrainPolicy
+		metricChanged := t.MetricName != metric
+		if metricChanged {
+			// the projections do not depend on the metric, no need to retrain
+			if err := t.SetMetric(metric); err != nil {
+				logger.Logf("cannot use metric %s, keeping %s : %v", metric, t.MetricName, err)
+				metricChanged = false
+			}
+		}
+		if metricChanged ||
+			t.OpenSet != conf.OpenSet ||
+			t.RejectionThreshold != conf.RejectionThreshold ||
+			t.PerIdentityThreshold != conf.ThresholdPerIdentity {
+			t.OpenSet = conf.OpenSet
+			t.RejectionThreshold = conf.RejectionThreshold
+			t.PerIdentityThreshold = conf.ThresholdPerIdentity
+			t.UpdateThreshold()
+			if err := t.Save(path); err != nil {
+				logger.Logf("cannot save trained model %s with error %v", path, err)
+			}
+		}
+		return t
+	}
+	logger.Logf("trained model %s not usable (%v), training", path, err)
+	t = fl.GetTrainer(featureType)
+	t.FeatureParameters = params
+	t.MetricName = metric
+	if err := t.Train(); err != nil {
+		logger.Logf("cannot train %s with error %v", featureType, err)
+	} else if err := t.Save(path); err != nil {
+		logger.Logf("cannot save trained model %s with error %v", path, err)
+	}
+	t.AfterRetrain = saveAfterRetrain(path)
+	return t
+}
+
+// sameFeatureParameters returns true if the parameters a and b of the feature
+// extractor name are the same once the default values are set.
+func sameFeatureParameters(name string, a, b Parameters) bool {
+	r, err := GetFeatureExtractorRegistration(name)
+	if err != nil {
+		return false
+	}
+	na, errA := r.NormalizeParameters(a)
+	nb, errB := r.NormalizeParameters(b)
+	return errA == nil && errB == nil && reflect.DeepEqual(na, nb)
+}
+
+func saveAfterRetrain(path string) func(t *Trainer) {
+	return func(t *Trainer) {
+		if err := t.Save(path); err != nil {
+			logger.Logf("cannot save retrained model %s with error %v", path, err)
+		}
+	}
+}
+
+// Enroll enrolls the new training images of the user in the trainer without
+// retraining it, then adds them in the library. The library and the trainer
+// are saved in the configuration base path, neither changes if an image
+// cannot be enrolled.
+func (fl *FaceRecognitionLib) Enroll(t *Trainer, u *FaceRecognitionItem) error {
+	images := make([]*algorithm.Matrix, 0, len(u.TrainingImages))
+	labels := make([]string, 0, len(u.TrainingImages))
+	sources := make([]string, 0, len(u.TrainingImages))
+	for _, path := range u.TrainingImages {
+		m, err := ReadMatrix(path)
+		if err != nil {
+			return errors.Wrapf(err, "cannot enroll %s", u.GetKey())
+		}
+		images = append(images, m.Vectorize())
+		labels = append(labels, u.GetKey())
+		sources = append(sources, path)
+	}
+	if err := t.EnrollImages(images, labels, sources); err != nil {
+		return errors.Wrapf(err, "cannot enroll %s", u.GetKey())
+	}
+	if err := fl.addUserFace(u); err != nil {
+		return err
+	}
+	t.SetLibraryFingerprint(fl.Fingerprint())
+	return t.Save(GetConfig().GetTrainedModel())
+}
+
+// Fingerprint identifies the content of the library a trainer is built from,
+// the face size and the distinct training images of each user, sorted.
+func (fl *FaceRecognitionLib) Fingerprint() string {
+	keys := make([]string, 0, len(fl.Items))
+	for key := range fl.Items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	fmt.Fprintf(h, "%dx%d\n", fl.Width, fl.Height)
+	for _, key := range keys {
+		paths := make([]string, 0, len(fl.Items[key].TrainingImages))
+		listed := make(map[string]bool)
+		for _, path := range fl.Items[key].TrainingImages {
+			if !listed[path] {
+				listed[path] = true
+				paths = append(paths, path)
+			}
+		}
+		sort.Strings(paths)
+		fmt.Fprintf(h, "%s\n", key)
+		for _, path := range paths {
+			fmt.Fprintf(h, "\t%s\n", path)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 func (fl *FaceRecognitionLib) GetTrainer(featureType string) *Trainer {
@@ -387,8 +499,24 @@ func (fl *FaceRecognitionLib) GetTrainer(featureType string) *Trainer {
 	// et ne pas insérer l'image d'un utilisateur sir numOfComponents est
 	// dépassé pour cet utilisateur.
 	// K's choice explained here http://sebastianraschka.com/Articles/2014_pca_step_by_step.html
+	// the number of components is chosen by the feature extractor parameters
 	getDistanceFunc := &L1{}
-	t := NewTrainerArgs(featureType, 2, len(fl.Items)+1, getDistanceFunc.GetDistance)
+	t := NewTrainerArgs(featureType, 2, 0, getDistanceFunc.GetDistance)
+	t.MetricName = L1Metric
+	if conf := GetConfig(); conf != nil && conf.Metric != "" {
+		t.MetricName = conf.Metric
+	}
+	t.Width = fl.Width
+	t.Height = fl.Height
+	t.LibraryFingerprint = fl.Fingerprint()
+	if conf := GetConfig(); conf != nil {
+		t.OpenSet = conf.OpenSet
+		t.RejectionThreshold = conf.RejectionThreshold
+		t.PerIdentityThreshold = conf.ThresholdPerIdentity
+		t.RetrainPolicy = conf.RetrainPolicy
+		t.Alignment = conf.AlignmentPolicy
+		t.Preprocessing = conf.Preprocessing
+	}
 
 	for username, user := range fl.Items {
 		numOfComponents := 0
@@ -397,9 +525,13 @@ func (fl *FaceRecognitionLib) GetTrainer(featureType string) *Trainer {
 				numOfComponents++
 				if numOfComponents > fl.MinimalNumOfComponents {
 					break
-				} else {
-					t.Add(ToMatrix(path).Vectorize(), username)
 				}
+				m, err := ReadMatrix(path)
+				if err != nil {
+					logger.Logf("skipping training image of %s : %v", username, err)
+					continue
+				}
+				t.AddWithSource(m.Vectorize(), username, path)
 			}
 		}
 	}
@@ -414,7 +546,7 @@ func SumPixels(face []float64, width int, height int) float64 {
 	return math.Abs(sum / float64(width*height) / 0xffff)
 }
 
-func SaveImageTo(img *image.Gray16, path string) {
+func SaveImageTo(img image.Image, path string) {
 	out, err := os.Create(path)
 	if err != nil {
 		logger.Log(err.Error())
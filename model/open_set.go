@@ -0,0 +1,224 @@
+package model
+
+import (
+	"math"
+	"sort"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/pkg/errors"
+)
+
+// RejectionThreshold is the maximal distance between a probe and the nearest
+// training image of a person to consider the probe as this person.
+// It is only valid for the feature type and the metric it was calibrated with.
+type RejectionThreshold struct {
+	FeatureType string             `json:"feature_type"`
+	Metric      string             `json:"metric"`
+	Global      float64            `json:"global"`
+	PerIdentity map[string]float64 `json:"per_identity,omitempty"`
+}
+
+// Get returns the threshold of the identity label, or the global one.
+func (r *RejectionThreshold) Get(label string) float64 {
+	if v, ok := r.PerIdentity[label]; ok {
+		return v
+	}
+	return r.Global
+}
+
+// Recognition is the result of an open set recognition, Label is empty if the
+// person is unknown and Nearest is always the nearest person found.
+type Recognition struct {
+	Label      string  `json:"label"`
+	Nearest    string  `json:"nearest"`
+	Known      bool    `json:"known"`
+	Distance   float64 `json:"distance"`
+	Threshold  float64 `json:"threshold"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Calibrate computes the rejection threshold of the trainer from its model :
+// the genuine distances (nearest other image of the same person) and the
+// impostor distances (nearest image of another person) of each training image
+// are separated by the value minimizing the sum of the false rejection and the
+// false acceptance rates.
+func (t *Trainer) Calibrate() error {
+	threshold, err := t.calibrate()
+	if err != nil {
+		return err
+	}
+	t.Threshold = threshold
+	return nil
+}
+
+func (t *Trainer) calibrate() (*RejectionThreshold, error) {
+	genuine := make(map[string][]float64)
+	impostor := make(map[string][]float64)
+	allGenuine := make([]float64, 0)
+	allImpostor := make([]float64, 0)
+	for i, p := range t.Model {
+		nearestGenuine := math.MaxFloat64
+		nearestImpostor := make(map[string]float64)
+		for j, q := range t.Model {
+			if i == j {
+				continue
+			}
+			d := t.Metric(q.Matrix, p.Matrix)
+			if q.Label == p.Label {
+				nearestGenuine = math.Min(nearestGenuine, d)
+			} else if v, ok := nearestImpostor[q.Label]; !ok || d < v {
+				nearestImpostor[q.Label] = d
+			}
+		}
+		if nearestGenuine != math.MaxFloat64 {
+			genuine[p.Label] = append(genuine[p.Label], nearestGenuine)
+			allGenuine = append(allGenuine, nearestGenuine)
+		}
+		nearest := math.MaxFloat64
+		for label, d := range nearestImpostor {
+			impostor[label] = append(impostor[label], d)
+			nearest = math.Min(nearest, d)
+		}
+		if nearest != math.MaxFloat64 {
+			allImpostor = append(allImpostor, nearest)
+		}
+	}
+	if len(allGenuine) == 0 && len(allImpostor) == 0 {
+		return nil, errors.New("not enough training images to calibrate the rejection threshold")
+	}
+
+	threshold := &RejectionThreshold{
+		FeatureType: t.FeatureType,
+		Metric:      t.MetricName,
+		Global:      separatingThreshold(allGenuine, allImpostor),
+	}
+	if t.PerIdentityThreshold {
+		threshold.PerIdentity = make(map[string]float64)
+		for label, g := range genuine {
+			if len(impostor[label]) > 0 {
+				threshold.PerIdentity[label] = separatingThreshold(g, impostor[label])
+			}
+		}
+	}
+	return threshold, nil
+}
+
+// separatingThreshold returns the value minimizing the false rejection rate of
+// the genuine distances plus the false acceptance rate of the impostor ones.
+func separatingThreshold(genuine, impostor []float64) float64 {
+	if len(impostor) == 0 {
+		return maxOf(genuine)
+	}
+	if len(genuine) == 0 {
+		return minOf(impostor) / 2.
+	}
+	candidates := append(append(make([]float64, 0, len(genuine)+len(impostor)), genuine...), impostor...)
+	sort.Float64s(candidates)
+	best := candidates[0]
+	bestError := math.MaxFloat64
+	for i, c := range candidates {
+		frr := 0.
+		for _, g := range genuine {
+			if g > c {
+				frr++
+			}
+		}
+		far := 0.
+		for _, im := range impostor {
+			if im <= c {
+				far++
+			}
+		}
+		e := frr/float64(len(genuine)) + far/float64(len(impostor))
+		if e < bestError {
+			bestError = e
+			// take the middle of the gap with the next distance
+			if i+1 < len(candidates) {
+				best = (c + candidates[i+1]) / 2.
+			} else {
+				best = c
+			}
+		}
+	}
+	return best
+}
+
+// RecognizeOpenSet returns the nearest person of matrix, the result is unknown
+// if the probe is farther than the rejection threshold from every training
+// image of this person. Without threshold the recognition is closed set.
+func (t *Trainer) RecognizeOpenSet(matrix *algorithm.Matrix) *Recognition {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if err := t.checkProbe(matrix); err != nil {
+		logger.Logf("cannot recognize : %v", err)
+		return &Recognition{Distance: math.MaxFloat64}
+	}
+	r, _, err := t.recognize(t.project(matrix))
+	if err != nil {
+		logger.Logf("cannot recognize : %v", err)
+		return &Recognition{Distance: math.MaxFloat64}
+	}
+	return r
+}
+
+// recognize returns the recognition of the projection testCase, see
+// RecognizeOpenSet, and the similarity of the person among the K nearest
+// training images, see Classify.
+func (t *Trainer) recognize(testCase *algorithm.Matrix) (*Recognition, float64, error) {
+	// NearestNeighbors stores the distances in the training matrices, work on a copy
+	neighbors, err := NearestNeighbors(NewSliceProjectedTrainingMatrix(t.Model), testCase, t.K, t.Metric)
+	if err != nil {
+		return nil, 0, err
+	}
+	label, similarity := Classify(neighbors)
+	r := &Recognition{Label: label, Nearest: label, Known: label != "", Distance: math.MaxFloat64}
+	for _, p := range t.Model {
+		if p.Label == label {
+			r.Distance = math.Min(r.Distance, t.Metric(p.Matrix, testCase))
+		}
+	}
+	if !r.Known {
+		return r, similarity, nil
+	}
+	if !t.openSet() {
+		r.Confidence = 1.
+		return r, similarity, nil
+	}
+	r.Threshold = t.Threshold.Get(label)
+	r.Confidence = confidence(r.Distance, r.Threshold)
+	if r.Distance > r.Threshold {
+		r.Known = false
+		r.Label = ""
+	}
+	return r, similarity, nil
+}
+
+// openSet returns true if the trainer rejects the unknown persons.
+func (t *Trainer) openSet() bool {
+	return t.OpenSet && t.Threshold != nil
+}
+
+// confidence is 1 for a null distance, .5 at the threshold and 0 at twice the threshold.
+func confidence(distance, threshold float64) float64 {
+	if threshold <= 0 {
+		return 0.
+	}
+	return math.Max(0., math.Min(1., 1.-distance/(2.*threshold)))
+}
+
+func maxOf(values []float64) float64 {
+	m := values[0]
+	for _, v := range values {
+		m = math.Max(m, v)
+	}
+	return m
+}
+
+func minOf(values []float64) float64 {
+	m := values[0]
+	for _, v := range values {
+		m = math.Min(m, v)
+	}
+	return m
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"crypto/rand"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"sync"
+
+	pnm "github.com/jbuchbinder/gopnm"
+	"github.com/jeromelesaux/facedetection/facedetector"
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/nfnt/resize"
+	"github.com/pkg/errors"
+)
+
+// Face is a face found in an image, cropped to the aspect ratio of the face
+// size, converted to gray levels and resized to the size of the library.
+type Face struct {
+	Rect  image.Rectangle // position of the face in the image
+	Image *image.Gray
+}
+
+// Vector returns the gray levels of the face vectorized by columns, the input
+// of the trainers.
+func (f *Face) Vector() *algorithm.Matrix {
+	return GrayMatrix(f.Image).Vectorize()
+}
+
+// Save writes the face in the PGM format in the file path.
+func (f *Face) Save(path string) error {
+	fw, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create file %s", path)
+	}
+	defer fw.Close()
+	if err := pnm.Encode(fw, f.Image, pnm.PGM); err != nil {
+		return errors.Wrapf(err, "cannot encode to pnm file %s", path)
+	}
+	return nil
+}
+
+// Faces returns the faces detected in img, the whole pipeline runs in memory
+// so it can be called concurrently.
+func (fl *FaceRecognitionLib) Faces(img image.Image) []*Face {
+	fd := facedetector.NewFaceDetector(img, GetConfig().FaceDetectionConfigurationFile)
+	return fl.faces(fd)
+}
+
+// faces normalizes the faces found by the detector fd.
+func (fl *FaceRecognitionLib) faces(fd *facedetector.FaceDetector) []*Face {
+	faces := detect(fd)
+	var wc sync.WaitGroup
+	for _, f := range faces {
+		wc.Add(1)
+		go func(f *Face) {
+			defer wc.Done()
+			f.Image = fl.NormalizeFace(f.Image)
+		}(f)
+	}
+	wc.Wait()
+	return faces
+}
+
+// detect returns the faces found by the detector fd cropped in gray levels,
+// at their size in the image, the trainer aligns them. The rectangles of the
+// detector are grown to the aspect ratio of the face size.
+func detect(fd *facedetector.FaceDetector) []*Face {
+	width, height := GetConfig().GetFaceSize()
+	rects := fd.GetFaces()
+	faces := make([]*Face, len(rects))
+	for i, r := range rects {
+		rect := faceRect(image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height), fd.Image.Bounds(), width, height)
+		faces[i] = &Face{Rect: rect, Image: grayCrop(fd.Image, rect)}
+	}
+	return faces
+}
+
+// Vectorize returns the vector of the whole image img normalized to the size
+// of the library, used when no face is detected.
+func (fl *FaceRecognitionLib) Vectorize(img image.Image) *algorithm.Matrix {
+	return GrayMatrix(fl.NormalizeFace(img)).Vectorize()
+}
+
+// NormalizeFace returns img in gray levels resized to the size of the library.
+func (fl *FaceRecognitionLib) NormalizeFace(img image.Image) *image.Gray {
+	return resizeFace(img, fl.Width, fl.Height)
+}
+
+// resizeFace returns img in gray levels resized to width x height.
+func resizeFace(img image.Image, width, height int) *image.Gray {
+	gray := grayCrop(img, img.Bounds())
+	if gray.Bounds().Dx() == width && gray.Bounds().Dy() == height {
+		return gray
+	}
+	return grayCrop(resize.Resize(uint(width), uint(height), gray, resize.Lanczos3), image.Rect(0, 0, width, height))
+}
+
+// faceRect returns the rectangle r of a detected face grown around its center
+// to the aspect ratio of width x height, within bounds.
+func faceRect(r, bounds image.Rectangle, width, height int) image.Rectangle {
+	w, h := r.Dx(), r.Dy()
+	if w*height > h*width {
+		h = (w*height + width/2) / width
+	} else {
+		w = (h*width + height/2) / height
+	}
+	corner := r.Min.Add(r.Max).Div(2).Sub(image.Pt(w/2, h/2))
+	grown := image.Rectangle{Min: corner, Max: corner.Add(image.Pt(w, h))}
+	return centeredCrop(grown.Intersect(bounds), width, height)
+}
+
+// centeredCrop returns the largest rectangle of the aspect ratio of width x
+// height centered in r.
+func centeredCrop(r image.Rectangle, width, height int) image.Rectangle {
+	w, h := r.Dx(), r.Dy()
+	if w*height > h*width {
+		w = (h*width + height/2) / height
+	} else {
+		h = (w*height + width/2) / width
+	}
+	corner := r.Min.Add(image.Pt((r.Dx()-w)/2, (r.Dy()-h)/2))
+	return image.Rectangle{Min: corner, Max: corner.Add(image.Pt(w, h))}
+}
+
+// DrawFaces returns a copy of img with the rectangles of the faces drawn.
+func DrawFaces(img image.Image, faces []*Face) *image.RGBA {
+	drawn := facedetector.ConvertToRGBA(img)
+	for _, f := range faces {
+		facedetector.DrawRect(f.Rect.Min.X, f.Rect.Min.Y, f.Rect.Max.X, f.Rect.Max.Y, 2, drawn)
+	}
+	return drawn
+}
+
+// grayCrop returns the rectangle r of img in gray levels with its origin at
+// (0, 0), the conversion is the one of the PGM encoder.
+func grayCrop(img image.Image, r image.Rectangle) *image.Gray {
+	if g, ok := img.(*image.Gray); ok && g.Bounds() == r && r.Min == (image.Point{}) {
+		return g
+	}
+	gray := image.NewGray(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, r.Min, draw.Src)
+	return gray
+}
+
+// uniqueID returns a random identifier for the names of the face files.
+func uniqueID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%X", b)
+}
@@ -0,0 +1,493 @@
+package model
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/pkg/errors"
+)
+
+var (
+	// HistogramEqualization spreads the gray levels of the image uniformly.
+	HistogramEqualization = "histeq"
+	// CLAHE equalizes the histograms of the tiles of the image with a limited
+	// contrast, interpolated between the tiles.
+	CLAHE = "clahe"
+	// GammaCorrection raises the gray levels to the power gamma.
+	GammaCorrection = "gamma"
+	// DifferenceOfGaussians is a band pass filter removing the low frequencies
+	// of the shading and the high frequencies of the noise.
+	DifferenceOfGaussians = "dog"
+	// TanTriggs chains a gamma correction, a difference of gaussians and a
+	// contrast equalization (Tan and Triggs).
+	TanTriggs = "tantriggs"
+)
+
+// PreprocessingStep is a step of the illumination normalization, Parameters
+// are the ones of the step, the default values are used if not set.
+type PreprocessingStep struct {
+	Name       string     `json:"name"`
+	Parameters Parameters `json:"parameters,omitempty"`
+}
+
+// Preprocessing is the chain of illumination normalizations applied to the
+// images before the feature extraction, at training and recognition time.
+type Preprocessing []*PreprocessingStep
+
+type preprocessingDefinition struct {
+	parameters []*ParameterDefinition
+	check      func(p Parameters) error // optional check of the parameters together
+	apply      func(img *algorithm.Matrix, p Parameters) *algorithm.Matrix
+}
+
+var preprocessingDefinitions = map[string]*preprocessingDefinition{
+	HistogramEqualization: {
+		apply: func(img *algorithm.Matrix, p Parameters) *algorithm.Matrix {
+			return equalizeHistogram(img)
+		},
+	},
+	CLAHE: {
+		parameters: []*ParameterDefinition{
+			{Name: "tiles", Type: IntParameter, Default: "8", Validate: positive, Description: "number of tiles horizontally and vertically"},
+			{Name: "clip_limit", Type: FloatParameter, Default: "2", Validate: atLeastOne, Description: "maximum height of the histogram bins relative to a uniform histogram"},
+		},
+		apply: func(img *algorithm.Matrix, p Parameters) *algorithm.Matrix {
+			return clahe(img, p.Int("tiles"), p.Float("clip_limit"))
+		},
+	},
+	GammaCorrection: {
+		parameters: []*ParameterDefinition{
+			{Name: "gamma", Type: FloatParameter, Default: "0.5", Validate: positive, Description: "exponent of the gray levels, lower than 1 brightens the shadows"},
+		},
+		apply: func(img *algorithm.Matrix, p Parameters) *algorithm.Matrix {
+			return gammaCorrection(img, p.Float("gamma"))
+		},
+	},
+	DifferenceOfGaussians: {
+		parameters: sigmaParameters(),
+		check:      checkSigmas,
+		apply: func(img *algorithm.Matrix, p Parameters) *algorithm.Matrix {
+			return rescale(differenceOfGaussians(img, p.Float("sigma0"), p.Float("sigma1")))
+		},
+	},
+	TanTriggs: {
+		parameters: append(sigmaParameters(),
+			&ParameterDefinition{Name: "gamma", Type: FloatParameter, Default: "0.2", Validate: positive, Description: "exponent of the gray levels"},
+			&ParameterDefinition{Name: "alpha", Type: FloatParameter, Default: "0.1", Validate: positive, Description: "exponent of the contrast equalization, reduces the influence of the large values"},
+			&ParameterDefinition{Name: "tau", Type: FloatParameter, Default: "10", Validate: positive, Description: "threshold of the contrast equalization"},
+		),
+		check: checkSigmas,
+		apply: func(img *algorithm.Matrix, p Parameters) *algorithm.Matrix {
+			return tanTriggs(img, p.Float("gamma"), p.Float("sigma0"), p.Float("sigma1"), p.Float("alpha"), p.Float("tau"))
+		},
+	},
+}
+
+func sigmaParameters() []*ParameterDefinition {
+	return []*ParameterDefinition{
+		{Name: "sigma0", Type: FloatParameter, Default: "1", Validate: notNegative, Description: "standard deviation of the inner gaussian, no blur if 0"},
+		{Name: "sigma1", Type: FloatParameter, Default: "2", Validate: positive, Description: "standard deviation of the outer gaussian"},
+	}
+}
+
+func checkSigmas(p Parameters) error {
+	if p.Float("sigma1") <= p.Float("sigma0") {
+		return errors.Errorf("sigma1 %s must be greater than sigma0 %s", p["sigma1"], p["sigma0"])
+	}
+	return nil
+}
+
+func positive(value string) error {
+	if v, _ := strconv.ParseFloat(value, 64); v <= 0 {
+		return errors.New("must be positive")
+	}
+	return nil
+}
+
+func notNegative(value string) error {
+	if v, _ := strconv.ParseFloat(value, 64); v < 0 {
+		return errors.New("must not be negative")
+	}
+	return nil
+}
+
+func atLeastOne(value string) error {
+	if v, _ := strconv.ParseFloat(value, 64); v < 1 {
+		return errors.New("must be at least 1")
+	}
+	return nil
+}
+
+// PreprocessingSteps returns the names of the preprocessing steps sorted.
+func PreprocessingSteps() []string {
+	names := make([]string, 0, len(preprocessingDefinitions))
+	for name := range preprocessingDefinitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Normalize validates the steps of the chain and returns them with the
+// default value of the parameters not set, nil for an empty chain.
+func (p Preprocessing) Normalize() (Preprocessing, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	normalized := make(Preprocessing, 0, len(p))
+	for _, step := range p {
+		d, ok := preprocessingDefinitions[step.Name]
+		if !ok {
+			return nil, errors.Errorf("unknown preprocessing step %s, expected one of %v", step.Name, PreprocessingSteps())
+		}
+		params, err := normalizeParameters("preprocessing step "+step.Name, d.parameters, step.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		if d.check != nil {
+			if err := d.check(params); err != nil {
+				return nil, errors.Wrapf(err, "preprocessing step %s", step.Name)
+			}
+		}
+		normalized = append(normalized, &PreprocessingStep{Name: step.Name, Parameters: params})
+	}
+	return normalized, nil
+}
+
+// Apply returns the image m vectorized by columns, of width x height pixels,
+// normalized by the steps of the chain. The chain must be the one returned by
+// Normalize, its parameters are not validated again.
+func (p Preprocessing) Apply(m *algorithm.Matrix, width, height int) (*algorithm.Matrix, error) {
+	if len(p) == 0 {
+		return m, nil
+	}
+	if width <= 0 || height <= 0 || m.N != 1 || m.M != width*height {
+		return nil, errors.Wrapf(ErrDimensionMismatch, "cannot preprocess an image of %dx%d as %dx%d pixels", m.M, m.N, width, height)
+	}
+	img := imageOfVector(m, width, height)
+	for _, step := range p {
+		d, ok := preprocessingDefinitions[step.Name]
+		if !ok {
+			return nil, errors.Errorf("unknown preprocessing step %s, expected one of %v", step.Name, PreprocessingSteps())
+		}
+		img = d.apply(img, step.Parameters)
+	}
+	return img.Vectorize(), nil
+}
+
+// String returns the chain in the format read by ParsePreprocessing.
+func (p Preprocessing) String() string {
+	steps := make([]string, 0, len(p))
+	for _, step := range p {
+		names := make([]string, 0, len(step.Parameters))
+		for name := range step.Parameters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		s := step.Name
+		for _, name := range names {
+			s += ":" + name + "=" + step.Parameters[name]
+		}
+		steps = append(steps, s)
+	}
+	return strings.Join(steps, ",")
+}
+
+// ParsePreprocessing reads a chain of steps separated by commas, each step is
+// its name followed by its parameters as :name=value, for instance
+// "gamma:gamma=0.2,dog:sigma0=1:sigma1=2".
+func ParsePreprocessing(s string) (Preprocessing, error) {
+	p := make(Preprocessing, 0)
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		parts := strings.Split(field, ":")
+		step := &PreprocessingStep{Name: parts[0], Parameters: make(Parameters)}
+		for _, kv := range parts[1:] {
+			nv := strings.SplitN(kv, "=", 2)
+			if len(nv) != 2 {
+				return nil, errors.Errorf("preprocessing step %s parameter %s is not name=value", step.Name, kv)
+			}
+			step.Parameters[nv[0]] = nv[1]
+		}
+		p = append(p, step)
+	}
+	return p.Normalize()
+}
+
+// samePreprocessing returns true if the chains a and b are the same once the
+// default values are set.
+func samePreprocessing(a, b Preprocessing) bool {
+	na, errA := a.Normalize()
+	nb, errB := b.Normalize()
+	return errA == nil && errB == nil && reflect.DeepEqual(na, nb)
+}
+
+// imageOfVector returns the image m vectorized by columns as a matrix of
+// height rows and width columns.
+func imageOfVector(m *algorithm.Matrix, width, height int) *algorithm.Matrix {
+	img := algorithm.NewMatrix(height, width)
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.A[y][x] = m.A[x*height+y][0]
+		}
+	}
+	return img
+}
+
+// grayLevel returns the value v clamped to a gray level between 0 and 255.
+func grayLevel(v float64) int {
+	return int(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+// equalizeHistogram maps the gray levels of img by their cumulative
+// distribution, the darkest one to 0 and the lightest one to 255.
+func equalizeHistogram(img *algorithm.Matrix) *algorithm.Matrix {
+	histogram := make([]float64, 256)
+	for _, row := range img.A {
+		for _, v := range row {
+			histogram[grayLevel(v)]++
+		}
+	}
+	lut := cumulativeLut(histogram)
+	equalized := algorithm.NewMatrix(img.M, img.N)
+	for i := 0; i < img.M; i++ {
+		for j := 0; j < img.N; j++ {
+			equalized.A[i][j] = lut[grayLevel(img.A[i][j])]
+		}
+	}
+	return equalized
+}
+
+// cumulativeLut returns the mapping of the gray levels by the cumulative
+// distribution of histogram, scaled between 0 and 255.
+func cumulativeLut(histogram []float64) []float64 {
+	lut := make([]float64, len(histogram))
+	total, first := 0., -1.
+	for _, count := range histogram {
+		total += count
+	}
+	sum := 0.
+	for b, count := range histogram {
+		sum += count
+		if first < 0 && count > 0 {
+			first = count
+		}
+		if total > first {
+			lut[b] = math.Max(0, 255*(sum-first)/(total-first))
+		} else {
+			lut[b] = float64(b)
+		}
+	}
+	return lut
+}
+
+// clahe equalizes the histograms of tiles x tiles tiles clipped at clipLimit
+// times the mean height of a bin, the excess is spread over all the bins. The
+// mapping of a pixel is interpolated between the 4 nearest tile centers.
+func clahe(img *algorithm.Matrix, tiles int, clipLimit float64) *algorithm.Matrix {
+	height, width := img.M, img.N
+	tilesY, tilesX := minInt(tiles, height), minInt(tiles, width)
+	bounds := func(size, count int) []int {
+		b := make([]int, count+1)
+		for i := range b {
+			b[i] = i * size / count
+		}
+		return b
+	}
+	rows, columns := bounds(height, tilesY), bounds(width, tilesX)
+	luts := make([][][]float64, tilesY)
+	for ty := range luts {
+		luts[ty] = make([][]float64, tilesX)
+		for tx := range luts[ty] {
+			histogram := make([]float64, 256)
+			for y := rows[ty]; y < rows[ty+1]; y++ {
+				for x := columns[tx]; x < columns[tx+1]; x++ {
+					histogram[grayLevel(img.A[y][x])]++
+				}
+			}
+			pixels := float64((rows[ty+1] - rows[ty]) * (columns[tx+1] - columns[tx]))
+			limit := math.Max(1, clipLimit*pixels/256)
+			excess := 0.
+			for b, count := range histogram {
+				if count > limit {
+					excess += count - limit
+					histogram[b] = limit
+				}
+			}
+			for b := range histogram {
+				histogram[b] += excess / 256
+			}
+			// the lut of a clipped histogram keeps the darkest level at 0
+			lut := make([]float64, 256)
+			sum := 0.
+			for b, count := range histogram {
+				sum += count
+				lut[b] = 255 * sum / pixels
+			}
+			luts[ty][tx] = lut
+		}
+	}
+	// position of a pixel between the tile centers : the index of the tile
+	// before it and the weight of the tile after it
+	interpolation := func(bounds []int, position int) (int, float64) {
+		count := len(bounds) - 1
+		center := func(i int) float64 { return float64(bounds[i]+bounds[i+1]-1) / 2 }
+		if float64(position) <= center(0) {
+			return 0, 0
+		}
+		if float64(position) >= center(count-1) {
+			return count - 1, 0
+		}
+		i := 0
+		for float64(position) >= center(i+1) {
+			i++
+		}
+		return i, (float64(position) - center(i)) / (center(i+1) - center(i))
+	}
+	equalized := algorithm.NewMatrix(height, width)
+	for y := 0; y < height; y++ {
+		ty, fy := interpolation(rows, y)
+		ty1 := minInt(ty+1, tilesY-1)
+		for x := 0; x < width; x++ {
+			tx, fx := interpolation(columns, x)
+			tx1 := minInt(tx+1, tilesX-1)
+			v := grayLevel(img.A[y][x])
+			equalized.A[y][x] = (1-fy)*((1-fx)*luts[ty][tx][v]+fx*luts[ty][tx1][v]) +
+				fy*((1-fx)*luts[ty1][tx][v]+fx*luts[ty1][tx1][v])
+		}
+	}
+	return equalized
+}
+
+// gammaCorrection returns 255 (v / 255)^gamma for each gray level v.
+func gammaCorrection(img *algorithm.Matrix, gamma float64) *algorithm.Matrix {
+	corrected := algorithm.NewMatrix(img.M, img.N)
+	for i := 0; i < img.M; i++ {
+		for j := 0; j < img.N; j++ {
+			corrected.A[i][j] = 255 * math.Pow(math.Max(0, img.A[i][j])/255, gamma)
+		}
+	}
+	return corrected
+}
+
+// gaussianBlur returns img convolved by a gaussian of standard deviation
+// sigma, the borders are extended. It is separable, rows then columns.
+func gaussianBlur(img *algorithm.Matrix, sigma float64) *algorithm.Matrix {
+	if sigma <= 0 {
+		return img.Copy()
+	}
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	sum := 0.
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	height, width := img.M, img.N
+	horizontal := algorithm.NewMatrix(height, width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 0.
+			for k, w := range kernel {
+				v += w * img.A[y][clampInt(x+k-radius, 0, width-1)]
+			}
+			horizontal.A[y][x] = v
+		}
+	}
+	blurred := algorithm.NewMatrix(height, width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 0.
+			for k, w := range kernel {
+				v += w * horizontal.A[clampInt(y+k-radius, 0, height-1)][x]
+			}
+			blurred.A[y][x] = v
+		}
+	}
+	return blurred
+}
+
+// differenceOfGaussians returns img blurred by sigma0 minus img blurred by
+// sigma1.
+func differenceOfGaussians(img *algorithm.Matrix, sigma0, sigma1 float64) *algorithm.Matrix {
+	dog := gaussianBlur(img, sigma0)
+	dog.MinusInPlace(gaussianBlur(img, sigma1))
+	return dog
+}
+
+// tanTriggs returns the illumination normalization of Tan and Triggs : the
+// gamma correction, the difference of gaussians, the contrast equalization
+// and the compression of the values by tau tanh(x / tau), mapped from
+// [-tau, tau] to [0, 255].
+func tanTriggs(img *algorithm.Matrix, gamma, sigma0, sigma1, alpha, tau float64) *algorithm.Matrix {
+	corrected := algorithm.NewMatrix(img.M, img.N)
+	for i := 0; i < img.M; i++ {
+		for j := 0; j < img.N; j++ {
+			corrected.A[i][j] = math.Pow(math.Max(0, img.A[i][j]), gamma)
+		}
+	}
+	x := differenceOfGaussians(corrected, sigma0, sigma1)
+	// x / mean(|x|^alpha)^(1/alpha) then x / mean(min(tau, |x|)^alpha)^(1/alpha)
+	for pass := 0; pass < 2; pass++ {
+		mean := 0.
+		for _, v := range x.Data {
+			v = math.Abs(v)
+			if pass == 1 {
+				v = math.Min(tau, v)
+			}
+			mean += math.Pow(v, alpha)
+		}
+		scale := math.Pow(mean/float64(len(x.Data)), 1/alpha)
+		if scale == 0 {
+			break
+		}
+		for i := range x.Data {
+			x.Data[i] /= scale
+		}
+	}
+	for i, v := range x.Data {
+		x.Data[i] = 255 * (math.Tanh(v/tau) + 1) / 2
+	}
+	return x
+}
+
+// rescale maps linearly the values of img between 0 and 255.
+func rescale(img *algorithm.Matrix) *algorithm.Matrix {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, v := range img.Data {
+		low, high = math.Min(low, v), math.Max(high, v)
+	}
+	if high > low {
+		for i, v := range img.Data {
+			img.Data[i] = 255 * (v - low) / (high - low)
+		}
+	}
+	return img
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func clampInt(v, low, high int) int {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
+}
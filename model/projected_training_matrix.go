@@ -7,13 +7,16 @@ import (
 type ProjectedTrainingMatrix struct {
 	Matrix   *algorithm.Matrix
 	Label    string
+	Source   string // path of the training image, empty if unknown
 	Distance float64
 }
 
 func NewSliceProjectedTrainingMatrix(input []*ProjectedTrainingMatrix) []*ProjectedTrainingMatrix {
 	ptm := make([]*ProjectedTrainingMatrix, 0)
 	for _, v := range input {
-		ptm = append(ptm, NewProjectedTrainingMatrix(v.Matrix, v.Label))
+		p := NewProjectedTrainingMatrix(v.Matrix, v.Label)
+		p.Source = v.Source
+		ptm = append(ptm, p)
 	}
 	return ptm
 }
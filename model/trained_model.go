@@ -0,0 +1,224 @@
+package model
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/pkg/errors"
+)
+
+// TrainedModelVersion is the version of the on-disk format written by Trainer.Save.
+// It must be increased each time the layout of TrainedModel changes.
+// Version 1 stored the mean matrix and W of the linear feature extractions,
+// version 2 stores the state of any registered feature extractor.
+var TrainedModelVersion = 2
+
+type TrainedModel struct {
+	Version              int                       `json:"version"`
+	FeatureType          string                    `json:"feature_type"`
+	FeatureParameters    Parameters                `json:"feature_parameters,omitempty"`
+	Alignment            AlignmentPolicy           `json:"alignment"`               // geometric normalization of the images
+	Preprocessing        Preprocessing             `json:"preprocessing,omitempty"` // illumination normalization of the images
+	Metric               string                    `json:"metric"`
+	MetricState          json.RawMessage           `json:"metric_state,omitempty"` // state of a fitted metric
+	K                    int                       `json:"k"`
+	NumOfComponents      int                       `json:"num_of_components"`
+	Components           int                       `json:"components,omitempty"`         // number of components kept by the feature extraction
+	ExplainedVariance    []float64                 `json:"explained_variance,omitempty"` // ratio of the variance explained by the first components
+	Width                int                       `json:"width"`
+	Height               int                       `json:"height"`
+	LibraryFingerprint   string                    `json:"library_fingerprint"`
+	OpenSet              bool                      `json:"openset"`
+	RejectionThreshold   float64                   `json:"rejection_threshold"`
+	PerIdentityThreshold bool                      `json:"threshold_per_identity"`
+	Threshold            *RejectionThreshold       `json:"threshold,omitempty"`
+	Extractor            json.RawMessage           `json:"extractor,omitempty"`
+	MeanMatrix           *algorithm.Matrix         `json:"mean_matrix,omitempty"` // version 1 only
+	W                    *algorithm.Matrix         `json:"w,omitempty"`           // version 1 only
+	Projections          []*TrainedModelProjection `json:"projections"`
+}
+
+type TrainedModelProjection struct {
+	Label  string            `json:"label"`
+	Source string            `json:"source,omitempty"`
+	Matrix *algorithm.Matrix `json:"matrix"`
+}
+
+// Save writes the trained model to path, the training set itself is not stored.
+func (t *Trainer) Save(path string) error {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if !t.Trained() {
+		return errors.New("trainer is not trained")
+	}
+	if t.MetricName == "" {
+		return errors.New("trainer metric has no name and cannot be persisted")
+	}
+	extractor, err := t.Extractor.Save()
+	if err != nil {
+		return errors.Wrapf(err, "cannot save feature extractor %s", t.FeatureType)
+	}
+	var metricState json.RawMessage
+	if fm, ok := t.DistanceMetric.(FittedMetric); ok {
+		if metricState, err = fm.Save(); err != nil {
+			return errors.Wrapf(err, "cannot save metric %s", t.MetricName)
+		}
+	}
+	tm := &TrainedModel{
+		Version:              TrainedModelVersion,
+		FeatureType:          t.FeatureType,
+		FeatureParameters:    t.FeatureParameters,
+		Alignment:            t.Alignment,
+		Preprocessing:        t.Preprocessing,
+		Metric:               t.MetricName,
+		MetricState:          metricState,
+		K:                    t.K,
+		NumOfComponents:      t.NumOfComponents,
+		Width:                t.Width,
+		Height:               t.Height,
+		LibraryFingerprint:   t.LibraryFingerprint,
+		OpenSet:              t.OpenSet,
+		RejectionThreshold:   t.RejectionThreshold,
+		PerIdentityThreshold: t.PerIdentityThreshold,
+		Threshold:            t.Threshold,
+		Extractor:            extractor,
+		Projections:          make([]*TrainedModelProjection, 0, len(t.Model)),
+	}
+	tm.Components, tm.ExplainedVariance = t.explainedVariance()
+	for _, p := range t.Model {
+		tm.Projections = append(tm.Projections, &TrainedModelProjection{Label: p.Label, Source: p.Source, Matrix: p.Matrix})
+	}
+
+	// write in a temporary file first to never leave a truncated model behind
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create trained model file %s", tmpPath)
+	}
+	if err := json.NewEncoder(f).Encode(tm); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return errors.Wrapf(err, "cannot encode trained model file %s", tmpPath)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrapf(err, "cannot close trained model file %s", tmpPath)
+	}
+	return errors.Wrapf(os.Rename(tmpPath, path), "cannot move trained model to %s", path)
+}
+
+// LoadTrainer reads a trained model written by Trainer.Save.
+func LoadTrainer(path string) (*Trainer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open trained model file %s", path)
+	}
+	defer f.Close()
+	tm := &TrainedModel{}
+	if err := json.NewDecoder(f).Decode(tm); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode trained model file %s", path)
+	}
+	if tm.Version == 1 {
+		if err := tm.migrateVersion1(); err != nil {
+			return nil, errors.Wrapf(err, "trained model %s", path)
+		}
+	}
+	if tm.Version != TrainedModelVersion {
+		return nil, errors.Errorf("trained model %s has version %d, expected %d", path, tm.Version, TrainedModelVersion)
+	}
+	if tm.Threshold != nil && (tm.Threshold.FeatureType != tm.FeatureType || tm.Threshold.Metric != tm.Metric) {
+		return nil, errors.Errorf("trained model %s threshold was calibrated for %s/%s and not %s/%s", path,
+			tm.Threshold.FeatureType, tm.Threshold.Metric, tm.FeatureType, tm.Metric)
+	}
+	metric, err := NewMetric(tm.Metric)
+	if err != nil {
+		return nil, errors.Wrapf(err, "trained model %s", path)
+	}
+	extractor, err := NewFeatureExtractor(tm.FeatureType, tm.FeatureParameters)
+	if err != nil {
+		return nil, errors.Wrapf(err, "trained model %s", path)
+	}
+	preprocessing, err := tm.Preprocessing.Normalize()
+	if err != nil {
+		return nil, errors.Wrapf(err, "trained model %s", path)
+	}
+	if err := extractor.Load(tm.Extractor); err != nil {
+		return nil, errors.Wrapf(err, "trained model %s", path)
+	}
+
+	t := NewTrainerArgs(tm.FeatureType, tm.K, tm.NumOfComponents, metric.GetDistance)
+	t.DistanceMetric = metric
+	t.FeatureParameters = tm.FeatureParameters
+	t.Alignment = tm.Alignment
+	t.Preprocessing = preprocessing
+	t.MetricName = tm.Metric
+	t.Width = tm.Width
+	t.Height = tm.Height
+	t.LibraryFingerprint = tm.LibraryFingerprint
+	t.OpenSet = tm.OpenSet
+	t.RejectionThreshold = tm.RejectionThreshold
+	t.PerIdentityThreshold = tm.PerIdentityThreshold
+	t.Threshold = tm.Threshold
+	t.setExtractor(extractor)
+	dimension := -1
+	if t.FeatureExtraction.W != nil {
+		dimension = t.FeatureExtraction.W.N
+	}
+	for _, p := range tm.Projections {
+		if dimension < 0 && p.Matrix != nil {
+			dimension = p.Matrix.M
+		}
+		if p.Matrix == nil || p.Matrix.M != dimension {
+			return nil, errors.Errorf("trained model %s has a projection of label %s with a wrong dimension", path, p.Label)
+		}
+		ptm := NewProjectedTrainingMatrix(p.Matrix, p.Label)
+		ptm.Source = p.Source
+		t.Model = append(t.Model, ptm)
+		t.FeatureExtraction.Labels = append(t.FeatureExtraction.Labels, p.Label)
+	}
+	t.FeatureExtraction.ProjectedTrainingSet = t.Model
+	if fm, ok := metric.(FittedMetric); ok {
+		if len(tm.MetricState) == 0 {
+			// model saved before the metric was fitted
+			t.fitMetric()
+		} else if err := fm.Load(tm.MetricState); err != nil {
+			return nil, errors.Wrapf(err, "trained model %s", path)
+		}
+	}
+	if t.Threshold == nil {
+		// model saved before the threshold was calibrated in closed set
+		t.UpdateThreshold()
+	}
+	return t, nil
+}
+
+// migrateVersion1 moves the mean matrix and W of a version 1 model in the
+// state of its linear feature extractor.
+func (tm *TrainedModel) migrateVersion1() error {
+	if tm.W == nil || tm.MeanMatrix == nil || tm.W.M != tm.MeanMatrix.M {
+		return errors.New("version 1 model has inconsistent projection matrices")
+	}
+	extractor, err := json.Marshal(&linearExtraction{NumOfComponents: tm.NumOfComponents, MeanMatrix: tm.MeanMatrix, W: tm.W})
+	if err != nil {
+		return err
+	}
+	tm.Extractor = extractor
+	tm.MeanMatrix = nil
+	tm.W = nil
+	tm.Version = 2
+	return nil
+}
+
+// CheckLibrary returns an error if the trainer was not built from the current
+// content of the library fl.
+func (t *Trainer) CheckLibrary(fl *FaceRecognitionLib) error {
+	fingerprint := fl.Fingerprint()
+	if t.LibraryFingerprint != fingerprint {
+		return errors.Errorf("trained model fingerprint %s does not match library fingerprint %s", t.LibraryFingerprint, fingerprint)
+	}
+	if t.Width != fl.Width || t.Height != fl.Height {
+		return errors.Errorf("trained model image size %dx%d does not match library image size %dx%d", t.Width, t.Height, fl.Width, fl.Height)
+	}
+	return nil
+}
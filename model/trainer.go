@@ -1,8 +1,12 @@
 package model
 
 import (
+	"strconv"
+	"sync"
+
 	"github.com/jeromelesaux/facerecognition/algorithm"
 	"github.com/jeromelesaux/facerecognition/logger"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -12,20 +16,41 @@ var (
 )
 
 type Trainer struct {
-	Metric            func(a, b *algorithm.Matrix) float64
-	FeatureType       string
-	FeatureExtraction *FeatureExtraction
-	NumOfComponents   int
-	K                 int
-	TrainingSet       []*algorithm.Matrix
-	TrainingLabels    []string
-	Model             []*ProjectedTrainingMatrix
+	Metric               func(a, b *algorithm.Matrix) float64 // distance function, the one of DistanceMetric if set
+	MetricName           string
+	DistanceMetric       Metric          // registered metric MetricName
+	FeatureType          string          // name of the registered feature extractor
+	FeatureParameters    Parameters      // parameters of the feature extractor
+	Alignment            AlignmentPolicy // geometric normalization of the images before the preprocessing
+	Preprocessing        Preprocessing   // illumination normalization of the images before the feature extraction
+	Extractor            FeatureExtractor
+	FeatureExtraction    *FeatureExtraction // state of a linear feature extractor
+	NumOfComponents      int
+	K                    int
+	Width                int
+	Height               int
+	LibraryFingerprint   string
+	OpenSet              bool
+	RejectionThreshold   float64 // fixed rejection threshold, calibrated while training if 0
+	PerIdentityThreshold bool
+	Threshold            *RejectionThreshold
+	TrainingSet          []*algorithm.Matrix
+	TrainingLabels       []string
+	TrainingSources      []string
+	Model                []*ProjectedTrainingMatrix
+	RetrainPolicy        RetrainPolicy
+	AfterRetrain         func(t *Trainer) // called after a background retraining
+	enrollment           enrollment
+	lock                 sync.RWMutex
+	metricTrainers       map[string]*Trainer // trainers returned by WithMetric, until the model changes
+	metricTrainersLock   sync.Mutex
 }
 
 func NewTrainer() *Trainer {
 	t := &Trainer{}
 	t.TrainingSet = make([]*algorithm.Matrix, 0)
 	t.TrainingLabels = make([]string, 0)
+	t.TrainingSources = make([]string, 0)
 	t.Model = make([]*ProjectedTrainingMatrix, 0)
 	t.FeatureExtraction = NewFeatureExtraction()
 	return t
@@ -41,35 +66,350 @@ func NewTrainerArgs(featureType string, k int, numOfComponents int, distanceFunc
 }
 
 func (t *Trainer) Add(m *algorithm.Matrix, label string) {
+	t.AddWithSource(m, label, "")
+}
+
+// AddWithSource adds the training image m of label read from the file source.
+func (t *Trainer) AddWithSource(m *algorithm.Matrix, label string, source string) {
 	t.TrainingSet = append(t.TrainingSet, m)
 	t.TrainingLabels = append(t.TrainingLabels, label)
+	t.TrainingSources = append(t.TrainingSources, source)
+}
+
+// Train computes the feature extraction from the training set, it must not be
+// called while the trainer is used for recognition, use Enroll instead.
+func (t *Trainer) Train() error {
+	t.forgetMetricTrainers()
+	t.Extractor = nil
+	t.FeatureExtraction = NewFeatureExtraction()
+	t.Model = make([]*ProjectedTrainingMatrix, 0)
+	if err := checkTrainingSet(t.TrainingSet, t.TrainingLabels); err != nil {
+		return errors.Wrap(err, "cannot train")
+	}
+	preprocessing, err := t.Preprocessing.Normalize()
+	if err != nil {
+		return errors.Wrap(err, "cannot train")
+	}
+	t.Preprocessing = preprocessing
+	if err := t.Alignment.check(); err != nil {
+		return errors.Wrap(err, "cannot train")
+	}
+	// the training set keeps the images as read, a retraining prepares them again
+	trainingSet := t.TrainingSet
+	if len(preprocessing) > 0 || t.Alignment.Align || t.Alignment.Mask {
+		trainingSet = make([]*algorithm.Matrix, len(t.TrainingSet))
+		notAligned := 0
+		for i, m := range t.TrainingSet {
+			if trainingSet[i], err = t.prepare(m); errors.Is(err, ErrEyesNotFound) {
+				notAligned++
+			} else if err != nil {
+				return errors.Wrap(err, "cannot train")
+			}
+		}
+		if notAligned > 0 {
+			logger.Logf("eyes not found in %d of %d training images, they are not aligned", notAligned, len(trainingSet))
+		}
+	}
+	extractor, err := NewFeatureExtractor(t.FeatureType, t.featureParameters())
+	if err != nil {
+		return errors.Wrap(err, "cannot create the feature extractor")
+	}
+	if ie, ok := extractor.(ImageFeatureExtractor); ok && t.Width > 0 && t.Height > 0 {
+		ie.SetImageSize(t.Width, t.Height)
+	}
+	if err := extractor.Fit(trainingSet, t.TrainingLabels); err != nil {
+		return errors.Wrapf(err, "cannot fit the feature extractor %s", t.FeatureType)
+	}
+	t.setExtractor(extractor)
+	if components, explained := t.explainedVariance(); len(explained) > 0 && components > 0 {
+		logger.Logf("%s keeps %d components of %d explaining %.2f%% of the variance", t.FeatureType, components, len(explained), 100*explained[components-1])
+	}
+	for i := range trainingSet {
+		ptm := NewProjectedTrainingMatrix(extractor.Project(trainingSet[i]), t.TrainingLabels[i])
+		if i < len(t.TrainingSources) {
+			ptm.Source = t.TrainingSources[i]
+		}
+		t.Model = append(t.Model, ptm)
+	}
+	t.FeatureExtraction.ProjectedTrainingSet = t.Model
+	// a background retraining still running keeps its channel
+	t.enrollment = enrollment{done: t.enrollment.done}
+	t.DistanceMetric = nil
+	t.fitMetric()
+	t.UpdateThreshold()
+	return nil
+}
+
+// SetMetric sets the registered metric name used to compare the projections,
+// it is fitted on the model if the trainer is trained.
+func (t *Trainer) SetMetric(name string) error {
+	m, err := NewMetric(name)
+	if err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.forgetMetricTrainers()
+	t.MetricName = name
+	t.DistanceMetric = m
+	t.fitMetric()
+	return nil
+}
+
+// fitMetric creates the metric MetricName if needed and fits it on the model.
+func (t *Trainer) fitMetric() {
+	if t.DistanceMetric == nil {
+		if t.MetricName == "" && t.Metric == nil {
+			t.MetricName = DefaultMetric(t.FeatureType)
+		}
+		if t.MetricName == "" {
+			return
+		}
+		m, err := NewMetric(t.MetricName)
+		if err != nil {
+			logger.Logf("cannot create the metric : %v", err)
+			return
+		}
+		t.DistanceMetric = m
+	}
+	if fm, ok := t.DistanceMetric.(FittedMetric); ok && len(t.Model) > 0 {
+		projections := make([]*algorithm.Matrix, len(t.Model))
+		labels := make([]string, len(t.Model))
+		for i, p := range t.Model {
+			projections[i] = p.Matrix
+			labels[i] = p.Label
+		}
+		if err := fm.Fit(projections, labels); err != nil {
+			logger.Logf("cannot fit the metric %s : %v", t.MetricName, err)
+		}
+	}
+	if em, ok := t.DistanceMetric.(EigenvalueMetric); ok && len(t.Model) > 0 {
+		if ve, ok := t.Extractor.(VarianceFeatureExtractor); ok && len(ve.ComponentVariances()) == t.Model[0].Matrix.M {
+			em.SetEigenvalues(ve.ComponentVariances())
+		}
+	}
+	t.Metric = t.DistanceMetric.GetDistance
+}
+
+// WithMetric returns a trainer sharing the feature extraction and the model of
+// t which compares the projections with the metric name, its rejection
+// threshold is calibrated for this metric. The trainer is built once per
+// metric until t is trained again, retrained or enrolls an image, it must not
+// be modified.
+func (t *Trainer) WithMetric(name string) (*Trainer, error) {
+	m, err := NewMetric(name)
+	if err != nil {
+		return nil, err
+	}
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if !t.Trained() {
+		return nil, errors.New("trainer is not trained")
+	}
+	// the model cannot change while the read lock is held, the trainers are
+	// forgotten under the write lock
+	t.metricTrainersLock.Lock()
+	defer t.metricTrainersLock.Unlock()
+	if wt, ok := t.metricTrainers[name]; ok {
+		return wt, nil
+	}
+	wt := NewTrainerArgs(t.FeatureType, t.K, t.NumOfComponents, nil)
+	wt.FeatureParameters = t.FeatureParameters
+	wt.Alignment = t.Alignment
+	wt.Preprocessing = t.Preprocessing
+	wt.Width = t.Width
+	wt.Height = t.Height
+	wt.LibraryFingerprint = t.LibraryFingerprint
+	wt.OpenSet = t.OpenSet
+	wt.PerIdentityThreshold = t.PerIdentityThreshold
+	wt.setExtractor(t.Extractor)
+	wt.Model = append(wt.Model, t.Model...)
+	wt.MetricName = name
+	wt.DistanceMetric = m
+	wt.fitMetric()
+	if t.MetricName == name {
+		wt.RejectionThreshold = t.RejectionThreshold
+	}
+	wt.UpdateThreshold()
+	if t.metricTrainers == nil {
+		t.metricTrainers = make(map[string]*Trainer)
+	}
+	t.metricTrainers[name] = wt
+	return wt, nil
+}
+
+// forgetMetricTrainers drops the trainers built by WithMetric, the model of t
+// changed.
+func (t *Trainer) forgetMetricTrainers() {
+	t.metricTrainersLock.Lock()
+	t.metricTrainers = nil
+	t.metricTrainersLock.Unlock()
+}
+
+// featureParameters returns the parameters of the feature extractor, the
+// number of components of the trainer is used if the extractor has a
+// components parameter not set.
+func (t *Trainer) featureParameters() Parameters {
+	p := t.FeatureParameters.Copy()
+	if _, ok := p["components"]; ok || t.NumOfComponents <= 0 {
+		return p
+	}
+	if r, err := GetFeatureExtractorRegistration(t.FeatureType); err == nil && r.parameter("components") != nil {
+		p["components"] = strconv.Itoa(t.NumOfComponents)
+	}
+	return p
 }
 
-func (t *Trainer) Train() {
-	if t.NumOfComponents == 0 {
-		logger.Log("No components to compute. Exit")
+func (t *Trainer) setExtractor(extractor FeatureExtractor) {
+	t.Extractor = extractor
+	if linear, ok := extractor.(LinearFeatureExtractor); ok {
+		t.FeatureExtraction = linear.Extraction()
+	} else {
+		t.FeatureExtraction = NewFeatureExtraction()
+	}
+}
+
+// ExplainedVariance returns the number of components kept by the feature
+// extraction and the ratio of the variance explained by the first 1, 2, ...
+// candidate components, nil if the feature extractor does not know it.
+func (t *Trainer) ExplainedVariance() (int, []float64) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.explainedVariance()
+}
+
+func (t *Trainer) explainedVariance() (int, []float64) {
+	se, ok := t.Extractor.(SpectrumFeatureExtractor)
+	if !ok || len(se.Spectrum()) == 0 {
+		return 0, nil
+	}
+	components := 0
+	if t.FeatureExtraction.W != nil {
+		components = t.FeatureExtraction.W.N
+	}
+	return components, ExplainedVariance(se.Spectrum())
+}
+
+// incremental returns true if the feature extractor never needs a retraining.
+func (t *Trainer) incremental() bool {
+	ie, ok := t.Extractor.(IncrementalFeatureExtractor)
+	return ok && ie.Incremental()
+}
+
+// Trained returns true if the feature extractor of the trainer is fitted.
+func (t *Trainer) Trained() bool {
+	return t.Extractor != nil
+}
+
+// UpdateThreshold sets the rejection threshold used by the open set
+// recognition and the verification, it is calibrated from the model if
+// RejectionThreshold is 0.
+func (t *Trainer) UpdateThreshold() {
+	t.forgetMetricTrainers()
+	t.Threshold = nil
+	if t.RejectionThreshold > 0 {
+		t.Threshold = &RejectionThreshold{FeatureType: t.FeatureType, Metric: t.MetricName, Global: t.RejectionThreshold}
 		return
 	}
-	switch t.FeatureType {
-	case PCAFeatureType:
-		p := NewPCA(t.TrainingSet, t.TrainingLabels, t.NumOfComponents)
-		t.FeatureExtraction = p.FeatureExtraction
+	if err := t.Calibrate(); err != nil {
+		logger.Logf("cannot calibrate the rejection threshold, recognition stays closed set and verification fails : %v", err)
+		return
+	}
+	logger.Logf("rejection threshold calibrated to %f", t.Threshold.Global)
+}
+
+// Project returns the projection of matrix in the feature space of the trainer.
+func (t *Trainer) Project(matrix *algorithm.Matrix) *algorithm.Matrix {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.project(matrix)
+}
 
-	case LDAFeatureType:
-		l := NewLDA(t.TrainingSet, t.TrainingLabels, t.NumOfComponents)
-		t.FeatureExtraction = l.FeatureExtraction
+func (t *Trainer) project(matrix *algorithm.Matrix) *algorithm.Matrix {
+	return t.Extractor.Project(t.preprocess(matrix))
+}
 
-	case LPPFeatureType:
-		l := NewLPP(t.TrainingSet, t.TrainingLabels, t.NumOfComponents)
-		t.FeatureExtraction = l.FeatureExtraction
+// preprocess returns matrix aligned and normalized by the preprocessing chain
+// of the trainer, matrix itself if there is none. The chain was normalized
+// when the trainer was trained or loaded, an error is only logged.
+func (t *Trainer) preprocess(matrix *algorithm.Matrix) *algorithm.Matrix {
+	prepared, err := t.prepare(matrix)
+	if errors.Is(err, ErrEyesNotFound) {
+		logger.Logf("image not aligned : %v", err)
+		return prepared
+	}
+	if err != nil {
+		logger.Logf("cannot preprocess the image : %v", err)
+		return matrix
+	}
+	return prepared
+}
 
+// prepare returns matrix aligned by the alignment policy and normalized by the
+// preprocessing chain of the trainer. If the eyes are not found the error is
+// ErrEyesNotFound and the matrix is returned not aligned.
+func (t *Trainer) prepare(matrix *algorithm.Matrix) (*algorithm.Matrix, error) {
+	aligned, alignErr := t.Alignment.Apply(matrix, t.Width, t.Height)
+	if alignErr != nil && !errors.Is(alignErr, ErrEyesNotFound) {
+		return nil, alignErr
+	}
+	preprocessed, err := t.Preprocessing.Apply(aligned, t.Width, t.Height)
+	if err != nil {
+		return nil, err
+	}
+	return preprocessed, alignErr
+}
+
+// inputDimension returns the dimension of the images the trainer projects, 0
+// if unknown.
+func (t *Trainer) inputDimension() int {
+	if t.FeatureExtraction != nil && t.FeatureExtraction.W != nil && t.FeatureExtraction.W.M > 0 {
+		return t.FeatureExtraction.W.M
+	}
+	if len(t.TrainingSet) > 0 {
+		return t.TrainingSet[0].M
 	}
+	return t.Width * t.Height
+}
 
-	t.Model = t.FeatureExtraction.ProjectedTrainingSet
+// checkProbe returns ErrNotTrained or ErrDimensionMismatch if matrix cannot be
+// projected by the trainer.
+func (t *Trainer) checkProbe(matrix *algorithm.Matrix) error {
+	if !t.Trained() {
+		return ErrNotTrained
+	}
+	if dimension := t.inputDimension(); dimension > 0 && (matrix.M != dimension || matrix.N != 1) {
+		return errors.Wrapf(ErrDimensionMismatch, "image is %dx%d for a trainer of dimension %d", matrix.M, matrix.N, dimension)
+	}
+	return nil
 }
 
+// Recognize returns the label and the score of Identify, an error is only
+// logged.
 func (t *Trainer) Recognize(matrix *algorithm.Matrix) (string, float64) {
-	testCase := t.FeatureExtraction.W.Transpose().TimesMatrix(matrix.Minus(t.FeatureExtraction.MeanMatrix))
-	result, similarity := AssignLabel(t.Model, testCase, t.K, t.Metric)
-	return result, similarity
+	label, similarity, err := t.Identify(matrix)
+	if err != nil {
+		logger.Logf("cannot recognize : %v", err)
+	}
+	return label, similarity
+}
+
+// Identify returns the label of the person nearest to matrix among the K
+// nearest training images and its similarity, see Classify. In open set the
+// label is empty if the person is unknown and the score is the confidence of
+// the recognition, see RecognizeOpenSet.
+func (t *Trainer) Identify(matrix *algorithm.Matrix) (string, float64, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if err := t.checkProbe(matrix); err != nil {
+		return "", 0, err
+	}
+	r, similarity, err := t.recognize(t.project(matrix))
+	if err != nil {
+		return "", 0, err
+	}
+	if t.openSet() {
+		return r.Label, r.Confidence, nil
+	}
+	return r.Label, similarity, nil
 }
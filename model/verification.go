@@ -0,0 +1,55 @@
+package model
+
+import (
+	"math"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownIdentity = errors.New("unknown identity")
+	ErrNoThreshold     = errors.New("no rejection threshold")
+)
+
+// Verification is the result of a 1:1 comparison between a probe and a person.
+type Verification struct {
+	Identity  string  `json:"identity"`
+	Accepted  bool    `json:"accepted"`
+	Distance  float64 `json:"distance"`
+	Threshold float64 `json:"threshold"`
+	Score     float64 `json:"score"`
+}
+
+// Verify checks if matrix is the person identity (the key First.Last of the
+// library) : the probe is only compared to the training images of this person
+// and accepted if the nearest one is within the rejection threshold.
+// The threshold is the one calibrated or set when the trainer was trained or
+// loaded, see UpdateThreshold.
+func Verify(t *Trainer, matrix *algorithm.Matrix, identity string) (*Verification, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if err := t.checkProbe(matrix); err != nil {
+		return nil, errors.Wrapf(err, "cannot verify %s", identity)
+	}
+	testCase := t.project(matrix)
+	v := &Verification{Identity: identity, Distance: math.MaxFloat64}
+	found := false
+	for _, p := range t.Model {
+		if p.Label == identity {
+			found = true
+			v.Distance = math.Min(v.Distance, t.Metric(p.Matrix, testCase))
+		}
+	}
+	if !found {
+		return nil, errors.Wrapf(ErrUnknownIdentity, "cannot verify %s", identity)
+	}
+
+	if t.Threshold == nil {
+		return nil, errors.Wrapf(ErrNoThreshold, "cannot verify %s", identity)
+	}
+	v.Threshold = t.Threshold.Get(identity)
+	v.Score = confidence(v.Distance, v.Threshold)
+	v.Accepted = v.Distance <= v.Threshold
+	return v, nil
+}
@@ -0,0 +1,305 @@
+package testFacerecognition
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+// eyeCascade is a Haar cascade of a single stump in the old OpenCV format,
+// detecting a dark spot in the center of a window of 20x20.
+var eyeCascade = `<?xml version="1.0"?>
+<opencv_storage>
+<dark_spot type_id="opencv-haar-classifier">
+  <size>20 20</size>
+  <stages>
+    <_>
+      <trees>
+        <_>
+          <_>
+            <feature>
+              <rects>
+                <_>0 0 20 20 -1.</_>
+                <_>5 5 10 10 4.</_></rects>
+              <tilted>0</tilted></feature>
+            <threshold>-0.05</threshold>
+            <left_val>1.</left_val>
+            <right_val>-1.</right_val></_></_></trees>
+      <stage_threshold>0.</stage_threshold>
+      <parent>-1</parent>
+      <next>-1</next></_></stages></dark_spot>
+</opencv_storage>
+`
+
+// faceEyeCascade is a Haar cascade of three stumps detecting on the faces of
+// the test data an eye, a dark spot darker than the skin at its left and
+// right and than the cheek below.
+var faceEyeCascade = `<?xml version="1.0"?>
+<opencv_storage>
+<eye type_id="opencv-haar-classifier">
+  <size>20 20</size>
+  <stages>
+    <_>
+      <trees>
+        <_>
+          <_>
+            <feature>
+              <rects>
+                <_>0 0 20 20 -1.</_>
+                <_>5 5 10 10 4.</_></rects>
+              <tilted>0</tilted></feature>
+            <threshold>-0.05</threshold>
+            <left_val>1.</left_val>
+            <right_val>-1.</right_val></_></_></trees>
+      <stage_threshold>0.</stage_threshold>
+      <parent>-1</parent>
+      <next>1</next></_>
+    <_>
+      <trees>
+        <_>
+          <_>
+            <feature>
+              <rects>
+                <_>0 6 20 8 -1.</_>
+                <_>6 6 8 8 2.</_></rects>
+              <tilted>0</tilted></feature>
+            <threshold>-0.05</threshold>
+            <left_val>1.</left_val>
+            <right_val>-1.</right_val></_></_></trees>
+      <stage_threshold>0.</stage_threshold>
+      <parent>0</parent>
+      <next>2</next></_>
+    <_>
+      <trees>
+        <_>
+          <_>
+            <feature>
+              <rects>
+                <_>4 6 12 14 -1.</_>
+                <_>4 6 12 7 2.</_></rects>
+              <tilted>0</tilted></feature>
+            <threshold>-0.05</threshold>
+            <left_val>1.</left_val>
+            <right_val>-1.</right_val></_></_></trees>
+      <stage_threshold>0.</stage_threshold>
+      <parent>1</parent>
+      <next>-1</next></_></stages></eye>
+</opencv_storage>
+`
+
+// writeCascade writes the cascade in a temporary file and returns its path.
+func writeCascade(t *testing.T, cascade string) string {
+	path := filepath.Join(t.TempDir(), "eye_cascade.xml")
+	if err := os.WriteFile(path, []byte(cascade), 0644); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	return path
+}
+
+// writeEyeCascade writes eyeCascade in a temporary file and returns its path.
+func writeEyeCascade(t *testing.T) string {
+	return writeCascade(t, eyeCascade)
+}
+
+func loadEyeDetector(t *testing.T) *model.EyeDetector {
+	detector, err := model.LoadEyeDetector(writeEyeCascade(t))
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	return detector
+}
+
+// syntheticFace returns a light face of 100x120 pixels with two dark eyes at
+// left and right.
+func syntheticFace(left, right image.Point) *image.Gray {
+	face := image.NewGray(image.Rect(0, 0, 100, 120))
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 100; x++ {
+			value := uint8(180)
+			for _, eye := range []image.Point{left, right} {
+				if (x-eye.X)*(x-eye.X)+(y-eye.Y)*(y-eye.Y) <= 16 {
+					value = 20
+				}
+			}
+			face.SetGray(x, y, color.Gray{value})
+		}
+	}
+	return face
+}
+
+func near(a, b image.Point, tolerance int) bool {
+	return math.Abs(float64(a.X-b.X)) <= float64(tolerance) && math.Abs(float64(a.Y-b.Y)) <= float64(tolerance)
+}
+
+func TestLocateEyes(t *testing.T) {
+	detector := loadEyeDetector(t)
+	if detector.Width != 20 || detector.Height != 20 || len(detector.Stages) != 1 {
+		t.Fatalf("expected a cascade of one stage on 20x20 and gets %dx%d %d", detector.Width, detector.Height, len(detector.Stages))
+	}
+	left, right, err := model.LocateEyes(syntheticFace(image.Pt(32, 45), image.Pt(68, 45)), detector)
+	if err != nil || !near(left, image.Pt(32, 45), 2) || !near(right, image.Pt(68, 45), 2) {
+		t.Fatalf("expected the eyes at (32,45) and (68,45) and gets %v %v %v", left, right, err)
+	}
+	if _, _, err := model.LocateEyes(syntheticFace(image.Pt(-10, -10), image.Pt(-10, -10)), detector); !errors.Is(err, model.ErrEyesNotFound) {
+		t.Fatalf("expected no eyes in a uniform face and gets %v", err)
+	}
+	if _, _, err := model.LocateEyes(syntheticFace(image.Pt(40, 30), image.Pt(60, 50)), detector); !errors.Is(err, model.ErrEyesNotFound) {
+		t.Fatalf("expected no eyes too close and too tilted and gets %v", err)
+	}
+
+	invalid := filepath.Join(t.TempDir(), "invalid.xml")
+	if err := os.WriteFile(invalid, []byte("<opencv_storage><eyes><size>20 20</size></eyes></opencv_storage>"), 0644); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if _, err := model.LoadEyeDetector(invalid); err == nil {
+		t.Fatal("expected an error for a cascade without stages")
+	}
+	tilted := strings.Replace(eyeCascade, "<tilted>0</tilted>", "<tilted>1</tilted>", 1)
+	if _, err := model.LoadEyeDetector(writeCascade(t, tilted)); err == nil {
+		t.Fatal("expected an error for a cascade of tilted features")
+	}
+}
+
+// faceEyes are the eyes of faces of the test data, checked by hand.
+var faceEyes = []struct {
+	path        string
+	left, right image.Point
+}{
+	{"Data/Barrack.Obama/face_Barrack.Obama-10A0906C597555365D380FFD1CB3B0A5_395_6_122_1222.pgm", image.Pt(31, 34), image.Pt(64, 33)},
+	{"faces/s1/1.pgm", image.Pt(29, 51), image.Pt(60, 51)},
+	{"faces/s12/1.pgm", image.Pt(31, 52), image.Pt(62, 52)},
+	{"faces/s32/1.pgm", image.Pt(37, 47), image.Pt(69, 47)},
+}
+
+// locateFaceEyes checks the eyes found by the detector in faceEyes.
+func locateFaceEyes(t *testing.T, detector *model.EyeDetector, tolerance int) {
+	for _, face := range faceEyes {
+		left, right, err := model.LocateEyes(model.ToImage(model.ToMatrix(face.path)), detector)
+		if err != nil || !near(left, face.left, tolerance) || !near(right, face.right, tolerance) {
+			t.Fatalf("expected the eyes of %s at %v and %v and gets %v %v %v", face.path, face.left, face.right, left, right, err)
+		}
+	}
+}
+
+func TestLocateEyesOfFaces(t *testing.T) {
+	detector, err := model.LoadEyeDetector(writeCascade(t, faceEyeCascade))
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if len(detector.Stages) != 3 {
+		t.Fatalf("expected a cascade of 3 stages and gets %d", len(detector.Stages))
+	}
+	locateFaceEyes(t, detector, 3)
+}
+
+// TestLocateEyesOpenCV runs the cascade of the eyes of OpenCV 2.4 when it is
+// copied in this directory from
+// https://github.com/opencv/opencv/blob/2.4/data/haarcascades/haarcascade_eye.xml
+func TestLocateEyesOpenCV(t *testing.T) {
+	if _, err := os.Stat("haarcascade_eye.xml"); err != nil {
+		t.Skip("haarcascade_eye.xml of OpenCV 2.4 not found")
+	}
+	detector, err := model.LoadEyeDetector("haarcascade_eye.xml")
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	locateFaceEyes(t, detector, 5)
+}
+
+func TestAlignFace(t *testing.T) {
+	detector := loadEyeDetector(t)
+	// eyes tilted and too close
+	face := syntheticFace(image.Pt(36, 52), image.Pt(62, 40))
+	aligned, err := model.AlignFace(face, detector)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if aligned.Bounds() != face.Bounds() {
+		t.Fatalf("expected an aligned face of %v and gets %v", face.Bounds(), aligned.Bounds())
+	}
+	left, right, err := model.LocateEyes(aligned, detector)
+	if err != nil || !near(left, image.Pt(30, 46), 3) || !near(right, image.Pt(70, 46), 3) {
+		t.Fatalf("expected the aligned eyes at (30,46) and (70,46) and gets %v %v %v", left, right, err)
+	}
+	if _, err := model.AlignFace(syntheticFace(image.Pt(-10, -10), image.Pt(-10, -10)), detector); !errors.Is(err, model.ErrEyesNotFound) {
+		t.Fatalf("expected the failure of the alignment reported and gets %v", err)
+	}
+
+	masked := model.MaskFace(aligned)
+	if masked.GrayAt(0, 0).Y != 0 || masked.GrayAt(99, 119).Y != 0 {
+		t.Fatal("expected the corners of the face masked")
+	}
+	if masked.GrayAt(50, 60) != aligned.GrayAt(50, 60) || aligned.GrayAt(0, 0).Y == 0 {
+		t.Fatal("expected the center of the face kept and the aligned face unchanged")
+	}
+}
+
+func TestTrainerAlignment(t *testing.T) {
+	// the identities differ by the gray level of their skin, their eyes are
+	// straight or tilted
+	identity := func(person int, left, right image.Point) *image.Gray {
+		face := syntheticFace(left, right)
+		for i, value := range face.Pix {
+			if value == 180 {
+				face.Pix[i] = uint8(140 + 40*person)
+			}
+		}
+		return face
+	}
+	policy := model.AlignmentPolicy{Align: true, Mask: true, EyeCascade: writeEyeCascade(t)}
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 0, nil)
+	trainer.MetricName = model.L1Metric
+	trainer.Width, trainer.Height = 100, 120
+	trainer.Alignment = policy
+	labels := []string{"a", "b", "c"}
+	for person, label := range labels {
+		trainer.Add(model.GrayMatrix(identity(person, image.Pt(30, 46), image.Pt(70, 46))).Vectorize(), label)
+		trainer.Add(model.GrayMatrix(identity(person, image.Pt(34, 50), image.Pt(66, 42))).Vectorize(), label)
+	}
+	if err := trainer.Train(); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "trained_model.json")
+	if err := trainer.Save(path); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	loaded, err := model.LoadTrainer(path)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if loaded.Alignment != policy {
+		t.Fatalf("expected the alignment %+v and gets %+v", policy, loaded.Alignment)
+	}
+
+	// the probes are aligned as the training images
+	probe := model.GrayMatrix(identity(1, image.Pt(36, 40), image.Pt(64, 52))).Vectorize()
+	aligned, err := policy.Apply(probe, 100, 120)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	sameMatrix(t, "aligned projection", loaded.Extractor.Project(aligned), loaded.Project(probe))
+	sameMatrix(t, "projection", trainer.Project(probe), loaded.Project(probe))
+	if label, _, err := loaded.Identify(probe); err != nil || label != "b" {
+		t.Fatalf("expected the tilted probe recognized as b and gets %s, %v", label, err)
+	}
+
+	// a face without eyes is masked only
+	uniform := model.GrayMatrix(syntheticFace(image.Pt(-10, -10), image.Pt(-10, -10))).Vectorize()
+	masked, err := policy.Apply(uniform, 100, 120)
+	if !errors.Is(err, model.ErrEyesNotFound) || masked == nil || masked.Data[0] != 0 || masked.Data[50*120+60] != 180 {
+		t.Fatalf("expected the face without eyes masked and reported and gets %v", err)
+	}
+
+	trainer.Alignment.EyeCascade = filepath.Join(t.TempDir(), "missing.xml")
+	if err := trainer.Train(); err == nil {
+		t.Fatal("expected an error without the eye cascade")
+	}
+}
@@ -1,7 +1,6 @@
 package testFacerecognition
 
 import (
-	"fmt"
 	"github.com/jeromelesaux/facerecognition/logger"
 	"github.com/jeromelesaux/facerecognition/model"
 	"image"
@@ -10,17 +9,6 @@ import (
 	"testing"
 )
 
-func init() {
-	model.SetAndLoad("../config.json")
-	fl := model.NewFaceRecognitionLib()
-	for i := 1; i < 41; i++ {
-		key := fmt.Sprintf("s%d.train", i)
-		firstname := fmt.Sprintf("s%d", i)
-		fl.Items[key] = &model.FaceRecognitionItem{User: model.User{FirstName: firstname, LastName: "train"}}
-	}
-	fl.Save()
-}
-
 func TestBarrackObamaDetection(t *testing.T) {
 	userslib := model.GetFaceRecognitionLib()
 	f, err := os.Open("images/barack.png")
@@ -0,0 +1,56 @@
+package testFacerecognition
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestRecognizeTopN(t *testing.T) {
+	m := &model.L1{}
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 3, 10, m.GetDistance)
+	for _, person := range []string{"s1", "s2", "s3", "s4", "s5"} {
+		for i := 1; i <= 5; i++ {
+			path := fmt.Sprintf("faces/%s/%d.pgm", person, i)
+			trainer.AddWithSource(model.ToMatrix(path).Vectorize(), person, path)
+		}
+	}
+	trainer.Train()
+
+	probe := model.ToMatrix("faces/s2/6.pgm").Vectorize()
+	label, _ := trainer.Recognize(probe)
+	candidates := trainer.RecognizeTopN(probe, 3)
+	if len(candidates) != 3 {
+		t.Fatalf("expected 3 candidates and gets %d", len(candidates))
+	}
+	if candidates[0].Label != label || label != "s2" {
+		t.Fatalf("expected s2 as first candidate and gets %s (recognize gives %s)", candidates[0].Label, label)
+	}
+	votes := 0
+	for i, c := range candidates {
+		votes += c.Votes
+		if i > 0 && c.Score > candidates[i-1].Score {
+			t.Fatalf("expected candidates ranked by score and gets %+v before %+v", candidates[i-1], c)
+		}
+		if len(c.TrainingImages) == 0 {
+			t.Fatalf("expected training images for candidate %+v", c)
+		}
+	}
+	if votes != trainer.K {
+		t.Fatalf("expected %d votes and gets %d", trainer.K, votes)
+	}
+
+	all := trainer.RecognizeTopN(probe, 0)
+	if len(all) != 5 {
+		t.Fatalf("expected 5 candidates and gets %d", len(all))
+	}
+	sum := 0.
+	for _, c := range all {
+		sum += c.Score
+	}
+	if math.Abs(sum-1.) > 1e-9 {
+		t.Fatalf("expected scores summing to 1 and gets %f", sum)
+	}
+}
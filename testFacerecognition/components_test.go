@@ -0,0 +1,90 @@
+package testFacerecognition
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestComponentsSelection(t *testing.T) {
+	spectrum := []float64{10, 5, 1, 0.5, 0.25}
+	explained := model.ExplainedVariance(spectrum)
+	for i, expected := range []float64{10 / 16.75, 15 / 16.75, 16 / 16.75, 16.5 / 16.75, 1} {
+		if math.Abs(explained[i]-expected) > 1e-12 {
+			t.Fatalf("expected an explained variance of %f for %d components and gets %f", expected, i+1, explained[i])
+		}
+	}
+	for _, tc := range []struct {
+		ratio    float64
+		expected int
+	}{{0.5, 1}, {0.9, 3}, {0.95, 3}, {0.99, 5}, {1, 5}} {
+		if components := model.ComponentsByVariance(spectrum, tc.ratio); components != tc.expected {
+			t.Fatalf("expected %d components for %f of the variance and gets %d", tc.expected, tc.ratio, components)
+		}
+	}
+	// the distances to the line joining (0, 10) and (4, 0.25) are the largest at the third eigenvalue
+	if components := model.ComponentsByElbow(spectrum); components != 3 {
+		t.Fatalf("expected the elbow at 3 components and gets %d", components)
+	}
+	if components := model.ComponentsByElbow([]float64{4, 1, 0}); components != 2 {
+		t.Fatalf("expected 2 components for 2 positive eigenvalues and gets %d", components)
+	}
+}
+
+func TestPCAVarianceSelection(t *testing.T) {
+	trainingSet, labels := faces([]string{"s1", "s2", "s3", "s4", "s5"}, 1, 5)
+	for _, parameters := range []model.Parameters{{"selection": model.VarianceSelection, "variance": "0.8"}, {"selection": model.ElbowSelection}} {
+		trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 0, nil)
+		trainer.MetricName = model.L1Metric
+		trainer.FeatureParameters = parameters
+		for i := range trainingSet {
+			trainer.Add(trainingSet[i], labels[i])
+		}
+		trainer.Train()
+		components, explained := trainer.ExplainedVariance()
+		if len(explained) != len(trainingSet) {
+			t.Fatalf("expected the variance explained by %d components and gets %d", len(trainingSet), len(explained))
+		}
+		if dim := trainer.Model[0].Matrix.M; components != dim {
+			t.Fatalf("expected %d components and gets %d", dim, components)
+		}
+		spectrum := trainer.Extractor.(model.SpectrumFeatureExtractor).Spectrum()
+		if parameters["selection"] == model.VarianceSelection {
+			if explained[components-1] < 0.8 || (components > 1 && explained[components-2] >= 0.8) {
+				t.Fatalf("expected the fewest components explaining 80%% of the variance and gets %d explaining %f", components, explained[components-1])
+			}
+		} else if expected := model.ComponentsByElbow(spectrum); components != expected {
+			t.Fatalf("expected %d components at the elbow and gets %d", expected, components)
+		}
+
+		path := filepath.Join(t.TempDir(), "trained_model.json")
+		if err := trainer.Save(path); err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		loaded, err := model.LoadTrainer(path)
+		if err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		if loadedComponents, loadedExplained := loaded.ExplainedVariance(); loadedComponents != components || len(loadedExplained) != len(explained) {
+			t.Fatalf("expected %d components and %d ratios after loading and gets %d and %d", components, len(explained), loadedComponents, len(loadedExplained))
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		tm := &model.TrainedModel{}
+		if err := json.Unmarshal(data, tm); err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		if tm.Components != components || len(tm.ExplainedVariance) != len(explained) {
+			t.Fatalf("expected %d components and the explained variance in the persisted model", components)
+		}
+	}
+	if _, err := model.NewFeatureExtractor(model.PCAFeatureType, model.Parameters{"variance": "1.5"}); err == nil {
+		t.Fatal("expected an error for a variance greater than 1")
+	}
+}
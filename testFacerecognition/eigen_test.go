@@ -0,0 +1,223 @@
+package testFacerecognition
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+// symmetricMatrix returns V diag(values) V^T with V a random orthonormal basis.
+func symmetricMatrix(r *rand.Rand, values []float64) *algorithm.Matrix {
+	n := len(values)
+	random := randomMatrix(r, n, n)
+	basis := random.Plus(random.Transpose()).SymEig().GetV()
+	scaled := basis.Copy()
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			scaled.A[i][j] *= values[j]
+		}
+	}
+	return scaled.TimesMatrix(basis.Transpose())
+}
+
+// checkEigenpairs checks that the columns of vectors are unit eigenvectors of
+// m for the eigenvalues values.
+func checkEigenpairs(t *testing.T, name string, m *algorithm.Matrix, values []float64, vectors *algorithm.Matrix) {
+	mv := m.TimesMatrix(vectors)
+	for c := range values {
+		norm, residual := 0., 0.
+		for i := 0; i < m.M; i++ {
+			norm += vectors.A[i][c] * vectors.A[i][c]
+			residual += math.Pow(mv.A[i][c]-values[c]*vectors.A[i][c], 2)
+		}
+		if math.Abs(norm-1) > 1e-8 || math.Sqrt(residual) > 1e-6*math.Max(1, math.Abs(values[0])) {
+			t.Fatalf("%s eigenpair %d of %f has a norm of %f and a residual of %g", name, c, values[c], norm, math.Sqrt(residual))
+		}
+	}
+}
+
+func TestSymmetricEigen(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	values := make([]float64, 40)
+	for i := range values {
+		values[i] = r.NormFloat64() * 10
+	}
+	m := symmetricMatrix(r, values)
+	feature := m.SymEig()
+	sort.Float64s(values)
+	for i, d := range feature.Getd() {
+		if math.Abs(d-values[i]) > 1e-9 {
+			t.Fatalf("expected the eigenvalue %d %f and gets %f", i, values[i], d)
+		}
+	}
+	checkEigenpairs(t, "SymEig", m, feature.Getd(), feature.GetV())
+}
+
+func TestTopEigen(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	// a decaying spectrum, a negative eigenvalue and a rank deficient matrix
+	for _, values := range [][]float64{
+		func() []float64 {
+			values := make([]float64, 500)
+			for i := range values {
+				values[i] = 1000 * math.Pow(0.97, float64(i))
+			}
+			return values
+		}(),
+		{9, 7, 5, -20, 3, 1, 0.5, 0.25, 0.1, 0.05},
+		append([]float64{4, 2, 1}, make([]float64, 57)...),
+	} {
+		m := symmetricMatrix(r, values)
+		expected := append([]float64{}, values...)
+		sort.Sort(sort.Reverse(sort.Float64Slice(expected)))
+		k := 3
+		top, vectors, err := algorithm.TopEigen(m, k)
+		if err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		if len(top) != k || vectors.M != len(values) || vectors.N != k {
+			t.Fatalf("expected %d eigenpairs of dimension %d and gets %d of %dx%d", k, len(values), len(top), vectors.M, vectors.N)
+		}
+		for i := range top {
+			if math.Abs(top[i]-expected[i]) > 1e-8*expected[0] {
+				t.Fatalf("expected the eigenvalue %d %f and gets %f", i, expected[i], top[i])
+			}
+		}
+		checkEigenpairs(t, "TopEigen", m, top, vectors)
+	}
+	if _, _, err := algorithm.TopEigen(algorithm.NewMatrix(3, 4), 1); err == nil {
+		t.Fatal("expected an error for a matrix not square")
+	}
+	if _, _, err := algorithm.TopEigen(algorithm.NewMatrix(3, 3), 4); err == nil {
+		t.Fatal("expected an error for more eigenvalues than the dimension")
+	}
+}
+
+func TestGeneralizedSymmetricEigen(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	n := 20
+	a := symmetricMatrix(r, randomMatrix(r, 1, n).A[0])
+	positive := make([]float64, n)
+	for i := range positive {
+		positive[i] = 0.5 + r.Float64()
+	}
+	b := symmetricMatrix(r, positive)
+	values, vectors, err := algorithm.GeneralizedSymmetricEigen(a, b)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if !sort.Float64sAreSorted(values) {
+		t.Fatalf("expected the eigenvalues in increasing order and gets %v", values)
+	}
+	// a V = b V diag(values) and V^T b V = I
+	av, bv := a.TimesMatrix(vectors), b.TimesMatrix(vectors)
+	for i := 0; i < n; i++ {
+		for c := 0; c < n; c++ {
+			if math.Abs(av.A[i][c]-values[c]*bv.A[i][c]) > 1e-9 {
+				t.Fatalf("eigenpair %d of %f has a residual of %g", c, values[c], av.A[i][c]-values[c]*bv.A[i][c])
+			}
+		}
+	}
+	vbv := vectors.TransposeTimesMatrix(bv)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			expected := 0.
+			if i == j {
+				expected = 1
+			}
+			if math.Abs(vbv.A[i][j]-expected) > 1e-9 {
+				t.Fatalf("expected b-orthonormal eigenvectors and gets %f at (%d,%d)", vbv.A[i][j], i, j)
+			}
+		}
+	}
+
+	positive[0] = -1
+	if _, _, err := algorithm.GeneralizedSymmetricEigen(a, symmetricMatrix(r, positive)); !errors.Is(err, algorithm.ErrSingularMatrix) {
+		t.Fatalf("expected a singular matrix error for b not positive definite and gets %v", err)
+	}
+}
+
+func TestSVD(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for _, size := range [][2]int{{30, 8}, {8, 30}, {12, 12}} {
+		m := randomMatrix(r, size[0], size[1])
+		original := m.Copy()
+		svd := m.SVD()
+		sameMatrix(t, "SVD input", original, m)
+		u, v := svd.GetU(), svd.GetV()
+		n := size[0]
+		if size[1] < n {
+			n = size[1]
+		}
+		if u.M != size[0] || u.N != n || v.M != size[1] || v.N < n {
+			t.Fatalf("SVD of a %dx%d matrix : unexpected U %dx%d and V %dx%d", size[0], size[1], u.M, u.N, v.M, v.N)
+		}
+		// A = U S V^T
+		reconstructed := u.TimesMatrix(svd.GetS()).TimesMatrix(v.GetMatrix3(0, v.M-1, 0, n-1).Transpose())
+		for i := 0; i < m.M; i++ {
+			for j := 0; j < m.N; j++ {
+				if math.Abs(reconstructed.A[i][j]-m.A[i][j]) > 1e-10 {
+					t.Fatalf("SVD of a %dx%d matrix : expected %f at (%d,%d) and gets %f", size[0], size[1], m.A[i][j], i, j, reconstructed.A[i][j])
+				}
+			}
+		}
+	}
+}
+
+func TestPCASolvers(t *testing.T) {
+	trainingSet, labels := faces([]string{"s1", "s2", "s3", "s4", "s5", "s6", "s7", "s8", "s9", "s10"}, 1, 10)
+	components := 10
+	extractions := make(map[string]*model.FeatureExtraction)
+	for _, solver := range []string{model.FullSolver, model.LanczosSolver, model.SVDSolver} {
+		extractor, err := model.NewFeatureExtractor(model.PCAFeatureType, model.Parameters{"components": "10", "solver": solver})
+		if err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		if err := extractor.Fit(trainingSet, labels); err != nil {
+			t.Fatalf("PCA %s solver : this error was not expected %v", solver, err)
+		}
+		extractions[solver] = extractor.(model.LinearFeatureExtractor).Extraction()
+	}
+	full := extractions[model.FullSolver]
+	if len(extractions[model.LanczosSolver].Spectrum) != 0 || len(full.Spectrum) != len(trainingSet) || len(extractions[model.SVDSolver].Spectrum) != len(trainingSet) {
+		t.Fatal("expected the spectrum of the full and svd solvers only")
+	}
+	for i := range full.Spectrum {
+		if math.Abs(full.Spectrum[i]-extractions[model.SVDSolver].Spectrum[i]) > 1e-8*full.Spectrum[0] {
+			t.Fatalf("expected the same spectrum with the svd solver at %d : %f and %f", i, full.Spectrum[i], extractions[model.SVDSolver].Spectrum[i])
+		}
+	}
+	for _, solver := range []string{model.LanczosSolver, model.SVDSolver} {
+		e := extractions[solver]
+		for c := 0; c < components; c++ {
+			if math.Abs(full.Eigenvalues[c]-e.Eigenvalues[c]) > 1e-8*full.Eigenvalues[0] {
+				t.Fatalf("%s solver : expected the eigenvalue %d %f and gets %f", solver, c, full.Eigenvalues[c], e.Eigenvalues[c])
+			}
+		}
+		// same subspace : the squared cosines of the principal angles sum to
+		// the number of components
+		cosines := full.W.TransposeTimesMatrix(e.W)
+		sum := 0.
+		for _, c := range cosines.Data {
+			sum += c * c
+		}
+		if math.Abs(sum-float64(components)) > 1e-6 {
+			t.Fatalf("%s solver : expected the subspace of the full solver and gets a sum of squared cosines of %f", solver, sum)
+		}
+		// and the same eigenfaces up to their sign
+		for c := 0; c < components; c++ {
+			if math.Abs(math.Abs(cosines.A[c][c])-1) > 1e-6 {
+				t.Fatalf("%s solver : expected the same eigenface %d and gets a cosine of %f", solver, c, cosines.A[c][c])
+			}
+		}
+	}
+
+	if _, err := model.NewFeatureExtractor(model.PCAFeatureType, model.Parameters{"solver": model.LanczosSolver, "selection": model.VarianceSelection}); err == nil {
+		t.Fatal("expected an error for the lanczos solver with the variance selection")
+	}
+}
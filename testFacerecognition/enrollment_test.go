@@ -0,0 +1,154 @@
+package testFacerecognition
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestEnrollWithoutRetraining(t *testing.T) {
+	m := &model.L1{}
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 10, m.GetDistance)
+	for _, person := range []string{"s1", "s2", "s3", "s4"} {
+		for i := 1; i <= 5; i++ {
+			trainer.Add(model.ToMatrix(fmt.Sprintf("faces/%s/%d.pgm", person, i)).Vectorize(), person)
+		}
+	}
+	trainer.Train()
+	w := trainer.FeatureExtraction.W
+
+	for i := 1; i <= 5; i++ {
+		path := fmt.Sprintf("faces/s5/%d.pgm", i)
+		if err := trainer.Enroll(model.ToMatrix(path).Vectorize(), "s5", path); err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+	}
+	trainer.WaitRetrain()
+	if trainer.FeatureExtraction.W != w {
+		t.Fatal("expected no retraining without retrain policy")
+	}
+	if len(trainer.Model) != 25 {
+		t.Fatalf("expected 25 projected images and gets %d", len(trainer.Model))
+	}
+	if trainer.Drift() <= 0 {
+		t.Fatalf("expected a drift for a person not in the feature space and gets %f", trainer.Drift())
+	}
+	label, _ := trainer.Recognize(model.ToMatrix("faces/s5/6.pgm").Vectorize())
+	if label != "s5" {
+		t.Fatalf("expected s5 and gets %s", label)
+	}
+}
+
+func TestEnrollRetrainPolicy(t *testing.T) {
+	m := &model.L1{}
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 10, m.GetDistance)
+	trainer.RetrainPolicy = model.RetrainPolicy{MaxPending: 3}
+	retrained := make(chan bool, 1)
+	trainer.AfterRetrain = func(t *model.Trainer) { retrained <- true }
+	for _, person := range []string{"s1", "s2", "s3", "s4"} {
+		for i := 1; i <= 5; i++ {
+			trainer.Add(model.ToMatrix(fmt.Sprintf("faces/%s/%d.pgm", person, i)).Vectorize(), person)
+		}
+	}
+	trainer.Train()
+	w := trainer.FeatureExtraction.W
+
+	for i := 1; i <= 3; i++ {
+		path := fmt.Sprintf("faces/s5/%d.pgm", i)
+		if err := trainer.Enroll(model.ToMatrix(path).Vectorize(), "s5", path); err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+	}
+	trainer.WaitRetrain()
+	select {
+	case <-retrained:
+	default:
+		t.Fatal("expected the retrained model handed to AfterRetrain once WaitRetrain returns")
+	}
+	if trainer.FeatureExtraction.W == w {
+		t.Fatal("expected a retraining after 3 enrolled images")
+	}
+	if len(trainer.Model) != 23 || len(trainer.TrainingSet) != 23 {
+		t.Fatalf("expected 23 images and gets %d projected and %d training", len(trainer.Model), len(trainer.TrainingSet))
+	}
+	if trainer.Drift() != 0 {
+		t.Fatalf("expected no drift after retraining and gets %f", trainer.Drift())
+	}
+}
+
+func TestEnrollLibraryReload(t *testing.T) {
+	conf := model.GetConfig()
+	defer func(basePath string) { conf.FaceRecognitionBasePath = basePath }(conf.FaceRecognitionBasePath)
+	conf.FaceRecognitionBasePath = t.TempDir()
+	users := []string{"s1", "s2", "s3", "s4"}
+	for _, person := range users {
+		directory := filepath.Join(conf.FaceRecognitionBasePath, person+".test")
+		if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		for i := 1; i <= 5; i++ {
+			if err := copyFile(fmt.Sprintf("faces/%s/%d.pgm", person, i), filepath.Join(directory, fmt.Sprintf("%d.pgm", i))); err != nil {
+				t.Fatalf("this error was not expected %v", err)
+			}
+		}
+	}
+	fl := model.NewFaceRecognitionLib()
+	for _, person := range users[:3] {
+		fl.Items[person+".test"] = &model.FaceRecognitionItem{User: model.User{FirstName: person, LastName: "test"}}
+	}
+	if err := fl.Save(); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	fl = model.LoadFaceRecognitionLib()
+	trainer := fl.GetTrainer(model.PCAFeatureType)
+	if err := trainer.Train(); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	projections := len(trainer.Model)
+
+	// an image missing changes neither the library nor the trainer
+	missing := model.NewFaceRecognitionItem()
+	missing.User = model.User{FirstName: "s4", LastName: "test"}
+	missing.TrainingImages = []string{filepath.Join(conf.FaceRecognitionBasePath, "s4.test", "1.pgm"), filepath.Join(conf.FaceRecognitionBasePath, "missing.pgm")}
+	if err := fl.Enroll(trainer, missing); err == nil {
+		t.Fatal("expected an error for a missing image")
+	}
+	if _, ok := model.LoadFaceRecognitionLib().Items["s4.test"]; ok || len(trainer.Model) != projections {
+		t.Fatalf("expected nothing enrolled and gets %d projections for %d", len(trainer.Model), projections)
+	}
+
+	item := model.NewFaceRecognitionItem()
+	item.User = model.User{FirstName: "s4", LastName: "test"}
+	for i := 1; i <= 5; i++ {
+		item.TrainingImages = append(item.TrainingImages, filepath.Join(conf.FaceRecognitionBasePath, "s4.test", fmt.Sprintf("%d.pgm", i)))
+	}
+	if err := fl.Enroll(trainer, item); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if len(trainer.Model) != projections+5 {
+		t.Fatalf("expected %d projections and gets %d", projections+5, len(trainer.Model))
+	}
+
+	// the library saved and loaded again still matches the trained model
+	loaded, err := model.LoadTrainer(conf.GetTrainedModel())
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	// the images are repeated up to the minimal number once, not at each load
+	images := fl.MinimalNumOfComponents
+	for i := 0; i < 2; i++ {
+		reloaded := model.LoadFaceRecognitionLib()
+		if len(reloaded.Items["s4.test"].TrainingImages) != images {
+			t.Fatalf("expected %d training images and gets %d", images, len(reloaded.Items["s4.test"].TrainingImages))
+		}
+		if err := loaded.CheckLibrary(reloaded); err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		if err := reloaded.Save(); err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package testFacerecognition
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestAlgorithmErrors(t *testing.T) {
+	if _, err := algorithm.NewMatrix(2, 3).TryTimesMatrix(algorithm.NewMatrix(2, 3)); !errors.Is(err, algorithm.ErrDimensionMismatch) {
+		t.Fatalf("expected a dimension mismatch and gets %v", err)
+	}
+	singular, _ := algorithm.NewMatrixWithArrays([][]float64{{1, 2}, {2, 4}})
+	if _, err := singular.TryInverse(); !errors.Is(err, algorithm.ErrSingularMatrix) {
+		t.Fatalf("expected a singular matrix and gets %v", err)
+	}
+	if !errors.Is(algorithm.ErrSingularMatrix, model.ErrSingularMatrix) {
+		t.Fatal("expected the model and algorithm sentinels to be the same")
+	}
+}
+
+func TestModelErrors(t *testing.T) {
+	garbage := filepath.Join(t.TempDir(), "garbage.pgm")
+	if err := os.WriteFile(garbage, []byte("not an image"), 0644); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if _, err := model.ReadMatrix(garbage); !errors.Is(err, model.ErrUndecodableImage) {
+		t.Fatalf("expected an undecodable image and gets %v", err)
+	}
+
+	trainingSet, labels := faces([]string{"s1"}, 1, 3)
+	if _, err := model.FitLDA(trainingSet, labels, 2); !errors.Is(err, model.ErrInsufficientSamples) {
+		t.Fatalf("expected insufficient samples with a single identity and gets %v", err)
+	}
+
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 2, nil)
+	if err := trainer.Train(); !errors.Is(err, model.ErrInsufficientSamples) {
+		t.Fatalf("expected insufficient samples for an empty trainer and gets %v", err)
+	}
+	if _, _, err := trainer.Identify(trainingSet[0]); !errors.Is(err, model.ErrNotTrained) {
+		t.Fatalf("expected a trainer not trained and gets %v", err)
+	}
+
+	trainingSet, labels = faces([]string{"s1", "s2", "s3"}, 1, 2)
+	for i := range trainingSet {
+		trainer.Add(trainingSet[i], labels[i])
+	}
+	if err := trainer.Train(); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if label, _, err := trainer.Identify(trainingSet[2]); err != nil || label != "s2" {
+		t.Fatalf("expected s2 and gets %s, %v", label, err)
+	}
+	if _, _, err := trainer.Identify(algorithm.NewMatrix(10, 1)); !errors.Is(err, model.ErrDimensionMismatch) {
+		t.Fatalf("expected a dimension mismatch and gets %v", err)
+	}
+
+	projected := []*model.ProjectedTrainingMatrix{
+		model.NewProjectedTrainingMatrix(vector(0, 0), "a"),
+		model.NewProjectedTrainingMatrix(vector(1, 1), "b"),
+	}
+	if _, err := model.NearestNeighbors(projected, vector(0, 1), 3, (&model.L1{}).GetDistance); !errors.Is(err, model.ErrInsufficientSamples) {
+		t.Fatalf("expected insufficient samples for K larger than the training set and gets %v", err)
+	}
+}
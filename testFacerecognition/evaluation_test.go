@@ -0,0 +1,66 @@
+package testFacerecognition
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/evaluation"
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestCrossValidate(t *testing.T) {
+	ds := &evaluation.Dataset{}
+	for _, person := range []string{"s1", "s2", "s3", "s4", "s5"} {
+		for i := 1; i <= 4; i++ {
+			path := fmt.Sprintf("faces/%s/%d.pgm", person, i)
+			ds.Add(model.ToMatrix(path).Vectorize(), person, path)
+		}
+	}
+	opts := evaluation.NewOptions()
+	opts.Folds = 2
+	reports, err := evaluation.CrossValidate(ds, opts)
+	if err != nil {
+		t.Fatalf("cannot cross validate : %v", err)
+	}
+	if len(reports) != len(opts.FeatureTypes)*len(opts.Metrics) {
+		t.Fatalf("expected %d reports and gets %d", len(opts.FeatureTypes)*len(opts.Metrics), len(reports))
+	}
+	for _, r := range reports {
+		if r.Error != "" {
+			continue
+		}
+		if r.Total != len(ds.Images) {
+			t.Fatalf("%s/%s expected %d tests and gets %d", r.FeatureType, r.Metric, len(ds.Images), r.Total)
+		}
+		if r.Folds != 2 {
+			t.Fatalf("%s/%s expected 2 folds and gets %d", r.FeatureType, r.Metric, r.Folds)
+		}
+		recognized := 0
+		for _, row := range r.Confusion {
+			for _, count := range row {
+				recognized += count
+			}
+		}
+		if recognized != r.Total {
+			t.Fatalf("%s/%s confusion matrix counts %d tests instead of %d", r.FeatureType, r.Metric, recognized, r.Total)
+		}
+		t.Logf("%s/%s rank-1 accuracy %.2f", r.FeatureType, r.Metric, r.Accuracy)
+	}
+	if reports[0].FeatureType != model.PCAFeatureType || reports[0].Accuracy < 0.5 {
+		t.Fatalf("expected PCA accuracy above 0.5 and gets %+v", reports[0])
+	}
+
+	var buf bytes.Buffer
+	if err := evaluation.WriteReportsJSON(&buf, reports); err != nil {
+		t.Fatalf("cannot write JSON report : %v", err)
+	}
+	decoded := make([]*evaluation.Report, 0)
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("cannot read JSON report : %v", err)
+	}
+	if len(decoded) != len(reports) || decoded[0].Correct != reports[0].Correct {
+		t.Fatalf("JSON report differs from the evaluation")
+	}
+}
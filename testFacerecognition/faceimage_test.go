@@ -0,0 +1,168 @@
+package testFacerecognition
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+// grayGradient returns an image of 6x4 gray levels, with all the values from
+// 0 to 253 in steps of 11.
+func grayGradient() *algorithm.Matrix {
+	m := algorithm.NewMatrix(4, 6)
+	for i := range m.Data {
+		m.Data[i] = float64(i * 11)
+	}
+	return m
+}
+
+// row returns a matrix of one row with values.
+func row(values ...float64) *algorithm.Matrix {
+	m := algorithm.NewMatrix(1, len(values))
+	copy(m.Data, values)
+	return m
+}
+
+func TestDecodeMatrix(t *testing.T) {
+	expected := grayGradient()
+	gray := model.ToImage(expected)
+	plain := bytes.NewBufferString("P2\n# a comment\n6 # the width\n4\n255\n")
+	raw8 := bytes.NewBufferString("P5 6 4 255\n")
+	raw16 := bytes.NewBufferString("P5\n6 4\n65535\n")
+	for _, v := range expected.Data {
+		fmt.Fprintf(plain, "%d\n", int(v))
+		raw8.WriteByte(byte(v))
+		raw16.Write([]byte{byte(v), byte(v)})
+	}
+	encoded := map[string]*bytes.Buffer{"plain PGM": plain, "raw PGM": raw8, "16 bits PGM": raw16}
+
+	encoded["PNG"] = new(bytes.Buffer)
+	if err := png.Encode(encoded["PNG"], gray); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	// a GIF of the gray levels in a palette of 256 grays
+	grays := make(color.Palette, 256)
+	for i := range grays {
+		grays[i] = color.RGBA{uint8(i), uint8(i), uint8(i), 255}
+	}
+	paletted := image.NewPaletted(gray.Bounds(), grays)
+	copy(paletted.Pix, gray.Pix)
+	encoded["GIF"] = new(bytes.Buffer)
+	if err := gif.Encode(encoded["GIF"], paletted, nil); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	for name, buffer := range encoded {
+		m, err := model.DecodeMatrix(buffer)
+		if err != nil {
+			t.Fatalf("%s : this error was not expected %v", name, err)
+		}
+		sameMatrix(t, name, expected, m)
+	}
+
+	// the colors are weighted by 0.299, 0.587 and 0.114
+	colored := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	colored.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	colored.Set(1, 0, color.RGBA{0, 255, 0, 255})
+	colored.Set(2, 0, color.RGBA{0, 0, 255, 255})
+	m := model.ImageMatrix(colored)
+	for i, weight := range []float64{0.299, 0.587, 0.114} {
+		if math.Abs(m.A[0][i]-255*weight) > 0.01 {
+			t.Fatalf("expected the gray level %f for the color %d and gets %f", 255*weight, i, m.A[0][i])
+		}
+	}
+	if m := model.ImageMatrix(image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9)); m.A[0][0] != 0 {
+		t.Fatalf("expected the black of the palette and gets %f", m.A[0][0])
+	}
+
+	// the samples are scaled by the maximum value of the file
+	m, err := model.DecodeMatrix(bytes.NewBufferString("P2 3 1 15 0 5 15"))
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	sameMatrix(t, "maximum value 15", row(0, 85, 255), m)
+
+	jpg := new(bytes.Buffer)
+	if err := jpeg.Encode(jpg, gray, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if m, err = model.DecodeMatrix(jpg); err != nil || m.M != 4 || m.N != 6 {
+		t.Fatalf("expected a JPEG of 6x4 and gets %v, %v", m, err)
+	}
+	for i := range expected.Data {
+		if math.Abs(expected.Data[i]-m.Data[i]) > 8 {
+			t.Fatalf("expected a JPEG gray level near %f at %d and gets %f", expected.Data[i], i, m.Data[i])
+		}
+	}
+
+	for _, invalid := range []string{"", "not an image", "P5 6 4 255\n\x00\x01", "P2 2 1 255 1", "P2 2 1 255 1 256", "P2 2 1 70000 1 2", "P2 2 x 255 1 2"} {
+		if _, err := model.DecodeMatrix(bytes.NewBufferString(invalid)); !errors.Is(err, model.ErrUndecodableImage) {
+			t.Fatalf("expected an undecodable image for %q and gets %v", invalid, err)
+		}
+	}
+	if _, err := model.ReadMatrix(filepath.Join(t.TempDir(), "missing.pgm")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a missing file and gets %v", err)
+	}
+}
+
+func TestImageConversions(t *testing.T) {
+	m, err := model.ReadMatrix("faces/s1/1.pgm")
+	if err != nil || m.M != 112 || m.N != 92 {
+		t.Fatalf("expected an ORL face of 112 rows and 92 columns and gets %v", err)
+	}
+	width, height, vector, err := model.ReadVector("faces/s1/1.pgm")
+	if err != nil || width != 92 || height != 112 {
+		t.Fatalf("expected a vector of 92x112 and gets %dx%d, %v", width, height, err)
+	}
+	sameMatrix(t, "ReadVector", m.Vectorize(), row(vector...).Transpose())
+
+	// the gray images keep their gray levels through ToImage
+	sameMatrix(t, "ToImage", m, model.ImageMatrix(model.ToImage(m)))
+	sameMatrix(t, "ToImage clamped", row(0, 12, 13, 255), model.ImageMatrix(model.ToImage(row(-20, 12.4, 12.6, 300))))
+
+	path := filepath.Join(t.TempDir(), "gradient.png")
+	encodePng(t, model.ToImage(grayGradient()), path)
+	pgmPath, err := model.ConvertToPgm(path)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	sameMatrix(t, "ConvertToPgm", grayGradient(), model.ToMatrix(pgmPath))
+
+	uniform := image.NewGray(image.Rect(0, 0, 40, 60))
+	for i := range uniform.Pix {
+		uniform.Pix[i] = 77
+	}
+	stream := model.StreamToVector(uniform, 30, 50)
+	if len(stream) != 30*50 {
+		t.Fatalf("expected a vector of %d and gets %d", 30*50, len(stream))
+	}
+	for i, v := range stream {
+		if math.Abs(v-77) > 1e-9 {
+			t.Fatalf("expected the gray level 77 at %d and gets %f", i, v)
+		}
+	}
+}
+
+// encodePng writes img in the PNG format in path.
+func encodePng(t *testing.T, img image.Image, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+}
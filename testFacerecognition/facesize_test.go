@@ -0,0 +1,83 @@
+package testFacerecognition
+
+import (
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestFaceSize(t *testing.T) {
+	var none *model.Config
+	if width, height := none.GetFaceSize(); width != 92 || height != 92 {
+		t.Fatalf("expected the default face size 92x92 and gets %dx%d", width, height)
+	}
+	if width, height := (&model.Config{FaceWidth: 80, FaceHeight: 100}).GetFaceSize(); width != 80 || height != 100 {
+		t.Fatalf("expected the face size 80x100 and gets %dx%d", width, height)
+	}
+
+	conf := model.GetConfig()
+	defer func(width, height int) { conf.FaceWidth, conf.FaceHeight = width, height }(conf.FaceWidth, conf.FaceHeight)
+	conf.FaceWidth, conf.FaceHeight = 80, 100
+	lib := model.NewFaceRecognitionLib()
+	if lib.Width != 80 || lib.Height != 100 {
+		t.Fatalf("expected a library of 80x100 and gets %dx%d", lib.Width, lib.Height)
+	}
+	faces := lib.Faces(decodeFile(t, "images/barack.png"))
+	if len(faces) == 0 {
+		t.Fatal("expected len faces > to 0")
+	}
+	for _, f := range faces {
+		if f.Image.Bounds() != image.Rect(0, 0, 80, 100) {
+			t.Fatalf("expected a face of 80x100 and gets %v", f.Image.Bounds())
+		}
+		// the square rectangles of the detector are grown to the aspect ratio
+		if ratio := float64(f.Rect.Dx()) / float64(f.Rect.Dy()); math.Abs(ratio-0.8) > 0.02 {
+			t.Fatalf("expected a face rectangle of aspect ratio 0.8 and gets %v", f.Rect)
+		}
+	}
+}
+
+func TestNormalizeStoredFaces(t *testing.T) {
+	dir := t.TempDir()
+	lib := model.NewFaceRecognitionLib()
+	lib.Width, lib.Height = 80, 100
+	item := model.NewFaceRecognitionItem()
+	item.User = model.User{FirstName: "s1", LastName: "normalized"}
+	for _, name := range []string{"1.pgm", "2.pgm"} {
+		content, err := os.ReadFile(filepath.Join("faces", "s1", name))
+		if err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		item.TrainingImages = append(item.TrainingImages, path)
+	}
+	// the same face twice, as the library loads them to reach the minimal count
+	item.TrainingImages = append(item.TrainingImages, item.TrainingImages[0])
+	lib.Items[item.GetKey()] = item
+	defer os.RemoveAll(model.GetConfig().GetOriginalsDirectory() + item.GetKey())
+
+	lib.NormalizeImageLength()
+	for _, path := range item.TrainingImages {
+		width, height, _, err := model.ReadVector(path)
+		if err != nil || width != 80 || height != 100 {
+			t.Fatalf("expected a face of 80x100 in %s and gets %dx%d, %v", path, width, height, err)
+		}
+		// the original is kept
+		original := filepath.Join(model.GetConfig().GetOriginalsDirectory()+item.GetKey(), filepath.Base(path))
+		sameMatrix(t, "original "+path, model.ToMatrix(filepath.Join("faces", "s1", filepath.Base(path))), model.ToMatrix(original))
+	}
+
+	// the faces are normalized from their original, back to their size
+	lib.Width, lib.Height = 92, 112
+	lib.NormalizeImageLength()
+	for _, path := range item.TrainingImages {
+		sameMatrix(t, "normalized "+path, model.ToMatrix(filepath.Join("faces", "s1", filepath.Base(path))), model.ToMatrix(path))
+	}
+}
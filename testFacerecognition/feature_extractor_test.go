@@ -0,0 +1,136 @@
+package testFacerecognition
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/model"
+	"github.com/pkg/errors"
+)
+
+// subsample is a feature extractor defined outside the model package keeping
+// one pixel every step pixels.
+type subsample struct {
+	Step int `json:"step"`
+}
+
+func (s *subsample) Name() string {
+	return "Subsample"
+}
+
+func (s *subsample) Fit(trainingSet []*algorithm.Matrix, labels []string) error {
+	if len(trainingSet) == 0 {
+		return errors.New("empty training set")
+	}
+	return nil
+}
+
+func (s *subsample) Project(m *algorithm.Matrix) *algorithm.Matrix {
+	p := algorithm.NewMatrix((m.M+s.Step-1)/s.Step, 1)
+	for i := 0; i < m.M; i += s.Step {
+		p.A[i/s.Step][0] = m.A[i][0]
+	}
+	return p
+}
+
+func (s *subsample) Save() (json.RawMessage, error) {
+	return json.Marshal(s)
+}
+
+func (s *subsample) Load(data json.RawMessage) error {
+	return json.Unmarshal(data, s)
+}
+
+func init() {
+	err := model.RegisterFeatureExtractor(&model.FeatureExtractorRegistration{
+		Name:        "Subsample",
+		Description: "one pixel every step pixels",
+		Parameters: []*model.ParameterDefinition{
+			{Name: "step", Type: model.IntParameter, Default: "4", Description: "distance between the pixels kept"},
+		},
+		New: func(p model.Parameters) (model.FeatureExtractor, error) {
+			if p.Int("step") < 1 {
+				return nil, errors.New("step must be at least 1")
+			}
+			return &subsample{Step: p.Int("step")}, nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestFeatureExtractorRegistry(t *testing.T) {
+	names := make(map[string]bool)
+	for _, r := range model.FeatureExtractors() {
+		names[r.Name] = true
+	}
+	for _, name := range []string{model.PCAFeatureType, model.LDAFeatureType, model.LPPFeatureType, "Subsample"} {
+		if !names[name] {
+			t.Fatalf("expected feature extractor %s to be registered", name)
+		}
+	}
+	if err := model.RegisterFeatureExtractor(&model.FeatureExtractorRegistration{
+		Name: model.PCAFeatureType,
+		New:  func(p model.Parameters) (model.FeatureExtractor, error) { return nil, nil },
+	}); err == nil {
+		t.Fatal("expected an error while registering PCA twice")
+	}
+	if _, err := model.NewFeatureExtractor("unknown", nil); err == nil {
+		t.Fatal("expected an error for an unknown feature extractor")
+	}
+	if _, err := model.NewFeatureExtractor(model.PCAFeatureType, model.Parameters{"unknown": "1"}); err == nil {
+		t.Fatal("expected an error for an unknown parameter")
+	}
+	if _, err := model.NewFeatureExtractor(model.PCAFeatureType, model.Parameters{"components": "many"}); err == nil {
+		t.Fatal("expected an error for a components parameter not integer")
+	}
+	if _, err := model.NewFeatureExtractor(model.PCAFeatureType, model.Parameters{"components": "-1"}); err == nil {
+		t.Fatal("expected an error for a negative components parameter")
+	}
+	if _, err := model.NewFeatureExtractor("Subsample", model.Parameters{"step": "0"}); err == nil {
+		t.Fatal("expected an error for a step of 0")
+	}
+}
+
+func TestTrainerCustomFeatureExtractor(t *testing.T) {
+	m := &model.L1{}
+	trainer := model.NewTrainerArgs("Subsample", 1, 0, m.GetDistance)
+	trainer.MetricName = model.L1Metric
+	trainer.FeatureParameters = model.Parameters{"step": "8"}
+	for _, person := range []string{"s1", "s2", "s3"} {
+		for _, i := range []string{"1", "2", "3"} {
+			trainer.Add(model.ToMatrix("faces/"+person+"/"+i+".pgm").Vectorize(), person)
+		}
+	}
+	trainer.Train()
+	if !trainer.Trained() {
+		t.Fatal("expected the trainer to be trained")
+	}
+	if dim := trainer.Model[0].Matrix.M; dim != 92*112/8 {
+		t.Fatalf("expected projections of dimension %d and gets %d", 92*112/8, dim)
+	}
+	probe := model.ToMatrix("faces/s2/5.pgm").Vectorize()
+	label, _ := trainer.Recognize(probe)
+	if label != "s2" {
+		t.Fatalf("expected s2 and gets %s", label)
+	}
+
+	path := filepath.Join(t.TempDir(), "trained_model.json")
+	if err := trainer.Save(path); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	loaded, err := model.LoadTrainer(path)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if loaded.FeatureType != "Subsample" || loaded.FeatureParameters.Int("step") != 8 {
+		t.Fatalf("unexpected loaded feature extractor %s %v", loaded.FeatureType, loaded.FeatureParameters)
+	}
+	found, _ := loaded.Recognize(probe)
+	if found != label {
+		t.Fatalf("expected %s and gets %s after loading", label, found)
+	}
+}
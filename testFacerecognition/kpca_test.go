@@ -0,0 +1,68 @@
+package testFacerecognition
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestKPCA(t *testing.T) {
+	for _, kernel := range []string{model.RBFKernel, model.PolynomialKernel, model.SigmoidKernel} {
+		m := &model.Euclidean{}
+		trainer := model.NewTrainerArgs(model.KPCAFeatureType, 1, 0, m.GetDistance)
+		trainer.MetricName = model.EuclideanMetric
+		trainer.FeatureParameters = model.Parameters{"kernel": kernel}
+		for _, person := range []string{"s1", "s2", "s3", "s4"} {
+			for _, i := range []string{"1", "2", "3", "4"} {
+				path := "faces/" + person + "/" + i + ".pgm"
+				trainer.AddWithSource(model.ToMatrix(path).Vectorize(), person, path)
+			}
+		}
+		trainer.Train()
+		if !trainer.Trained() {
+			t.Fatalf("KPCA %s is not trained", kernel)
+		}
+		if dim := trainer.Model[0].Matrix.M; dim != 12 {
+			t.Fatalf("KPCA %s expected 12 components (n - c) and gets %d", kernel, dim)
+		}
+		// the projections of the training images are centered
+		for c := 0; c < trainer.Model[0].Matrix.M; c++ {
+			mean := 0.
+			for _, p := range trainer.Model {
+				mean += p.Matrix.A[c][0]
+			}
+			if math.Abs(mean/float64(len(trainer.Model))) > 1e-6 {
+				t.Fatalf("KPCA %s projections are not centered on component %d : %f", kernel, c, mean)
+			}
+		}
+		if kernel == model.SigmoidKernel {
+			continue
+		}
+		probe := model.ToMatrix("faces/s3/8.pgm").Vectorize()
+		label, distance := trainer.Recognize(probe)
+		if label != "s3" {
+			t.Fatalf("KPCA %s expected s3 and gets %s", kernel, label)
+		}
+
+		path := filepath.Join(t.TempDir(), "trained_model.json")
+		if err := trainer.Save(path); err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		loaded, err := model.LoadTrainer(path)
+		if err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		found, foundDistance := loaded.Recognize(probe)
+		if found != label || math.Abs(foundDistance-distance) > 1e-9*distance {
+			t.Fatalf("KPCA %s expected %s (%f) and gets %s (%f) after loading", kernel, label, distance, found, foundDistance)
+		}
+	}
+	if _, err := model.NewFeatureExtractor(model.KPCAFeatureType, model.Parameters{"kernel": "linear"}); err == nil {
+		t.Fatal("expected an error for an unknown kernel")
+	}
+	if _, err := model.NewFeatureExtractor(model.KPCAFeatureType, model.Parameters{"degree": "0"}); err == nil {
+		t.Fatal("expected an error for a degree of 0")
+	}
+}
@@ -0,0 +1,97 @@
+package testFacerecognition
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestLBPH(t *testing.T) {
+	trainer := model.NewTrainerArgs(model.LBPHFeatureType, 1, 0, nil)
+	trainer.Width, trainer.Height = 92, 112
+	trainer.RetrainPolicy = model.RetrainPolicy{MaxPending: 1}
+	for _, person := range []string{"s1", "s2", "s3", "s4"} {
+		for i := 1; i <= 4; i++ {
+			path := fmt.Sprintf("faces/%s/%d.pgm", person, i)
+			trainer.AddWithSource(model.ToMatrix(path).Vectorize(), person, path)
+		}
+	}
+	trainer.Train()
+	if !trainer.Trained() {
+		t.Fatal("LBPH is not trained")
+	}
+	if trainer.MetricName != model.ChiSquareMetric {
+		t.Fatalf("expected the %s metric by default and gets %s", model.ChiSquareMetric, trainer.MetricName)
+	}
+	// 8 x 8 cells of 59 bins for 8 neighbors with uniform patterns
+	if dim := trainer.Model[0].Matrix.M; dim != 8*8*59 {
+		t.Fatalf("expected %d bins and gets %d", 8*8*59, dim)
+	}
+	for _, person := range []string{"s1", "s2", "s3", "s4"} {
+		label, _ := trainer.Recognize(model.ToMatrix("faces/" + person + "/8.pgm").Vectorize())
+		if label != person {
+			t.Fatalf("expected %s and gets %s", person, label)
+		}
+	}
+
+	// the enrolled images never require a retraining
+	extractor := trainer.Extractor
+	for i := 1; i <= 4; i++ {
+		path := fmt.Sprintf("faces/s5/%d.pgm", i)
+		if err := trainer.Enroll(model.ToMatrix(path).Vectorize(), "s5", path); err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+	}
+	trainer.WaitRetrain()
+	if trainer.Extractor != extractor {
+		t.Fatal("expected no retraining of LBPH")
+	}
+	probe := model.ToMatrix("faces/s5/8.pgm").Vectorize()
+	label, distance := trainer.Recognize(probe)
+	if label != "s5" {
+		t.Fatalf("expected s5 and gets %s", label)
+	}
+	if err := trainer.Enroll(algorithm.NewMatrix(10, 1), "s5", ""); err == nil {
+		t.Fatal("expected an error while enrolling an image of another dimension")
+	}
+
+	path := filepath.Join(t.TempDir(), "trained_model.json")
+	if err := trainer.Save(path); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	loaded, err := model.LoadTrainer(path)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	found, foundDistance := loaded.Recognize(probe)
+	if found != label || foundDistance != distance {
+		t.Fatalf("expected %s (%f) and gets %s (%f) after loading", label, distance, found, foundDistance)
+	}
+}
+
+func TestLBPHParameters(t *testing.T) {
+	for _, p := range []model.Parameters{{"radius": "0"}, {"neighbors": "32"}, {"grid_x": "0"}, {"uniform": "maybe"}} {
+		if _, err := model.NewFeatureExtractor(model.LBPHFeatureType, p); err == nil {
+			t.Fatalf("expected an error for the parameters %v", p)
+		}
+	}
+	// without the image size the extractor cannot be fitted
+	trainer := model.NewTrainerArgs(model.LBPHFeatureType, 1, 0, nil)
+	trainer.Add(model.ToMatrix("faces/s1/1.pgm").Vectorize(), "s1")
+	trainer.Add(model.ToMatrix("faces/s2/1.pgm").Vectorize(), "s2")
+	trainer.Train()
+	if trainer.Trained() {
+		t.Fatal("expected LBPH not trained without the image size")
+	}
+	trainer.FeatureParameters = model.Parameters{"width": "92", "height": "112", "uniform": "false", "grid_x": "4", "grid_y": "4"}
+	trainer.Train()
+	if !trainer.Trained() {
+		t.Fatal("expected LBPH trained with the image size in its parameters")
+	}
+	if dim := trainer.Model[0].Matrix.M; dim != 4*4*256 {
+		t.Fatalf("expected %d bins and gets %d", 4*4*256, dim)
+	}
+}
@@ -0,0 +1,134 @@
+package testFacerecognition
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestLDAModes(t *testing.T) {
+	persons := []string{"s1", "s2", "s3", "s4", "s5"}
+	for _, images := range []int{1, 2, 3} {
+		for _, mode := range []string{model.FisherLDA, model.RegularizedLDA, model.DirectLDA} {
+			extractor, err := model.NewFeatureExtractor(model.LDAFeatureType, model.Parameters{"mode": mode})
+			if err != nil {
+				t.Fatalf("this error was not expected %v", err)
+			}
+			trainingSet, labels := faces(persons, 1, images)
+			err = extractor.Fit(trainingSet, labels)
+			if mode == model.FisherLDA && images == 1 {
+				// the within class scatter is null with one image per identity
+				if err == nil {
+					t.Fatal("expected an error for the fisher mode with one image per identity")
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("LDA %s with %d images per identity : this error was not expected %v", mode, images, err)
+			}
+			if dim := extractor.Project(trainingSet[0]).M; dim != len(persons)-1 {
+				t.Fatalf("LDA %s expected %d components and gets %d", mode, len(persons)-1, dim)
+			}
+
+			trainer := model.NewTrainerArgs(model.LDAFeatureType, 1, 0, nil)
+			trainer.MetricName = model.EuclideanMetric
+			trainer.FeatureParameters = model.Parameters{"mode": mode}
+			for i := range trainingSet {
+				trainer.Add(trainingSet[i], labels[i])
+			}
+			trainer.Train()
+			if !trainer.Trained() {
+				t.Fatalf("LDA %s with %d images per identity is not trained", mode, images)
+			}
+			probes, probeLabels := faces(persons, 9, 10)
+			correct := 0
+			for i := range probes {
+				if label, _ := trainer.Recognize(probes[i]); label == probeLabels[i] {
+					correct++
+				}
+			}
+			if correct < len(probes)/2 {
+				t.Fatalf("LDA %s with %d images per identity recognizes %d probes of %d", mode, images, correct, len(probes))
+			}
+		}
+	}
+	if _, err := model.NewFeatureExtractor(model.LDAFeatureType, model.Parameters{"shrinkage": "2"}); err == nil {
+		t.Fatal("expected an error for a shrinkage greater than 1")
+	}
+	if _, err := model.NewFeatureExtractor(model.LDAFeatureType, model.Parameters{"mode": "pseudo"}); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+}
+
+func TestLDAErrors(t *testing.T) {
+	extractor, err := model.NewFeatureExtractor(model.LDAFeatureType, model.Parameters{"mode": model.RegularizedLDA})
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	trainingSet, labels := faces([]string{"s1"}, 1, 3)
+	if err := extractor.Fit(trainingSet, labels); err == nil {
+		t.Fatal("expected an error with a single identity")
+	}
+	if lda := model.NewLDA(trainingSet, labels, 2); lda.FeatureExtraction.W != nil {
+		t.Fatal("expected an empty feature extraction with a single identity")
+	}
+}
+
+func TestLDAScatters(t *testing.T) {
+	// two classes of two points, of means (2, 3) and (6, 1), the total mean is
+	// (4, 2)
+	points := [][]float64{{1, 2}, {3, 4}, {5, 0}, {7, 2}}
+	labels := []string{"a", "a", "b", "b"}
+	projected := make([]*model.ProjectedTrainingMatrix, len(points))
+	for i, p := range points {
+		m, _ := algorithm.NewMatrixWithArrays([][]float64{{p[0]}, {p[1]}})
+		projected[i] = model.NewProjectedTrainingMatrix(m, labels[i])
+	}
+	sw, sb := (&model.LDA{}).Scatters(projected, 2)
+	// each point is at (-1, -1) or (1, 1) of the mean of its class
+	expectedSw, _ := algorithm.NewMatrixWithArrays([][]float64{{4, 4}, {4, 4}})
+	// the means of the classes are at (-2, 1) and (2, -1) of the total mean,
+	// weighted by the 2 points of each class
+	expectedSb, _ := algorithm.NewMatrixWithArrays([][]float64{{16, -8}, {-8, 4}})
+	sameMatrix(t, "Sw", expectedSw, sw)
+	sameMatrix(t, "Sb", expectedSb, sb)
+
+	// the direct LDA keeps the direction (2, -1) of Sb, of eigenvalue 20, and
+	// weights it by the inverse square root of its within class scatter
+	// (2, -1) Sw (2, -1)^T / (5 * 20) = 0.04 : the projections have a within
+	// class scatter of 1 and a between class scatter of 1 / 0.04
+	extractor, err := model.NewFeatureExtractor(model.LDAFeatureType, model.Parameters{"mode": model.DirectLDA, "components": "2"})
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	trainingSet := make([]*algorithm.Matrix, len(points))
+	for i := range points {
+		trainingSet[i] = projected[i].Matrix
+	}
+	if err := extractor.Fit(trainingSet, labels); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	for i := range points {
+		projected[i] = model.NewProjectedTrainingMatrix(extractor.Project(trainingSet[i]), labels[i])
+	}
+	sw, sb = (&model.LDA{}).Scatters(projected, 1)
+	if math.Abs(sw.A[0][0]-1) > 1e-6 || math.Abs(sb.A[0][0]-25) > 1e-6 {
+		t.Fatalf("expected the direct LDA scatters 1 and 25 and gets %f and %f", sw.A[0][0], sb.A[0][0])
+	}
+}
+
+// faces returns the images first to last of the persons and their labels.
+func faces(persons []string, first, last int) ([]*algorithm.Matrix, []string) {
+	images := make([]*algorithm.Matrix, 0)
+	labels := make([]string, 0)
+	for _, person := range persons {
+		for i := first; i <= last; i++ {
+			images = append(images, model.ToMatrix(fmt.Sprintf("faces/%s/%d.pgm", person, i)).Vectorize())
+			labels = append(labels, person)
+		}
+	}
+	return images, labels
+}
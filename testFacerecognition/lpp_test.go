@@ -0,0 +1,99 @@
+package testFacerecognition
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestNearestNeighborGraph(t *testing.T) {
+	// points on a line at 0, 1, 3 and 7 : the nearest neighbor of 0 is 1, of 1
+	// is 0, of 3 is 1 and of 7 is 3, then 1
+	points := []*algorithm.Matrix{vector(0), vector(1), vector(3), vector(7)}
+	labels := []string{"a", "a", "b", "b"}
+	e := math.Exp
+	for _, tc := range []struct {
+		name       string
+		k          int
+		t          float64
+		supervised bool
+		expected   [][]float64
+	}{
+		{"binary", 1, 0, false, [][]float64{{0, 1, 0, 0}, {1, 0, 1, 0}, {0, 1, 0, 1}, {0, 0, 1, 0}}},
+		{"heat kernel", 1, 2, false, [][]float64{{0, e(-0.5), 0, 0}, {e(-0.5), 0, e(-2), 0}, {0, e(-2), 0, e(-8)}, {0, 0, e(-8), 0}}},
+		{"two neighbors", 2, 0, false, [][]float64{{0, 1, 1, 0}, {1, 0, 1, 1}, {1, 1, 0, 1}, {0, 1, 1, 0}}},
+		{"supervised", 3, 0, true, [][]float64{{0, 1, 0, 0}, {1, 0, 0, 0}, {0, 0, 0, 1}, {0, 0, 1, 0}}},
+	} {
+		s := model.NearestNeighborGraph(points, labels, tc.k, tc.t, tc.supervised)
+		for i := range tc.expected {
+			for j := range tc.expected[i] {
+				if math.Abs(s.A[i][j]-tc.expected[i][j]) > 1e-12 {
+					t.Fatalf("%s graph expected weight %f between %d and %d and gets %f", tc.name, tc.expected[i][j], i, j, s.A[i][j])
+				}
+			}
+		}
+	}
+}
+
+func TestLPPDirection(t *testing.T) {
+	// two identities at x = 0 and x = 10 varying along y : the supervised graph
+	// joins the images of the same identity, X L X^T = [[0, 0], [0, 2]] so the
+	// first direction of the LPP is the x axis, with an eigenvalue of 0
+	points := []*algorithm.Matrix{vector(0, 0), vector(0, 1), vector(10, 0), vector(10, 1)}
+	labels := []string{"a", "a", "b", "b"}
+	extractor, err := model.NewFeatureExtractor(model.LPPFeatureType, model.Parameters{"components": "2", "neighbors": "1", "supervised": "true"})
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if err := extractor.Fit(points, labels); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	w := extractor.(model.LinearFeatureExtractor).Extraction().W
+	if math.Abs(w.A[1][0]) > 1e-9*math.Abs(w.A[0][0]) {
+		t.Fatalf("expected the first LPP direction along x and gets (%f, %f)", w.A[0][0], w.A[1][0])
+	}
+	// the images of an identity have the same first coordinate
+	a, b := extractor.Project(points[0]), extractor.Project(points[1])
+	if math.Abs(a.A[0][0]-b.A[0][0]) > 1e-9 {
+		t.Fatalf("expected the same first coordinate and gets %f and %f", a.A[0][0], b.A[0][0])
+	}
+}
+
+func TestLPPRecognition(t *testing.T) {
+	persons := []string{"s1", "s2", "s3", "s4", "s5"}
+	for _, parameters := range []model.Parameters{
+		{},
+		{"neighbors": "5", "t": "1e7"},
+		{"neighbors": "4", "supervised": "true"},
+	} {
+		trainer := model.NewTrainerArgs(model.LPPFeatureType, 1, 0, nil)
+		trainer.MetricName = model.EuclideanMetric
+		trainer.FeatureParameters = parameters
+		trainingSet, labels := faces(persons, 1, 5)
+		for i := range trainingSet {
+			trainer.Add(trainingSet[i], labels[i])
+		}
+		trainer.Train()
+		if !trainer.Trained() {
+			t.Fatalf("LPP %v is not trained", parameters)
+		}
+		probes, probeLabels := faces(persons, 9, 10)
+		correct := 0
+		for i := range probes {
+			if label, _ := trainer.Recognize(probes[i]); label == probeLabels[i] {
+				correct++
+			}
+		}
+		if correct < len(probes)/2 {
+			t.Fatalf("LPP %v recognizes %d probes of %d", parameters, correct, len(probes))
+		}
+	}
+	if _, err := model.NewFeatureExtractor(model.LPPFeatureType, model.Parameters{"neighbors": "0"}); err == nil {
+		t.Fatal("expected an error for 0 neighbors")
+	}
+	if _, err := model.NewFeatureExtractor(model.LPPFeatureType, model.Parameters{"t": "-1"}); err == nil {
+		t.Fatal("expected an error for a negative t")
+	}
+}
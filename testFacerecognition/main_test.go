@@ -0,0 +1,96 @@
+package testFacerecognition
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+// TestMain runs the tests on a copy of the library of Data in a temporary
+// directory, the tests never write in Data.
+func TestMain(m *testing.M) {
+	basePath, err := os.MkdirTemp("", "facerecognition")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot create the test directory : %v\n", err)
+		os.Exit(1)
+	}
+	configFile, err := copyTestLibrary(basePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot copy the test library : %v\n", err)
+		os.RemoveAll(basePath)
+		os.Exit(1)
+	}
+	model.SetAndLoad(configFile)
+	fl := model.NewFaceRecognitionLib()
+	for i := 1; i < 41; i++ {
+		key := fmt.Sprintf("s%d.train", i)
+		firstname := fmt.Sprintf("s%d", i)
+		fl.Items[key] = &model.FaceRecognitionItem{User: model.User{FirstName: firstname, LastName: "train"}}
+	}
+	fl.Save()
+
+	code := m.Run()
+	os.RemoveAll(basePath)
+	os.Exit(code)
+}
+
+// copyTestLibrary copies the training faces of Data in basePath and writes
+// there the configuration of the tests using it, returns the configuration
+// file.
+func copyTestLibrary(basePath string) (string, error) {
+	content, err := os.ReadFile("config.json")
+	if err != nil {
+		return "", err
+	}
+	conf := &model.Config{}
+	if err := json.Unmarshal(content, conf); err != nil {
+		return "", err
+	}
+	source := conf.FaceRecognitionBasePath
+	conf.FaceRecognitionBasePath = basePath
+	directories, err := filepath.Glob(filepath.Join(source, "*.train"))
+	if err != nil {
+		return "", err
+	}
+	for _, directory := range directories {
+		files, err := os.ReadDir(directory)
+		if err != nil {
+			return "", err
+		}
+		destination := filepath.Join(basePath, filepath.Base(directory))
+		if err := os.MkdirAll(destination, os.ModePerm); err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			if err := copyFile(filepath.Join(directory, file.Name()), filepath.Join(destination, file.Name())); err != nil {
+				return "", err
+			}
+		}
+	}
+	configFile := filepath.Join(basePath, "config.json")
+	content, err = json.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+	return configFile, os.WriteFile(configFile, content, 0644)
+}
+
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
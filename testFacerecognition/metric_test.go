@@ -0,0 +1,178 @@
+package testFacerecognition
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func vector(values ...float64) *algorithm.Matrix {
+	m := algorithm.NewMatrix(len(values), 1)
+	for i, v := range values {
+		m.A[i][0] = v
+	}
+	return m
+}
+
+func TestMetrics(t *testing.T) {
+	a, b := vector(1, 2, 3), vector(2, 0, 3)
+	expected := map[string]float64{
+		model.L1Metric:        3,
+		model.EuclideanMetric: math.Sqrt(5),
+		model.ChebyshevMetric: 2,
+		model.ChiSquareMetric: 1./3. + 4./2.,
+	}
+	for name, value := range expected {
+		m, err := model.NewMetric(name)
+		if err != nil {
+			t.Fatalf("cannot create metric %s : %v", name, err)
+		}
+		if m.Name() != name {
+			t.Fatalf("expected metric %s and gets %s", name, m.Name())
+		}
+		if d := m.GetDistance(a, b); math.Abs(d-value) > 1e-9 {
+			t.Fatalf("expected %s distance %f and gets %f", name, value, d)
+		}
+	}
+	if _, err := model.NewMetric("unknown"); err == nil {
+		t.Fatal("expected an error for an unknown metric")
+	}
+	if err := model.RegisterMetric(model.L1Metric, func() model.Metric { return &model.L1{} }); err == nil {
+		t.Fatal("expected an error while registering L1 twice")
+	}
+
+	// the variance of the first coordinate is 4 and of the second 1
+	m := &model.Mahalanobis{}
+	if err := m.Fit([]*algorithm.Matrix{vector(-2, 1), vector(2, -1), vector(0, 0)}, []string{"a", "b", "c"}); err != nil {
+		t.Fatalf("cannot fit Mahalanobis : %v", err)
+	}
+	if d := m.GetDistance(vector(0, 0), vector(2, 1)); math.Abs(d-math.Sqrt(4./4.+1./1.)) > 1e-9 {
+		t.Fatalf("unexpected Mahalanobis distance %f", d)
+	}
+	w := &model.WeightedAngle{}
+	if d := w.GetDistance(vector(1, 1), vector(2, 2)); math.Abs(d) > 1e-9 {
+		t.Fatalf("expected a weighted angle of 0 for colinear vectors and gets %f", d)
+	}
+}
+
+func TestTrainerFittedMetric(t *testing.T) {
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 0, nil)
+	trainer.MetricName = model.MahalanobisMetric
+	for _, person := range []string{"s1", "s2", "s3"} {
+		for _, i := range []string{"1", "2", "3", "4"} {
+			trainer.Add(model.ToMatrix("faces/"+person+"/"+i+".pgm").Vectorize(), person)
+		}
+	}
+	trainer.Train()
+	if _, ok := trainer.DistanceMetric.(*model.Mahalanobis); !ok {
+		t.Fatalf("expected a Mahalanobis metric and gets %T", trainer.DistanceMetric)
+	}
+	probe := model.ToMatrix("faces/s3/6.pgm").Vectorize()
+	label, distance := trainer.Recognize(probe)
+	if label != "s3" {
+		t.Fatalf("expected s3 and gets %s", label)
+	}
+
+	path := filepath.Join(t.TempDir(), "trained_model.json")
+	if err := trainer.Save(path); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	loaded, err := model.LoadTrainer(path)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	found, foundDistance := loaded.Recognize(probe)
+	if found != label || math.Abs(foundDistance-distance) > 1e-9 {
+		t.Fatalf("expected %s (%f) and gets %s (%f) after loading", label, distance, found, foundDistance)
+	}
+
+	euclidean, err := trainer.WithMetric(model.EuclideanMetric)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if euclidean.MetricName != model.EuclideanMetric || trainer.MetricName != model.MahalanobisMetric {
+		t.Fatalf("unexpected metrics %s and %s", euclidean.MetricName, trainer.MetricName)
+	}
+	if found, _ := euclidean.Recognize(probe); found != "s3" {
+		t.Fatalf("expected s3 with the euclidean distance and gets %s", found)
+	}
+	// the trainer of a metric is built once until the model changes
+	if again, _ := trainer.WithMetric(model.EuclideanMetric); again != euclidean {
+		t.Fatal("expected the same euclidean trainer for the second request")
+	}
+	if err := trainer.Enroll(model.ToMatrix("faces/s3/9.pgm").Vectorize(), "s3", ""); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	enrolled, _ := trainer.WithMetric(model.EuclideanMetric)
+	if enrolled == euclidean || len(enrolled.Model) != len(euclidean.Model)+1 {
+		t.Fatalf("expected a euclidean trainer with the enrolled image and gets %d projections", len(enrolled.Model))
+	}
+	if err := trainer.SetMetric("unknown"); err == nil {
+		t.Fatal("expected an error for an unknown metric")
+	}
+}
+
+func TestEigenvalueMetrics(t *testing.T) {
+	a, b := vector(2, 1), vector(2, -1)
+	// whitened by the standard deviations 2 and 1, a and b are orthogonal
+	w := &model.WhitenedCosine{}
+	w.SetEigenvalues([]float64{4, 1})
+	if d := w.GetDistance(a, b); math.Abs(d-1) > 1e-9 {
+		t.Fatalf("expected a whitened cosine distance of 1 and gets %f", d)
+	}
+	ma := &model.MahalanobisAngle{}
+	ma.SetEigenvalues([]float64{4, 1})
+	if d := ma.GetDistance(a, b); math.Abs(d-math.Pi/2) > 1e-9 {
+		t.Fatalf("expected a Mahalanobis angle of pi/2 and gets %f", d)
+	}
+	if d := ma.GetDistance(a, vector(4, 2)); math.Abs(d) > 1e-6 {
+		t.Fatalf("expected a Mahalanobis angle of 0 for colinear vectors and gets %f", d)
+	}
+
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 5, nil)
+	trainer.MetricName = model.MahalanobisMetric
+	for _, person := range []string{"s1", "s2", "s3"} {
+		for _, i := range []string{"1", "2", "3"} {
+			trainer.Add(model.ToMatrix("faces/"+person+"/"+i+".pgm").Vectorize(), person)
+		}
+	}
+	trainer.Train()
+	eigenvalues := trainer.FeatureExtraction.Eigenvalues
+	if len(eigenvalues) != 5 {
+		t.Fatalf("expected 5 eigenvalues and gets %d", len(eigenvalues))
+	}
+	for i := range eigenvalues {
+		if eigenvalues[i] <= 0 || (i > 0 && eigenvalues[i] > eigenvalues[i-1]) {
+			t.Fatalf("expected positive decreasing eigenvalues and gets %v", eigenvalues)
+		}
+		// the eigenvalue is the variance of the projected training images
+		variance := 0.
+		for _, p := range trainer.Model {
+			variance += p.Matrix.A[i][0] * p.Matrix.A[i][0]
+		}
+		variance /= float64(len(trainer.Model) - 1)
+		if math.Abs(variance-eigenvalues[i]) > 1e-6*eigenvalues[i] {
+			t.Fatalf("expected eigenvalue %d equal to the variance %f and gets %f", i, variance, eigenvalues[i])
+		}
+	}
+	p, q := trainer.Model[0].Matrix, trainer.Model[4].Matrix
+	expected := 0.
+	for i := range eigenvalues {
+		expected += (p.A[i][0] - q.A[i][0]) * (p.A[i][0] - q.A[i][0]) / eigenvalues[i]
+	}
+	if d := trainer.Metric(p, q); math.Abs(d-math.Sqrt(expected)) > 1e-9*math.Sqrt(expected) {
+		t.Fatalf("expected Mahalanobis distance %f and gets %f", math.Sqrt(expected), d)
+	}
+	for _, name := range []string{model.WhitenedCosineMetric, model.MahalanobisAngleMetric} {
+		wt, err := trainer.WithMetric(name)
+		if err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		if found, _ := wt.Recognize(model.ToMatrix("faces/s2/7.pgm").Vectorize()); found != "s2" {
+			t.Fatalf("expected s2 with %s and gets %s", name, found)
+		}
+	}
+}
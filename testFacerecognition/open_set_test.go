@@ -0,0 +1,134 @@
+package testFacerecognition
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/model"
+	"github.com/pkg/errors"
+)
+
+var openSetPersons = []string{"s1", "s2", "s3", "s4", "s5"}
+
+func newOpenSetTrainer(perIdentity bool, rejectionThreshold float64) *model.Trainer {
+	m := &model.L1{}
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 10, m.GetDistance)
+	trainer.MetricName = model.L1Metric
+	trainer.OpenSet = true
+	trainer.PerIdentityThreshold = perIdentity
+	trainer.RejectionThreshold = rejectionThreshold
+	for _, person := range openSetPersons {
+		for i := 1; i <= 5; i++ {
+			trainer.Add(model.ToMatrix(fmt.Sprintf("faces/%s/%d.pgm", person, i)).Vectorize(), person)
+		}
+	}
+	trainer.Train()
+	return trainer
+}
+
+func TestOpenSetRecognition(t *testing.T) {
+	for _, perIdentity := range []bool{false, true} {
+		trainer := newOpenSetTrainer(perIdentity, 0)
+		if trainer.Threshold == nil || trainer.Threshold.Global <= 0 {
+			t.Fatalf("expected a calibrated threshold and gets %v", trainer.Threshold)
+		}
+		for _, person := range openSetPersons {
+			for i := 6; i <= 10; i++ {
+				r := trainer.RecognizeOpenSet(model.ToMatrix(fmt.Sprintf("faces/%s/%d.pgm", person, i)).Vectorize())
+				if !r.Known || r.Label != person {
+					t.Fatalf("expected %s/%d.pgm recognized as %s and gets %+v", person, i, person, r)
+				}
+				if r.Confidence < .5 {
+					t.Fatalf("expected a confidence greater than .5 and gets %+v", r)
+				}
+			}
+		}
+		unknown := 0
+		for _, person := range []string{"s30", "s31", "s32", "s33", "s34"} {
+			for i := 6; i <= 10; i++ {
+				probe := model.ToMatrix(fmt.Sprintf("faces/%s/%d.pgm", person, i)).Vectorize()
+				r := trainer.RecognizeOpenSet(probe)
+				// Recognize rejects the strangers as well, with the confidence
+				if label, score := trainer.Recognize(probe); label != r.Label || score != r.Confidence {
+					t.Fatalf("expected %q with the confidence %f and gets %q %f", r.Label, r.Confidence, label, score)
+				}
+				if !r.Known {
+					if r.Label != "" || r.Nearest == "" {
+						t.Fatalf("expected an empty label and a nearest person for an unknown and gets %+v", r)
+					}
+					unknown++
+				}
+			}
+		}
+		if unknown == 0 {
+			t.Fatalf("expected strangers to be rejected with per identity threshold %v", perIdentity)
+		}
+	}
+}
+
+func TestOpenSetFixedThreshold(t *testing.T) {
+	trainer := newOpenSetTrainer(false, 1.)
+	if trainer.Threshold == nil || trainer.Threshold.Global != 1. {
+		t.Fatalf("expected a fixed threshold of 1 and gets %v", trainer.Threshold)
+	}
+	r := trainer.RecognizeOpenSet(model.ToMatrix("faces/s1/6.pgm").Vectorize())
+	if r.Known {
+		t.Fatalf("expected unknown with a threshold of 1 and gets %+v", r)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	m := &model.L1{}
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 10, m.GetDistance)
+	trainer.MetricName = model.L1Metric
+	for _, person := range openSetPersons {
+		for i := 1; i <= 5; i++ {
+			trainer.Add(model.ToMatrix(fmt.Sprintf("faces/%s/%d.pgm", person, i)).Vectorize(), person)
+		}
+	}
+	trainer.Train()
+	// the threshold is calibrated by the training, even in closed set
+	threshold := trainer.Threshold
+	if threshold == nil || threshold.Global <= 0 {
+		t.Fatalf("expected a calibrated threshold and gets %v", threshold)
+	}
+
+	probe := model.ToMatrix("faces/s2/6.pgm").Vectorize()
+	v, err := model.Verify(trainer, probe, "s2")
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if !v.Accepted || v.Score < .5 {
+		t.Fatalf("expected s2 accepted and gets %+v", v)
+	}
+	impostor, err := model.Verify(trainer, probe, "s1")
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if impostor.Distance <= v.Distance {
+		t.Fatalf("expected s1 farther than s2 and gets %+v and %+v", impostor, v)
+	}
+	if _, err := model.Verify(trainer, probe, "nobody"); errors.Cause(err) != model.ErrUnknownIdentity {
+		t.Fatalf("expected unknown identity error and gets %v", err)
+	}
+	if trainer.Threshold != threshold || v.Threshold != threshold.Global {
+		t.Fatalf("expected the verification to use the threshold %v and gets %v", threshold, trainer.Threshold)
+	}
+
+	path := filepath.Join(t.TempDir(), "trained_model.json")
+	if err := trainer.Save(path); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	loaded, err := model.LoadTrainer(path)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if loaded.Threshold == nil || loaded.Threshold.Global != threshold.Global {
+		t.Fatalf("expected the threshold %v and gets %v", threshold, loaded.Threshold)
+	}
+	loaded.Threshold = nil
+	if _, err := model.Verify(loaded, probe, "s2"); errors.Cause(err) != model.ErrNoThreshold {
+		t.Fatalf("expected no threshold error and gets %v", err)
+	}
+}
@@ -1,15 +1,15 @@
 package testFacerecognition
 
 import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"testing"
+
 	"github.com/jeromelesaux/facerecognition/algorithm"
 	"github.com/jeromelesaux/facerecognition/model"
-	"testing"
 )
 
-func init() {
-	model.SetAndLoad("../config.json")
-}
-
 func BenchmarkPerformanceRecognition(b *testing.B) {
 	l := model.GetFaceRecognitionLib()
 	tr := l.GetTrainer(model.PCAFeatureType)
@@ -48,3 +48,148 @@ func TestTimesMatrices(t *testing.T) {
 	t.Log(m3m.Tostring())
 
 }
+
+// naiveTimesMatrix is the triple loop product the blocked one is compared to.
+func naiveTimesMatrix(a, b *algorithm.Matrix) *algorithm.Matrix {
+	x := algorithm.NewMatrix(a.M, b.N)
+	for i := 0; i < a.M; i++ {
+		for j := 0; j < b.N; j++ {
+			s := 0.
+			for k := 0; k < a.N; k++ {
+				s += a.A[i][k] * b.A[k][j]
+			}
+			x.A[i][j] = s
+		}
+	}
+	return x
+}
+
+func randomMatrix(r *rand.Rand, m, n int) *algorithm.Matrix {
+	x := algorithm.NewMatrix(m, n)
+	for i := range x.Data {
+		x.Data[i] = r.NormFloat64()
+	}
+	return x
+}
+
+func sameMatrix(t *testing.T, name string, expected, actual *algorithm.Matrix) {
+	if expected.M != actual.M || expected.N != actual.N {
+		t.Fatalf("%s expected %dx%d and gets %dx%d", name, expected.M, expected.N, actual.M, actual.N)
+	}
+	for i := 0; i < expected.M; i++ {
+		for j := 0; j < expected.N; j++ {
+			if expected.A[i][j] != actual.A[i][j] {
+				t.Fatalf("%s expected %v at (%d,%d) and gets %v", name, expected.A[i][j], i, j, actual.A[i][j])
+			}
+		}
+	}
+}
+
+func TestBlockedTimesMatrix(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	// larger than the blocks and than the parallel threshold
+	a, b := randomMatrix(r, 150, 130), randomMatrix(r, 130, 70)
+	sameMatrix(t, "TimesMatrix", naiveTimesMatrix(a, b), a.TimesMatrix(b))
+	sameMatrix(t, "TransposeTimesMatrix", naiveTimesMatrix(b.Transpose(), b), b.TransposeTimesMatrix(b))
+
+	x := algorithm.NewMatrix(150, 70)
+	x.Data[0] = 42
+	if err := a.TimesInto(b, x); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	sameMatrix(t, "TimesInto", naiveTimesMatrix(a, b), x)
+	if err := a.TimesInto(b, algorithm.NewMatrix(70, 150)); err == nil {
+		t.Fatal("expected an error for a product matrix of wrong dimensions")
+	}
+	square := randomMatrix(r, 130, 130)
+	if err := square.TimesInto(square, square); !errors.Is(err, algorithm.ErrAliasedMatrix) {
+		t.Fatalf("expected an error for a product matrix aliasing its operands and gets %v", err)
+	}
+	if err := b.TransposeTimesInto(square.View(0, 129, 0, 69), square.View(60, 129, 60, 129)); !errors.Is(err, algorithm.ErrAliasedMatrix) {
+		t.Fatalf("expected an error for a product matrix overlapping a view and gets %v", err)
+	}
+	if err := b.TransposeTimesInto(square.View(0, 129, 0, 69), algorithm.NewMatrix(70, 70)); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+
+	// views share the elements and have the stride of the matrix
+	v := a.View(10, 19, 0, 129)
+	if v.M != 10 || v.N != 130 || v.Stride != a.N {
+		t.Fatalf("unexpected view %dx%d of stride %d", v.M, v.N, v.Stride)
+	}
+	v = a.View(10, 19, 5, 24)
+	sameMatrix(t, "View", a.GetMatrix3(10, 19, 5, 24), v)
+	c := randomMatrix(r, 20, 30)
+	sameMatrix(t, "TimesMatrix of a view", naiveTimesMatrix(v, c), v.TimesMatrix(c))
+	v.A[0][0] = 7
+	if a.A[10][5] != 7 {
+		t.Fatal("expected the view to share the elements of the matrix")
+	}
+
+	d := a.Copy()
+	if err := d.MinusInPlace(a); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	sameMatrix(t, "MinusInPlace", algorithm.NewMatrix(150, 130), d)
+	if err := d.MinusInPlace(b); err == nil {
+		t.Fatal("expected an error for matrices of different dimensions")
+	}
+}
+
+func TestMatrixJSON(t *testing.T) {
+	m, _ := algorithm.NewMatrixWithArrays([][]float64{{1, 2, 3}, {4, 5, 6}})
+	data, err := json.Marshal(m.View(0, 1, 1, 2))
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if string(data) != `{"A":[[2,3],[5,6]],"M":2,"N":2}` {
+		t.Fatalf("unexpected json %s", data)
+	}
+	decoded := &algorithm.Matrix{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	sameMatrix(t, "json", m.GetMatrix3(0, 1, 1, 2), decoded)
+	if decoded.Stride != 2 || len(decoded.Data) != 4 {
+		t.Fatalf("expected a contiguous matrix and gets stride %d and %d elements", decoded.Stride, len(decoded.Data))
+	}
+	if err := json.Unmarshal([]byte(`{"A":[[1,2],[3]],"M":2,"N":2}`), decoded); err == nil {
+		t.Fatal("expected an error for rows of different lengths")
+	}
+}
+
+// orlData returns the 10304 x 100 matrix of the 10 images of 10 persons.
+func orlData() *algorithm.Matrix {
+	images, _ := faces([]string{"s1", "s2", "s3", "s4", "s5", "s6", "s7", "s8", "s9", "s10"}, 1, 10)
+	x := algorithm.NewMatrix(images[0].M, len(images))
+	for i := range images {
+		x.SetMatrix(0, x.M-1, i, i, images[i])
+	}
+	return x
+}
+
+func BenchmarkNaiveTimesMatrix(b *testing.B) {
+	x := orlData()
+	xt := x.Transpose()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		naiveTimesMatrix(xt, x)
+	}
+}
+
+func BenchmarkTimesMatrix(b *testing.B) {
+	x := orlData()
+	xt := x.Transpose()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		xt.TimesMatrix(x)
+	}
+}
+
+func BenchmarkTransposeTimesMatrix(b *testing.B) {
+	x := orlData()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.TransposeTimesMatrix(x)
+	}
+}
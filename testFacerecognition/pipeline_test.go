@@ -0,0 +1,92 @@
+package testFacerecognition
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	pnm "github.com/jbuchbinder/gopnm"
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/model"
+	"github.com/nfnt/resize"
+)
+
+func decodeFile(t *testing.T, path string) image.Image {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("expected image and gets error %v", err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatalf("cannot decode %s : %v", path, err)
+	}
+	return img
+}
+
+// encodePgm writes img in the PGM format in path.
+func encodePgm(t *testing.T, img image.Image, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	defer f.Close()
+	if err := pnm.Encode(f, img, pnm.PGM); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+}
+
+func TestVectorizeInMemory(t *testing.T) {
+	lib := model.GetFaceRecognitionLib()
+	img := decodeFile(t, "images/barack.png")
+
+	// the former round trip : raw PGM file, resized and read again
+	raw := filepath.Join(t.TempDir(), "raw.pgm")
+	encodePgm(t, img, raw)
+	encodePgm(t, resize.Resize(uint(lib.Width), uint(lib.Height), decodeFile(t, raw), resize.Lanczos3), raw)
+	sameMatrix(t, "Vectorize", model.ToMatrix(raw).Vectorize(), lib.Vectorize(img))
+}
+
+func TestFacesConcurrently(t *testing.T) {
+	lib := model.GetFaceRecognitionLib()
+	img := decodeFile(t, "images/barack.png")
+	expected := lib.Faces(img)
+	if len(expected) == 0 {
+		t.Fatal("expected len faces > to 0")
+	}
+	for _, f := range expected {
+		if f.Image.Bounds() != image.Rect(0, 0, lib.Width, lib.Height) {
+			t.Fatalf("expected a face of %dx%d and gets %v", lib.Width, lib.Height, f.Image.Bounds())
+		}
+	}
+
+	var wc sync.WaitGroup
+	vectors := make([][]*algorithm.Matrix, 4)
+	files := make([][]string, len(vectors))
+	for i := range vectors {
+		wc.Add(1)
+		go func(index int) {
+			defer wc.Done()
+			vectors[index], files[index] = lib.FindFace(&img)
+		}(i)
+	}
+	wc.Wait()
+
+	seen := make(map[string]bool)
+	for i, names := range files {
+		if len(vectors[i]) != len(expected) || len(names) != len(expected) {
+			t.Fatalf("expected %d faces and gets %d vectors and %d files", len(expected), len(vectors[i]), len(names))
+		}
+		for j, name := range names {
+			sameMatrix(t, "FindFace", expected[j].Vector(), vectors[i][j])
+			sameMatrix(t, "FindFace file", expected[j].Vector(), model.ToMatrix(name).Vectorize())
+			if seen[name] {
+				t.Fatalf("the file %s is shared by two calls", name)
+			}
+			seen[name] = true
+			os.Remove(name)
+		}
+	}
+}
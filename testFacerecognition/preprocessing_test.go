@@ -0,0 +1,132 @@
+package testFacerecognition
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/algorithm"
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+// scaled returns the image m with its gray levels multiplied by factor.
+func scaled(m *algorithm.Matrix, factor float64) *algorithm.Matrix {
+	s := m.Copy()
+	for i := range s.Data {
+		s.Data[i] *= factor
+	}
+	return s
+}
+
+func TestPreprocessingSteps(t *testing.T) {
+	images, _ := faces([]string{"s1"}, 1, 1)
+	for _, name := range model.PreprocessingSteps() {
+		p, err := model.Preprocessing{{Name: name}}.Normalize()
+		if err != nil {
+			t.Fatalf("%s : this error was not expected %v", name, err)
+		}
+		preprocessed, err := p.Apply(images[0], 92, 112)
+		if err != nil {
+			t.Fatalf("%s : this error was not expected %v", name, err)
+		}
+		if preprocessed.M != images[0].M || preprocessed.N != 1 {
+			t.Fatalf("%s : expected a vector of %d and gets %dx%d", name, images[0].M, preprocessed.M, preprocessed.N)
+		}
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, v := range preprocessed.Data {
+			low, high = math.Min(low, v), math.Max(high, v)
+		}
+		if math.IsNaN(low) || low < 0 || high > 255 || high-low < 64 {
+			t.Fatalf("%s : expected gray levels spread between 0 and 255 and gets [%f, %f]", name, low, high)
+		}
+	}
+
+	identity, _ := model.Preprocessing{{Name: model.GammaCorrection, Parameters: model.Parameters{"gamma": "1"}}}.Apply(images[0], 92, 112)
+	sameMatrix(t, "gamma 1", images[0], identity)
+
+	// the Tan-Triggs normalization does not depend on the brightness
+	p, _ := model.Preprocessing{{Name: model.TanTriggs}}.Normalize()
+	reference, _ := p.Apply(images[0], 92, 112)
+	darker, _ := p.Apply(scaled(images[0], 0.3), 92, 112)
+	for i := range reference.Data {
+		if math.Abs(reference.Data[i]-darker.Data[i]) > 1e-6 {
+			t.Fatalf("expected the same Tan-Triggs normalization of a darker image at %d : %f and %f", i, reference.Data[i], darker.Data[i])
+		}
+	}
+
+	if _, err := p.Apply(images[0], 92, 100); !errors.Is(err, model.ErrDimensionMismatch) {
+		t.Fatalf("expected a dimension mismatch and gets %v", err)
+	}
+	if _, err := (model.Preprocessing{{Name: "sharpen"}}).Apply(images[0], 92, 112); err == nil {
+		t.Fatal("expected an error for an unknown step")
+	}
+	for _, invalid := range []string{"sharpen", "gamma:gamma=-1", "dog:sigma0=3:sigma1=2", "clahe:size=4", "clahe:tiles"} {
+		if _, err := model.ParsePreprocessing(invalid); err == nil {
+			t.Fatalf("expected an error for the preprocessing %s", invalid)
+		}
+	}
+	parsed, err := model.ParsePreprocessing("histeq, tantriggs:tau=5")
+	if err != nil || len(parsed) != 2 || parsed[1].Parameters["tau"] != "5" || parsed[1].Parameters["gamma"] != "0.2" {
+		t.Fatalf("expected histeq and tantriggs with tau 5 and gets %v, %v", parsed, err)
+	}
+	if again, err := model.ParsePreprocessing(parsed.String()); err != nil || again.String() != parsed.String() {
+		t.Fatalf("expected %s and gets %s, %v", parsed, again, err)
+	}
+}
+
+func TestTrainerPreprocessing(t *testing.T) {
+	persons := []string{"s1", "s2", "s3", "s4", "s5"}
+	trainingSet, labels := faces(persons, 1, 7)
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 0, nil)
+	trainer.MetricName = model.CosineDissimilarityMetric
+	trainer.Width, trainer.Height = 92, 112
+	trainer.Preprocessing = model.Preprocessing{{Name: model.TanTriggs}}
+	for i := range trainingSet {
+		trainer.Add(trainingSet[i], labels[i])
+	}
+	if err := trainer.Train(); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if trainer.Preprocessing[0].Parameters["tau"] != "10" {
+		t.Fatalf("expected the preprocessing parameters set by the training and gets %v", trainer.Preprocessing[0].Parameters)
+	}
+
+	path := filepath.Join(t.TempDir(), "trained_model.json")
+	if err := trainer.Save(path); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	loaded, err := model.LoadTrainer(path)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if loaded.Preprocessing.String() != trainer.Preprocessing.String() {
+		t.Fatalf("expected the preprocessing %s and gets %s", trainer.Preprocessing, loaded.Preprocessing)
+	}
+
+	probes, probeLabels := faces(persons, 8, 10)
+	recognized := 0
+	for i, probe := range probes {
+		// the probes are darker than the training images
+		darker := scaled(probe, 0.4)
+		sameMatrix(t, "projection", trainer.Project(darker), loaded.Project(darker))
+		label, _, err := loaded.Identify(darker)
+		if err != nil {
+			t.Fatalf("this error was not expected %v", err)
+		}
+		if expected, _, _ := loaded.Identify(probe); label != expected {
+			t.Fatalf("expected %s for the darker probe %d and gets %s", expected, i, label)
+		}
+		if label == probeLabels[i] {
+			recognized++
+		}
+	}
+	if recognized < 12 {
+		t.Fatalf("expected at least 12 darker probes recognized and gets %d of %d", recognized, len(probes))
+	}
+
+	trainer.Width = 0
+	if err := trainer.Train(); !errors.Is(err, model.ErrDimensionMismatch) {
+		t.Fatalf("expected a dimension mismatch without the size of the images and gets %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package testFacerecognition
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/evaluation"
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestEER(t *testing.T) {
+	s := &evaluation.Scores{
+		Genuine:  []float64{1, 2, 3, 4, 6},
+		Impostor: []float64{5, 7, 8, 9, 10},
+	}
+	points := s.ROC()
+	eer, threshold := evaluation.EER(points)
+	if math.Abs(eer-0.2) > 1e-9 {
+		t.Fatalf("expected EER 0.2 and gets %f (threshold %f)", eer, threshold)
+	}
+	if threshold < 4 || threshold > 6 {
+		t.Fatalf("expected EER threshold between 4 and 6 and gets %f", threshold)
+	}
+	tar, threshold := evaluation.TARAtFAR(points, 1e-3)
+	if tar != 0.8 || threshold != 4 {
+		t.Fatalf("expected TAR 0.8 at threshold 4 and gets %f at %f", tar, threshold)
+	}
+}
+
+func TestAnalyseVerification(t *testing.T) {
+	ds := &evaluation.Dataset{}
+	for _, person := range []string{"s1", "s2", "s3", "s4", "s5"} {
+		for i := 1; i <= 4; i++ {
+			path := fmt.Sprintf("faces/%s/%d.pgm", person, i)
+			ds.Add(model.ToMatrix(path).Vectorize(), person, path)
+		}
+	}
+	opts := evaluation.NewOptions()
+	opts.FeatureTypes = []string{model.PCAFeatureType}
+	opts.Folds = 2
+	reports, err := evaluation.AnalyseVerification(ds, opts)
+	if err != nil {
+		t.Fatalf("cannot analyse verification : %v", err)
+	}
+	if len(reports) != len(opts.Metrics) {
+		t.Fatalf("expected %d reports and gets %d", len(opts.Metrics), len(reports))
+	}
+	for _, r := range reports {
+		// 2 folds of 2 images per person : 5 genuine pairs and 40 impostor pairs per fold
+		if r.Error != "" || r.GenuinePairs != 10 || r.ImpostorPairs != 80 {
+			t.Fatalf("unexpected report %+v", r)
+		}
+		if r.EER < 0 || r.EER > 0.5 {
+			t.Fatalf("%s/%s unexpected EER %f", r.FeatureType, r.Metric, r.EER)
+		}
+		t.Logf("%s/%s EER %.3f TAR@FAR=1e-3 %.3f", r.FeatureType, r.Metric, r.EER, r.TARAtFAR1e3)
+	}
+
+	var csv bytes.Buffer
+	if err := evaluation.WriteROCCSV(&csv, reports[0].Points); err != nil {
+		t.Fatalf("cannot write CSV : %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(csv.String()), "\n")
+	if len(lines) != len(reports[0].Points) || !strings.HasPrefix(lines[0], "threshold,far,frr,tar") {
+		t.Fatalf("unexpected CSV with %d lines for %d points", len(lines), len(reports[0].Points))
+	}
+
+	var plot bytes.Buffer
+	if err := evaluation.PlotROC(&plot, reports, 400, 200); err != nil {
+		t.Fatalf("cannot plot : %v", err)
+	}
+	img, err := png.Decode(&plot)
+	if err != nil {
+		t.Fatalf("cannot decode plot : %v", err)
+	}
+	if img.Bounds().Dx() != 400 || img.Bounds().Dy() != 200 {
+		t.Fatalf("unexpected plot size %v", img.Bounds())
+	}
+}
@@ -0,0 +1,114 @@
+package testFacerecognition
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromelesaux/facerecognition/model"
+)
+
+func TestTrainerSaveAndLoad(t *testing.T) {
+	m := &model.L1{}
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 3, m.GetDistance)
+	trainer.MetricName = model.L1Metric
+	for _, value := range []string{"faces/s2/1.pgm", "faces/s2/2.pgm", "faces/s2/3.pgm"} {
+		trainer.Add(model.ToMatrix(value).Vectorize(), "john")
+	}
+	for _, value := range []string{"faces/s4/1.pgm", "faces/s4/2.pgm", "faces/s4/3.pgm"} {
+		trainer.Add(model.ToMatrix(value).Vectorize(), "smith")
+	}
+	trainer.Train()
+
+	path := filepath.Join(t.TempDir(), "trained_model.json")
+	if err := trainer.Save(path); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	loaded, err := model.LoadTrainer(path)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if len(loaded.Model) != len(trainer.Model) {
+		t.Fatalf("expected %d projections and gets %d", len(trainer.Model), len(loaded.Model))
+	}
+
+	for _, value := range []string{"faces/s2/4.pgm", "faces/s4/4.pgm"} {
+		probe := model.ToMatrix(value).Vectorize()
+		expected, expectedDistance := trainer.Recognize(probe)
+		found, distance := loaded.Recognize(probe)
+		if found != expected || distance != expectedDistance {
+			t.Fatalf("expected %s (%f) and gets %s (%f) for %s", expected, expectedDistance, found, distance, value)
+		}
+	}
+}
+
+func TestLoadTrainerWrongVersion(t *testing.T) {
+	m := &model.L1{}
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 1, m.GetDistance)
+	trainer.MetricName = model.L1Metric
+	trainer.Add(model.ToMatrix("faces/s2/1.pgm").Vectorize(), "john")
+	trainer.Add(model.ToMatrix("faces/s4/1.pgm").Vectorize(), "smith")
+	trainer.Train()
+
+	path := filepath.Join(t.TempDir(), "trained_model.json")
+	version := model.TrainedModelVersion
+	model.TrainedModelVersion = version + 1
+	err := trainer.Save(path)
+	model.TrainedModelVersion = version
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if _, err := model.LoadTrainer(path); err == nil {
+		t.Fatal("expected an error while loading a model with an unknown version")
+	}
+}
+
+func TestLoadTrainerVersion1(t *testing.T) {
+	m := &model.L1{}
+	trainer := model.NewTrainerArgs(model.PCAFeatureType, 1, 3, m.GetDistance)
+	trainer.MetricName = model.L1Metric
+	for _, value := range []string{"faces/s2/1.pgm", "faces/s2/2.pgm", "faces/s4/1.pgm", "faces/s4/2.pgm"} {
+		trainer.Add(model.ToMatrix(value).Vectorize(), filepath.Dir(value))
+	}
+	trainer.Train()
+
+	// version 1 stored the linear feature extraction in the model itself
+	tm := &model.TrainedModel{
+		Version:         1,
+		FeatureType:     trainer.FeatureType,
+		Metric:          trainer.MetricName,
+		K:               trainer.K,
+		NumOfComponents: trainer.NumOfComponents,
+		MeanMatrix:      trainer.FeatureExtraction.MeanMatrix,
+		W:               trainer.FeatureExtraction.W,
+	}
+	for _, p := range trainer.Model {
+		tm.Projections = append(tm.Projections, &model.TrainedModelProjection{Label: p.Label, Matrix: p.Matrix})
+	}
+	path := filepath.Join(t.TempDir(), "trained_model.json")
+	data, err := json.Marshal(tm)
+	if err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+
+	loaded, err := model.LoadTrainer(path)
+	if err != nil {
+		t.Fatalf("cannot load a version 1 model : %v", err)
+	}
+	probe := model.ToMatrix("faces/s4/3.pgm").Vectorize()
+	expected, expectedDistance := trainer.Recognize(probe)
+	found, distance := loaded.Recognize(probe)
+	if found != expected || distance != expectedDistance {
+		t.Fatalf("expected %s (%f) and gets %s (%f)", expected, expectedDistance, found, distance)
+	}
+	if err := loaded.Save(path); err != nil {
+		t.Fatalf("this error was not expected %v", err)
+	}
+	if _, err := model.LoadTrainer(path); err != nil {
+		t.Fatalf("cannot load the migrated model : %v", err)
+	}
+}
@@ -22,14 +22,40 @@ import (
 )
 
 type FaceRecognitionResponse struct {
-	Error            string     `json:"error,omitempty"`
-	User             model.User `json:"user"`
-	Average          string     `json:"average"`
-	FaceDetected     []string   `json:"faces_detected"`
-	PersonRecognized string     `json:"person_recognized"`
-	Distance         float64    `json:"distance"`
+	Error            string                `json:"error,omitempty"`
+	User             model.User            `json:"user"`
+	Average          string                `json:"average"`
+	FaceDetected     []string              `json:"faces_detected"`
+	PersonRecognized string                `json:"person_recognized"`
+	Distance         float64               `json:"distance"`
+	Confidence       float64               `json:"confidence"`
+	Candidates       []CandidateResponse   `json:"candidates"`
+	Recognitions     []RecognitionResponse `json:"recognitions"` // one per face found in the images
 }
 
+// RecognitionResponse is the open set recognition of a face, the person of
+// the response is the most confident one recognized.
+type RecognitionResponse struct {
+	Face       int        `json:"face"` // index of the face found in the image
+	Known      bool       `json:"known"`
+	User       model.User `json:"user"` // the person recognized, the nearest one if unknown
+	Distance   float64    `json:"distance"`
+	Confidence float64    `json:"confidence"`
+}
+
+type CandidateResponse struct {
+	Face     int        `json:"face"` // index of the face found in the image
+	User     model.User `json:"user"`
+	Distance float64    `json:"distance"`
+	Votes    int        `json:"votes"`
+	Score    float64    `json:"score"`
+	Faces    []string   `json:"faces"`
+}
+
+// defaultTopN is the number of candidates returned by Compare if the
+// top query parameter is not set.
+var defaultTopN = 5
+
 type PersonResponse struct {
 	FirstName string   `json:"first_name"`
 	LastName  string   `json:"last_name"`
@@ -54,8 +80,8 @@ var (
 func load() {
 	libload.Do(func() {
 		frlib = model.GetFaceRecognitionLib()
-		t = frlib.GetTrainer("PCA")
-		t.Train()
+		conf := model.GetConfig()
+		t = frlib.LoadOrTrain(conf.GetFeatureType(), conf.FeatureParameters, conf.GetMetric())
 	})
 }
 
@@ -81,6 +107,29 @@ func GetPerson(w http.ResponseWriter, r *http.Request) {
 	sendJson(w, "not found")
 }
 
+// ListFeatureExtractors returns the registered feature extractors and their
+// parameters, the one used by the trainer is selected in the configuration.
+func ListFeatureExtractors(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(200)
+	sendJson(w, model.FeatureExtractors())
+}
+
+func ListMetrics(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(200)
+	sendJson(w, model.Metrics())
+}
+
+// trainerWithMetric returns the trainer comparing the projections with the
+// metric query parameter, the configured one if not set. The trainers of the
+// other metrics are kept by t until it is retrained or enrolls new images.
+func trainerWithMetric(r *http.Request) (*model.Trainer, error) {
+	name := r.URL.Query().Get("metric")
+	if name == "" || name == t.MetricName {
+		return t, nil
+	}
+	return t.WithMetric(name)
+}
+
 func ListPersons(w http.ResponseWriter, r *http.Request) {
 	load()
 	response := NewLibraryResponse()
@@ -104,18 +153,36 @@ func Compare(w http.ResponseWriter, r *http.Request) {
 	load()
 	var err error
 	// user := &model.User{}
-	response := &FaceRecognitionResponse{PersonRecognized: "Not recognized"}
+	response := &FaceRecognitionResponse{PersonRecognized: "Not recognized", Candidates: make([]CandidateResponse, 0), Recognitions: make([]RecognitionResponse, 0)}
 
 	defer func() {
 		w.WriteHeader(200)
 		sendJson(w, response)
 	}()
 
+	t, err := trainerWithMetric(r)
+	if err != nil {
+		response.Error = err.Error()
+		return
+	}
+	topN := defaultTopN
+	if v := r.URL.Query().Get("top"); v != "" {
+		topN, err = strconv.Atoi(v)
+		if err != nil {
+			response.Error = "top parameter must be an integer."
+			return
+		}
+	}
+
 	mr, err := r.MultipartReader()
 	if err != nil {
 		response.Error = err.Error()
 		return
 	}
+	// the most confident recognition of the faces, and its image
+	var best *model.Recognition
+	var bestImage image.Image
+	var bestFaces []*model.Face
 	for {
 		part, err := mr.NextPart()
 		if err == io.EOF {
@@ -129,33 +196,148 @@ func Compare(w http.ResponseWriter, r *http.Request) {
 			logger.Log(part.FileName())
 			img, err := imageFromMultipart(part)
 			if err == nil {
-				mats, files := frlib.FindFace(&img)
-				frlib.Train(model.PCAFeatureType)
+				faces := frlib.Faces(img)
+				mats := make([]*algorithm.Matrix, 0, len(faces))
+				for _, f := range faces {
+					mats = append(mats, f.Vector())
+				}
 				if len(mats) == 0 {
-					mats = append(mats, frlib.MatrixNVectorize(&img))
+					mats = append(mats, frlib.Vectorize(img))
 				}
-				for _, m := range mats {
-					p, distance := t.Recognize(m)
-					logger.Log("Found " + p + " distance " + strconv.FormatFloat(distance, 'e', 2, 32))
-					if p != "" {
-						response.User = frlib.Items[p].User
-						response.Distance = 0.0
-						if len(files) == 0 {
-							response.Average = imageToBase64(&img)
-						} else {
-							response.Average = fileToBase64(model.GetConfig().GetTmpDirectory() + "final-faces-found.png")
+				for i, m := range mats {
+					for _, c := range t.RecognizeTopN(m, topN) {
+						cr := CandidateResponse{Face: i, Distance: c.Distance, Votes: c.Votes, Score: c.Score}
+						if item, ok := frlib.Items[c.Label]; ok {
+							cr.User = item.User
 						}
-						for _, f := range frlib.Items[p].TrainingImages {
-							response.FaceDetected = append(response.FaceDetected, fileToBase64(f))
+						for _, f := range c.TrainingImages {
+							cr.Faces = append(cr.Faces, fileToBase64(f))
 						}
-						response.PersonRecognized = "It seems to be " + response.User.ToString()
-					} else {
-						response.Error = "Not recognized."
+						response.Candidates = append(response.Candidates, cr)
+					}
+					result := t.RecognizeOpenSet(m)
+					logger.Log("Found " + result.Nearest + " distance " + strconv.FormatFloat(result.Distance, 'e', 2, 32) + " known " + strconv.FormatBool(result.Known))
+					rr := RecognitionResponse{Face: i, Known: result.Known, Distance: result.Distance, Confidence: result.Confidence}
+					if item, ok := frlib.Items[result.Nearest]; ok {
+						rr.User = item.User
+					}
+					response.Recognitions = append(response.Recognitions, rr)
+					if result.Known && (best == nil || result.Confidence > best.Confidence ||
+						(result.Confidence == best.Confidence && result.Distance < best.Distance)) {
+						best, bestImage, bestFaces = result, img, faces
 					}
 				}
 			}
 		}
 	}
+	if best == nil {
+		if len(response.Recognitions) > 0 {
+			response.Error = "Not recognized."
+		}
+		return
+	}
+	response.User = frlib.Items[best.Label].User
+	response.Distance = best.Distance
+	response.Confidence = best.Confidence
+	if len(bestFaces) == 0 {
+		response.Average = imageToBase64(&bestImage)
+	} else {
+		drawn := image.Image(model.DrawFaces(bestImage, bestFaces))
+		response.Average = imageToBase64(&drawn)
+	}
+	for _, f := range frlib.Items[best.Label].TrainingImages {
+		response.FaceDetected = append(response.FaceDetected, fileToBase64(f))
+	}
+	response.PersonRecognized = "It seems to be " + response.User.ToString()
+}
+
+type VerificationResponse struct {
+	Error     string     `json:"error,omitempty"`
+	User      model.User `json:"user"`
+	Accepted  bool       `json:"accepted"`
+	Distance  float64    `json:"distance"`
+	Threshold float64    `json:"threshold"`
+	Score     float64    `json:"score"`
+}
+
+func Verify(w http.ResponseWriter, r *http.Request) {
+	load()
+	user := &model.User{}
+	response := &VerificationResponse{}
+	images := make([]image.Image, 0)
+
+	defer func() {
+		w.WriteHeader(200)
+		sendJson(w, response)
+	}()
+
+	t, err := trainerWithMetric(r)
+	if err != nil {
+		response.Error = err.Error()
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		response.Error = err.Error()
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			response.Error = err.Error()
+			return
+		}
+		if name := part.FormName(); name != "" {
+			switch name {
+			case "first_name":
+				user.FirstName = stringFromMultipart(part)
+			case "last_name":
+				user.LastName = stringFromMultipart(part)
+			default:
+				logger.Log(part.FileName())
+				img, err := imageFromMultipart(part)
+				if err == nil {
+					images = append(images, img)
+				}
+			}
+		}
+	}
+	response.User = *user
+	if user.FirstName == "" || user.LastName == "" {
+		response.Error = "Firstname and lastname are mandatories."
+		return
+	}
+	if len(images) == 0 {
+		response.Error = "No images detected"
+		return
+	}
+
+	// each probe must show a single face, the farthest one from the person
+	// decides, the threshold is the same for all of them
+	var worst *model.Verification
+	for i, img := range images {
+		faces := frlib.Faces(img)
+		if len(faces) != 1 {
+			response.Error = "Image " + strconv.Itoa(i+1) + " must contain exactly one face, " + strconv.Itoa(len(faces)) + " detected."
+			return
+		}
+		v, err := model.Verify(t, faces[0].Vector(), user.Key())
+		if err != nil {
+			response.Error = err.Error()
+			return
+		}
+		if worst == nil || v.Distance > worst.Distance {
+			worst = v
+		}
+	}
+	logger.Log("Verify " + user.Key() + " distance " + strconv.FormatFloat(worst.Distance, 'e', 2, 32) + " accepted " + strconv.FormatBool(worst.Accepted))
+	response.Accepted = worst.Accepted
+	response.Distance = worst.Distance
+	response.Threshold = worst.Threshold
+	response.Score = worst.Score
 }
 
 func Training(w http.ResponseWriter, r *http.Request) {
@@ -216,7 +398,9 @@ func Training(w http.ResponseWriter, r *http.Request) {
 		userFace.User = *user
 		logger.Log("Adding " + userFace.GetKey())
 		userFace.DetectFacesFromImages(images)
-		frlib.AddUserFace(userFace)
+		if err := frlib.Enroll(t, userFace); err != nil {
+			response.Error = err.Error()
+		}
 	}
 
 	response.User = *user